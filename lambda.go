@@ -8,6 +8,7 @@ import (
 	l "github.com/reddragon/lambda/lang"
 	"github.com/tiborvass/uniline"
 	"os"
+	"strings"
 )
 
 func process(env *l.LangEnv, line string) {
@@ -15,9 +16,17 @@ func process(env *l.LangEnv, line string) {
 	tokens := evalResult.RemainingTokens
 	if len(evalResult.ErrStr) > 0 {
 		fmt.Printf("Error: %s\n", evalResult.ErrStr)
+		if len(evalResult.StackTrace) > 0 {
+			fmt.Printf("%s\n", evalResult.StackTrace)
+		}
 	} else {
-		if len(evalResult.ValStr) > 0 {
-			fmt.Printf("%s\n", evalResult.ValStr)
+		formatted, err := env.FormatResult(evalResult)
+		if err != nil {
+			fmt.Printf("Error: %s\n", err)
+			return
+		}
+		if len(formatted) > 0 {
+			fmt.Printf("%s\n", formatted)
 		} else {
 			fmt.Printf("\n")
 		}
@@ -28,15 +37,11 @@ func process(env *l.LangEnv, line string) {
 	}
 }
 
-func initREPL() {
-	// Setup the language environment
-	env := l.NewEnv()
-
-	prompt := "lambda> "
+func initREPL(env *l.LangEnv) {
 	scanner := uniline.DefaultScanner()
-	for scanner.Scan(prompt) {
+	for scanner.Scan(env.Prompt()) {
 		line := scanner.Text()
-		if len(line) > 0 {
+		if len(strings.TrimSpace(line)) > 0 {
 			scanner.AddToHistory(line)
 			// printType(line)
 			process(env, line)
@@ -50,7 +55,7 @@ func initREPL() {
 	}
 }
 
-func processScriptFile(scriptFilePath string) {
+func processScriptFile(env *l.LangEnv, scriptFilePath string) {
 	file, err := os.Open(scriptFilePath)
 	if err != nil {
 		panic(err)
@@ -61,22 +66,39 @@ func processScriptFile(scriptFilePath string) {
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
 		concBuf.WriteString(scanner.Text())
-		concBuf.WriteString(" ")
+		concBuf.WriteString("\n")
 	}
 
 	if scanner.Err() != nil {
 		panic(scanner.Err())
 	}
-	process(l.NewEnv(), concBuf.String())
+	process(env, concBuf.String())
 }
 
 func main() {
 	var scriptFile = flag.String("f", "", "path of the file to read from")
+	var debug = flag.Bool("debug", false, "enable the operators for debugging the interpreter")
+	var sandbox = flag.Bool("sandbox", false, "disable the operators which access the system, like read-file")
+	var noDeprecationWarnings = flag.Bool("no-deprecation-warnings", false, "do not warn when calling deprecated operators")
 	flag.Parse()
 
+	// Setup the language environment
+	env := l.NewEnv()
+	env.SetDebug(*debug)
+	env.SetSandboxed(*sandbox)
+	env.SetDeprecationWarnings(!*noDeprecationWarnings)
+
+	// The script can also be passed as the first argument, like
+	// `lambda script.el arg1 arg2`. The arguments after it are passed to it.
+	args := flag.Args()
+	if len(*scriptFile) == 0 && len(args) > 0 {
+		*scriptFile, args = args[0], args[1:]
+	}
+	env.SetArgs(args)
+
 	if len(*scriptFile) > 0 {
-		processScriptFile(*scriptFile)
+		processScriptFile(env, *scriptFile)
 	} else {
-		initREPL()
+		initREPL(env)
 	}
 }
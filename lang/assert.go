@@ -0,0 +1,263 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	assert      string = "assert"
+	assertEqual string = "assert-equal"
+	deftest     string = "deftest"
+	runTests    string = "run-tests"
+	withRedefs  string = "with-redefs"
+)
+
+// A test registered using deftest.
+type testCase struct {
+	name string
+	body []*ASTNode
+}
+
+// Returns a human readable description of how the expected and the actual
+// values differ.
+func diffStr(expected, actual Value) string {
+	expStr, actStr := expected.Str(), actual.Str()
+	if expStr == actStr {
+		return fmt.Sprintf("expected: %s (%s), actual: %s (%s)",
+			expStr, expected.getValueType(), actStr, actual.getValueType())
+	}
+
+	// The index counts characters from 0, in the contents of strings, and in how
+	// other values are printed.
+	expContents, actContents := expStr, actStr
+	expString, expIsString := expected.(stringValue)
+	actString, actIsString := actual.(stringValue)
+	if expIsString && actIsString {
+		expContents, actContents = expString.contents(), actString.contents()
+	}
+	expRunes, actRunes := []rune(expContents), []rune(actContents)
+	pos := 0
+	for pos < len(expRunes) && pos < len(actRunes) && expRunes[pos] == actRunes[pos] {
+		pos++
+	}
+	return fmt.Sprintf("expected: %s, actual: %s, first difference at index %d",
+		expStr, actStr, pos)
+}
+
+// The definitions of a name in an environment, which are restored once
+// with-redefs is done.
+type savedDefinition struct {
+	name string
+	// Either is nil if the name was not bound to an operator or a variable.
+	op  *Operator
+	val Value
+}
+
+func saveDefinition(env *LangEnv, name string) savedDefinition {
+	saved := savedDefinition{name: name}
+	saved.op = env.getOperator(name)
+	saved.val = env.getValue(name)
+	return saved
+}
+
+func (s savedDefinition) restore(env *LangEnv) {
+	env.unbind(s.name)
+	if s.op != nil {
+		env.setOperator(s.name, s.op)
+	}
+	if s.val != nil {
+		env.setValue(s.name, s.val)
+	}
+}
+
+func addAssertOperators(opMap map[string]*Operator) {
+	// Tests are run in the order in which they were first defined.
+	tests := make([]*testCase, 0)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      assert,
+			minArgCount: 1,
+			maxArgCount: 1,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				result := evalASTHelper(env, astVal.astNodes[0])
+				if result.Err != nil {
+					return result
+				}
+				if !isTruthy(result.Val) {
+					retVal.Err = errors.New(fmt.Sprintf("Assertion failed: %s",
+						StringifyAST(astVal.astNodes[0])))
+					return retVal
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      assertEqual,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				actual, expected := operands[0].Val, operands[1].Val
+				if actual.getValueType() != expected.getValueType() ||
+					actual.Str() != expected.Str() {
+					retVal.Err = errors.New(fmt.Sprintf("Assertion failed: %s",
+						diffStr(expected, actual)))
+					return retVal
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      deftest,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				nameNode := astVal.astNodes[0]
+				if !nameNode.isValue {
+					retVal.Err = errors.New(fmt.Sprintf("Test name not defined correctly."))
+					return retVal
+				}
+				nameVal, err := getValue(env, nameNode.value)
+				if err != nil || nameVal.getValueType() != varType {
+					retVal.Err = errors.New(fmt.Sprintf("Expecting test name, got %s", nameNode.value))
+					return retVal
+				}
+
+				test := &testCase{name: nameVal.Str(), body: astVal.astNodes[1:]}
+				env.mu.Lock()
+				defer env.mu.Unlock()
+				redefined := false
+				for i, t := range tests {
+					if t.name == test.name {
+						tests[i] = test
+						redefined = true
+						break
+					}
+				}
+				if !redefined {
+					tests = append(tests, test)
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      runTests,
+			minArgCount: 0,
+			maxArgCount: 0,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				env.mu.RLock()
+				tests := append([]*testCase{}, tests...)
+				env.mu.RUnlock()
+				passed := 0
+				for _, test := range tests {
+					// Every test runs in its own scope, so that definitions made by one
+					// test are not visible to the others.
+					result := evalASTs(env.newChildEnv(), test.body)
+					if result.Err != nil {
+						fmt.Fprintf(env.out, "FAIL: %s: %s\n", test.name, result.Err)
+					} else {
+						fmt.Fprintf(env.out, "PASS: %s\n", test.name)
+						passed++
+					}
+				}
+				fmt.Fprintf(env.out, "%d tests, %d passed, %d failed\n",
+					len(tests), passed, len(tests)-passed)
+				retVal.Val = newBoolValue(passed == len(tests))
+				return retVal
+			},
+		},
+	)
+
+	// Evaluates the body with the names temporarily bound to new values, like
+	// (with-redefs ((random (lambda () 0.5))) body...). The names can refer to
+	// methods, operators or variables, and their definitions are restored
+	// afterwards, even if the body results in an error.
+	addOperator(opMap,
+		&Operator{
+			symbol:      withRedefs,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				bindingsNode := astVal.astNodes[0]
+				if bindingsNode.isValue {
+					retVal.Err = errors.New(fmt.Sprintf("Missing list of bindings for %s", withRedefs))
+					return retVal
+				}
+
+				// All the values are evaluated before any of the names is rebound.
+				names := make([]string, 0, len(bindingsNode.children))
+				values := make([]Value, 0, len(bindingsNode.children))
+				for _, binding := range bindingsNode.children {
+					if binding.isValue || len(binding.children) != 2 || !binding.children[0].isValue {
+						retVal.Err = errors.New(fmt.Sprintf(
+							"Bindings for %s should be of the format `(name value)`.", withRedefs))
+						return retVal
+					}
+					name := binding.children[0].value
+					// Operators like + are not variable names, but can be redefined too.
+					nameVal, err := getValue(env, name)
+					if env.getOperator(name) == nil && (err != nil || nameVal.getValueType() != varType) {
+						retVal.Err = errors.New(fmt.Sprintf("Expected a name to redefine, got %s", name))
+						return retVal
+					}
+					if retVal.Err = checkNotSpecialForm(name); retVal.Err != nil {
+						return retVal
+					}
+					result := evalASTHelper(env, binding.children[1])
+					if result.Err != nil {
+						return result
+					}
+					names = append(names, name)
+					values = append(values, result.Val)
+				}
+
+				saved := make([]savedDefinition, 0, len(names))
+				defer func() {
+					// Restored in reverse, in case a name was rebound more than once.
+					for i := len(saved) - 1; i >= 0; i-- {
+						saved[i].restore(env)
+					}
+				}()
+				for i, name := range names {
+					saved = append(saved, saveDefinition(env, name))
+					env.unbind(name)
+					bindParam(env, env, name, values[i])
+				}
+
+				retVal = evalASTs(env, astVal.astNodes[1:])
+				// The result might refer to one of the names, so it has to be looked up
+				// before they are restored.
+				if retVal.Err == nil {
+					if val, err := getVarValue(env, retVal.Val); err == nil {
+						retVal.Val = val
+					}
+				}
+				return retVal
+			},
+		},
+	)
+}
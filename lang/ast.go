@@ -3,7 +3,9 @@ package lang
 import (
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
+	"unicode"
 )
 
 // An AstNode either has a value, or has children.
@@ -12,37 +14,128 @@ type ASTNode struct {
 	isValue  bool
 	value    string
 	children []*ASTNode
+	// Where the node starts in the source it was parsed from. Nodes built by
+	// the interpreter, like macro expansions, have the zero position.
+	pos position
+}
+
+// A position in the source, as the line and the column, counted from 1, and
+// the offset, counted from 0, all in characters.
+type position struct {
+	line, col, offset int
+}
+
+// Returns the position as line:column, or an empty string if it is unknown.
+func (p position) String() string {
+	if p.line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d:%d", p.line, p.col)
 }
 
 const (
 	openBracket   string = "("
 	closedBracket string = ")"
+	// Opens an anonymous function, like #(+ %1 %2).
+	anonFnBracket string = "#("
+	// Refers to an argument of an anonymous function. It is either followed by
+	// the position of the argument, or stands for the first one.
+	anonFnArg string = "%"
 )
 
 func errStr(expected, found string) error {
 	return errors.New(fmt.Sprintf("Expected %s, got %s.", expected, found))
 }
 
-// This method gets you the AST of a given expression.
-func getAST(exp string) (*ASTNode, []string, error) {
-	tokens := tokenize(exp)
+// This method gets you the AST of a given expression, along with the tokens
+// after it, and their positions.
+func getAST(exp string) (*ASTNode, []string, []position, error) {
+	tokens, positions := tokenize(exp)
 	if len(tokens) == 0 {
-		return nil, nil, errors.New("Nothing to evaluate")
+		return nil, nil, nil, errors.New("Nothing to evaluate")
 	}
-	return buildAST(tokens)
+	node, rest, err := buildAST(tokens, positions)
+	return node, rest, positions[len(positions)-len(rest):], err
 }
 
-func tokenize(exp string) []string {
-	return strings.Fields(
-		strings.Replace(strings.Replace(exp, "(", " ( ", -1), ")", " ) ", -1),
-	)
+// Splits an expression into brackets and the tokens between them, and returns
+// them along with where they start. String literals are kept as single tokens,
+// so that they can contain whitespace and brackets. Within a string, a
+// backslash escapes the character after it.
+func tokenize(exp string) ([]string, []position) {
+	tokens := make([]string, 0)
+	positions := make([]position, 0)
+	var token []rune
+	var start position
+	flush := func() {
+		if len(token) > 0 {
+			tokens = append(tokens, string(token))
+			positions = append(positions, start)
+			token = nil
+		}
+	}
+
+	runes := []rune(exp)
+	// The position of every character, so that the positions of tokens can be
+	// looked up by their offset.
+	at := make([]position, len(runes))
+	line, col := 1, 1
+	for i, r := range runes {
+		at[i] = position{line, col, i}
+		if r == '\n' {
+			line, col = line+1, 1
+		} else {
+			col++
+		}
+	}
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if len(token) == 0 {
+			start = at[i]
+		}
+		switch {
+		case string(token) == "#" && r == '\\' && i+1 < len(runes):
+			// The character in a character literal is never a separator.
+			token = append(token, r, runes[i+1])
+			i++
+		case unicode.IsSpace(r):
+			flush()
+		case r == '(' && string(token) == "#":
+			token = nil
+			tokens = append(tokens, anonFnBracket)
+			positions = append(positions, start)
+		case r == '(' || r == ')':
+			flush()
+			tokens = append(tokens, string(r))
+			positions = append(positions, at[i])
+		case (r == '"' || r == '\'') && len(token) == 0:
+			token = append(token, r)
+			for i++; i < len(runes); i++ {
+				token = append(token, runes[i])
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+					token = append(token, runes[i])
+				} else if runes[i] == r {
+					break
+				}
+			}
+		default:
+			token = append(token, r)
+		}
+	}
+	flush()
+	return tokens, positions
 }
 
 // This method does the heavy-lifting of building an AST, once an expression
-// is tokenized.
-func buildAST(tokens []string) (*ASTNode, []string, error) {
+// is tokenized. The tokens are always the last ones of those which were
+// tokenized, and positions are those of all of them.
+func buildAST(tokens []string, positions []position) (*ASTNode, []string, error) {
 	var token = ""
 	tokensLen := len(tokens)
+	pos := func(tokens []string) position {
+		return positions[len(positions)-len(tokens)]
+	}
 	// If it is an empty list of tokens, the AST is a nil node
 	if tokensLen == 0 {
 		return nil, tokens, nil
@@ -50,7 +143,7 @@ func buildAST(tokens []string) (*ASTNode, []string, error) {
 		// TODO Check that this token is a value.
 		//      A proxy for now is checking if this is not a ( or )
 		token, tokens = pop(tokens)
-		if token == openBracket || token == closedBracket {
+		if token == openBracket || token == closedBracket || token == anonFnBracket {
 			return nil, tokens, errStr("value", token)
 		}
 
@@ -58,28 +151,33 @@ func buildAST(tokens []string) (*ASTNode, []string, error) {
 		node.isValue = true
 		node.value = token
 		node.children = nil
+		node.pos = positions[len(positions)-1]
 		return node, tokens, nil
 	} else {
+		start := pos(tokens)
 		token, tokens = pop(tokens)
-		if token != openBracket {
+		if token != openBracket && token != anonFnBracket {
 			return nil, tokens, errStr(openBracket, token)
 		}
+		isAnonFn := token == anonFnBracket
 
 		node := new(ASTNode)
 		node.isValue = false
 		// Create a slice with 0 length initially.
 		node.children = make([]*ASTNode, 0)
+		node.pos = start
 
 		tokensLen = len(tokens)
 		for len(tokens) != 0 && tokens[0] != closedBracket {
 			var childNode *ASTNode = nil
 			var err error = nil
 			// If this is not an open brace, this is a single value
-			if tokens[0] != openBracket {
-				token, tokens = pop(tokens)
-				childNode, _, err = buildAST([]string{token})
+			if tokens[0] != openBracket && tokens[0] != anonFnBracket {
+				childNode = newValueNode(tokens[0])
+				childNode.pos = pos(tokens)
+				tokens = tokens[1:]
 			} else {
-				childNode, tokens, err = buildAST(tokens)
+				childNode, tokens, err = buildAST(tokens, positions)
 			}
 			if err != nil {
 				return nil, tokens, err
@@ -94,10 +192,62 @@ func buildAST(tokens []string) (*ASTNode, []string, error) {
 		if token != closedBracket {
 			return nil, tokens, errStr(token, closedBracket)
 		}
+		if isAnonFn {
+			return expandAnonFn(node), tokens, nil
+		}
 		return node, tokens, nil
 	}
 }
 
+// Returns the position of the argument which the token refers to in an
+// anonymous function, or 0 if it does not refer to one.
+func anonFnArgPos(token string) int {
+	if token == anonFnArg {
+		return 1
+	}
+	if !strings.HasPrefix(token, anonFnArg) {
+		return 0
+	}
+	pos, err := strconv.Atoi(token[len(anonFnArg):])
+	if err != nil || pos < 1 {
+		return 0
+	}
+	return pos
+}
+
+// Renames the arguments referred to in the node, in place, and returns the
+// highest position amongst them.
+func renameAnonFnArgs(node *ASTNode) int {
+	if node.isValue {
+		pos := anonFnArgPos(node.value)
+		if pos > 0 {
+			node.value = fmt.Sprintf("%sarg%d", anonFnArg, pos)
+		}
+		return pos
+	}
+	maxPos := 0
+	for _, child := range node.children {
+		if pos := renameAnonFnArgs(child); pos > maxPos {
+			maxPos = pos
+		}
+	}
+	return maxPos
+}
+
+// Expands the body of an anonymous function into a lambda, which takes as
+// many arguments as the highest one referred to in the body. The arguments are
+// renamed to %arg1, %arg2 and so on, since they are not valid variable names.
+func expandAnonFn(body *ASTNode) *ASTNode {
+	arity := renameAnonFnArgs(body)
+	params := make([]*ASTNode, arity)
+	for i := range params {
+		params[i] = newValueNode(fmt.Sprintf("%sarg%d", anonFnArg, i+1))
+	}
+	node := newListNode([]*ASTNode{newValueNode(lambda), newListNode(params), body})
+	node.pos = body.pos
+	return node
+}
+
 func StringifyAST(node *ASTNode) string {
 	if node == nil {
 		return ""
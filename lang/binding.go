@@ -0,0 +1,215 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	let     string = "let"
+	letStar string = "let*"
+	ifLet   string = "if-let"
+	whenLet string = "when-let"
+	// Separates the rest pattern in a list pattern, like (a . rest).
+	restMarker string = "."
+)
+
+// Binds val to the pattern in env. A pattern is either a variable name, or a
+// list of patterns which destructures a list value of the same length. The
+// last pattern in a list can be preceded by a ., in which case it is bound to
+// the list of the remaining values.
+func bindPattern(env *LangEnv, pattern *ASTNode, val Value) error {
+	if pattern.isValue {
+		nameVal, err := getValue(env, pattern.value)
+		if err != nil || nameVal.getValueType() != varType {
+			return errors.New(fmt.Sprintf("Expected a variable name to bind to, got %s", pattern.value))
+		}
+		if err := checkNotSpecialForm(pattern.value); err != nil {
+			return err
+		}
+		bindParam(env, env, pattern.value, val)
+		return nil
+	}
+
+	listVal, ok := consToList(val).(listValue)
+	if !ok {
+		return errors.New(fmt.Sprintf("Cannot destructure %s into %s, as it is not a list",
+			val.Str(), StringifyAST(pattern)))
+	}
+	patterns := pattern.children
+	var restPattern *ASTNode
+	if n := len(patterns); n >= 2 && patterns[n-2].isValue && patterns[n-2].value == restMarker {
+		patterns, restPattern = patterns[:n-2], patterns[n-1]
+	}
+	if len(listVal.values) < len(patterns) || (restPattern == nil && len(listVal.values) != len(patterns)) {
+		return errors.New(fmt.Sprintf("Cannot destructure %s into %s, as their lengths differ",
+			val.Str(), StringifyAST(pattern)))
+	}
+
+	for i, p := range patterns {
+		if err := bindPattern(env, p, listVal.values[i]); err != nil {
+			return err
+		}
+	}
+	if restPattern != nil {
+		rest := make([]Value, len(listVal.values)-len(patterns))
+		copy(rest, listVal.values[len(patterns):])
+		return bindPattern(env, restPattern, newListValue(rest))
+	}
+	return nil
+}
+
+// Returns an error if the pattern cannot be bound to by bindPattern, whatever
+// the value.
+func checkPattern(env *LangEnv, pattern *ASTNode) error {
+	if pattern.isValue {
+		nameVal, err := getValue(env, pattern.value)
+		if err != nil || nameVal.getValueType() != varType || pattern.value == restMarker {
+			return errors.New(fmt.Sprintf("Expected a variable name to bind to, got %s", pattern.value))
+		}
+		return checkNotSpecialForm(pattern.value)
+	}
+	patterns := pattern.children
+	if n := len(patterns); n >= 2 && patterns[n-2].isValue && patterns[n-2].value == restMarker {
+		patterns = append(patterns[:n-2:n-2], patterns[n-1])
+	}
+	for _, p := range patterns {
+		if err := checkPattern(env, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the handler for let, or let* if sequential is set. For let, all the
+// values are evaluated before any of them is bound, whereas for let* every
+// value can refer to the earlier bindings.
+func letHandler(symbol string, sequential bool) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		astVal, _ := operands[0].Val.(astValue)
+		bindingsNode := astVal.astNodes[0]
+		if bindingsNode.isValue {
+			retVal.Err = errors.New(fmt.Sprintf("Missing list of bindings for %s", symbol))
+			return retVal
+		}
+
+		newEnv := env.newChildEnv()
+		evalEnv := env
+		if sequential {
+			evalEnv = newEnv
+		}
+		patterns := make([]*ASTNode, 0, len(bindingsNode.children))
+		values := make([]Value, 0, len(bindingsNode.children))
+		for _, binding := range bindingsNode.children {
+			if binding.isValue || len(binding.children) != 2 {
+				retVal.Err = errors.New(fmt.Sprintf(
+					"Bindings for %s should be of the format `(pattern value)`.", symbol))
+				return retVal
+			}
+			result := evalASTHelper(evalEnv, binding.children[1])
+			if result.Err != nil {
+				return result
+			}
+			if sequential {
+				retVal.Err = bindPattern(newEnv, binding.children[0], result.Val)
+				if retVal.Err != nil {
+					return retVal
+				}
+				continue
+			}
+			patterns = append(patterns, binding.children[0])
+			values = append(values, result.Val)
+		}
+
+		if !sequential {
+			for i, p := range patterns {
+				retVal.Err = bindPattern(newEnv, p, values[i])
+				if retVal.Err != nil {
+					return retVal
+				}
+			}
+		}
+		return evalASTs(newEnv, astVal.astNodes[1:])
+	}
+}
+
+// Returns the handler for if-let, or when-let if isWhen is set. The binding is
+// of the form `(pattern value)`, and is only in scope of the body, which is
+// evaluated when the value is truthy. Otherwise if-let evaluates its else
+// branch, without the binding, and when-let results in nil.
+func conditionalLetHandler(symbol string, isWhen bool) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		astVal, _ := operands[0].Val.(astValue)
+		binding := astVal.astNodes[0]
+		if binding.isValue || len(binding.children) != 2 {
+			retVal.Err = errors.New(fmt.Sprintf(
+				"The binding for %s should be of the format `(pattern value)`.", symbol))
+			return retVal
+		}
+		result := evalASTHelper(env, binding.children[1])
+		if result.Err != nil {
+			return result
+		}
+		if !isTruthy(result.Val) {
+			if isWhen {
+				retVal.Val = newNilValue()
+				return retVal
+			}
+			return evalASTs(env, astVal.astNodes[2:])
+		}
+
+		newEnv := env.newChildEnv()
+		retVal.Err = bindPattern(newEnv, binding.children[0], result.Val)
+		if retVal.Err != nil {
+			return retVal
+		}
+		if isWhen {
+			return evalASTs(newEnv, astVal.astNodes[1:])
+		}
+		return evalASTHelper(newEnv, astVal.astNodes[1])
+	}
+}
+
+func addBindingOperators(opMap map[string]*Operator) {
+	addOperator(opMap,
+		&Operator{
+			symbol:      let,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler:     letHandler(let, false),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      letStar,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler:     letHandler(letStar, true),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      ifLet,
+			minArgCount: 2,
+			maxArgCount: 3,
+			passRawAST:  true,
+			handler:     conditionalLetHandler(ifLet, false),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      whenLet,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler:     conditionalLetHandler(whenLet, true),
+		},
+	)
+}
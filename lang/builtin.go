@@ -4,18 +4,57 @@ package lang
 func builtinOperators() map[string]*Operator {
 	opMap := make(map[string]*Operator)
 	addBuiltinOperators(opMap)
+	addNumberOperators(opMap)
+	addAssertOperators(opMap)
+	addDebugOperators(opMap)
+	addDocOperators(opMap)
+	addMacroOperators(opMap)
+	addListOperators(opMap)
+	addDiffOperators(opMap)
+	addVectorOperators(opMap)
+	addBindingOperators(opMap)
+	addMatchOperators(opMap)
+	addValuesOperators(opMap)
+	addStringOperators(opMap)
+	addCharOperators(opMap)
+	addMapOperators(opMap)
+	addSetOperators(opMap)
+	addErrorOperators(opMap)
+	addPromiseOperators(opMap)
+	addConcurrencyOperators(opMap)
+	addCycleOperators(opMap)
+	addStreamOperators(opMap)
+	addFunctionOperators(opMap)
+	addParameterOperators(opMap)
+	addRecurOperators(opMap)
+	addIOOperators(opMap)
+	addSystemOperators(opMap)
+	addRandomOperators(opMap)
+	addEncodingOperators(opMap)
+	addSerializeOperators(opMap)
+	addEvalOperators(opMap)
+	addEnumOperators(opMap)
+	addRecordOperators(opMap)
+	addSizeOperators(opMap)
+	addDeprecationOperators(opMap)
+	addREPLOperators(opMap)
 	return opMap
 }
 
 func builtinTypes() []Value {
 	types := make([]Value, 0)
 	// Append the values in the order of prefence, i.e, more specific types
-	// should be first.
+	// should be first. Numbers get the narrowest type which can hold them, so
+	// int has to come before bigInt, and both before float.
 	types = append(types, new(stringValue))
 	types = append(types, new(intValue))
 	types = append(types, new(bigIntValue))
 	types = append(types, new(floatValue))
+	types = append(types, new(bigFloatValue))
 	types = append(types, new(boolValue))
+	types = append(types, new(nilValue))
+	types = append(types, new(keywordValue))
+	types = append(types, new(charValue))
 	types = append(types, new(varValue))
 	return types
 }
@@ -0,0 +1,84 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"unicode"
+)
+
+const (
+	isAlpha      string = "alpha?"
+	isDigit      string = "digit?"
+	isWhitespace string = "whitespace?"
+	isUpper      string = "upper?"
+	isLower      string = "lower?"
+	charUpcase   string = "char-upcase"
+	charDowncase string = "char-downcase"
+)
+
+// Returns the character passed to the operator, or an error if it is not a
+// character. Other values are never converted to characters.
+func charOperand(symbol string, operand Atom) (rune, error) {
+	charVal, ok := operand.Val.(charValue)
+	if !ok {
+		return 0, errors.New(fmt.Sprintf("For %s, expected %s to be a character",
+			symbol, operand.Val.Str()))
+	}
+	return charVal.value, nil
+}
+
+func addCharOperators(opMap map[string]*Operator) {
+	// Predicates on characters, based on their Unicode categories.
+	predicates := map[string]func(rune) bool{
+		isAlpha:      unicode.IsLetter,
+		isDigit:      unicode.IsDigit,
+		isWhitespace: unicode.IsSpace,
+		isUpper:      unicode.IsUpper,
+		isLower:      unicode.IsLower,
+	}
+	for symbol, predicate := range predicates {
+		symbol, predicate := symbol, predicate
+		addOperator(opMap,
+			&Operator{
+				symbol:      symbol,
+				minArgCount: 1,
+				maxArgCount: 1,
+				handler: func(env *LangEnv, operands []Atom) Atom {
+					var retVal Atom
+					r, err := charOperand(symbol, operands[0])
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					retVal.Val = newBoolValue(predicate(r))
+					return retVal
+				},
+			},
+		)
+	}
+
+	conversions := map[string]func(rune) rune{
+		charUpcase:   unicode.ToUpper,
+		charDowncase: unicode.ToLower,
+	}
+	for symbol, conversion := range conversions {
+		symbol, conversion := symbol, conversion
+		addOperator(opMap,
+			&Operator{
+				symbol:      symbol,
+				minArgCount: 1,
+				maxArgCount: 1,
+				handler: func(env *LangEnv, operands []Atom) Atom {
+					var retVal Atom
+					r, err := charOperand(symbol, operands[0])
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					retVal.Val = newCharValue(conversion(r))
+					return retVal
+				},
+			},
+		)
+	}
+}
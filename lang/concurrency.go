@@ -0,0 +1,434 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+const (
+	spawn         string = "spawn"
+	await         string = "await"
+	makeChannel   string = "make-channel"
+	send          string = "send!"
+	receive       string = "receive!"
+	closeChannel  string = "close-channel!"
+	channelToList string = "channel->list"
+	atomOp        string = "atom"
+	deref         string = "deref"
+	swap          string = "swap!"
+	pmap          string = "pmap"
+)
+
+// Starts evaluating the expression in a new goroutine, and returns the future
+// of its result.
+//
+// The task is evaluated in a copy of the environment it was spawned in, so
+// definitions made by the task are not visible outside of it, and definitions
+// made afterwards are not visible to it, except through the methods it calls.
+// The variables and operators of all the environments are guarded by a lock,
+// so tasks can safely call methods which refer to them while they are being
+// redefined. Values themselves are immutable, apart from promises and streams,
+// which are guarded as well.
+//
+// The input and output are shared with the task, so the output of concurrent
+// tasks can be interleaved, and the writer set using SetOutput has to be safe
+// for concurrent use.
+func spawnTask(env *LangEnv, node *ASTNode) futureValue {
+	f := &future{done: make(chan struct{})}
+	taskEnv := env.newTaskEnv()
+	go func() {
+		defer close(f.done)
+		f.result = evalASTHelper(taskEnv, node)
+	}()
+	return futureValue{f}
+}
+
+// Blocks until the task is done, and returns its result.
+func (v futureValue) await() Atom {
+	<-v.f.done
+	return v.f.result
+}
+
+// Sends the value, blocking until it is received, or until there is room for it
+// in the buffer of the channel.
+func (c *channel) send(v Value) (err error) {
+	// Sending to a closed channel panics, even if it was closed while blocked.
+	defer func() {
+		if recover() != nil {
+			err = errors.New(fmt.Sprintf("Cannot %s to a closed channel", send))
+		}
+	}()
+	c.values <- v
+	return nil
+}
+
+// Returns the next value sent to the channel, blocking until there is one. Once
+// the channel is closed and the values sent before have been received, it
+// returns false.
+func (c *channel) receive() (Value, bool) {
+	v, ok := <-c.values
+	return v, ok
+}
+
+func (c *channel) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return errors.New(fmt.Sprintf("For %s, the channel is already closed", closeChannel))
+	}
+	c.closed = true
+	close(c.values)
+	return nil
+}
+
+func channelOperand(symbol string, operand Atom) (*channel, error) {
+	chanVal, ok := operand.Val.(channelValue)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("For %s, expected %s to be a channel", symbol, operand.Val.Str()))
+	}
+	return chanVal.c, nil
+}
+
+// Returns the current value of the atom, along with its version.
+func (a *atomState) get() (Value, uint64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.value, a.version
+}
+
+// Sets the value of the atom, unless it was changed since the given version.
+func (a *atomState) compareAndSet(version uint64, value Value) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.version != version {
+		return false
+	}
+	a.value = value
+	a.version++
+	return true
+}
+
+// Replaces the value of the atom with the result of calling fn on it, and the
+// extra arguments. The atom is not locked while fn is called, so that fn can
+// refer to it. If another task changes the value in the meantime, fn is called
+// again with the new value, so it should not have side effects.
+func (a *atomState) swap(env *LangEnv, fn Value, args []Value) Atom {
+	for {
+		current, version := a.get()
+		result := callOperator(env, fn, append([]Value{current}, args...))
+		if result.Err == nil {
+			result.Err = checkSingleValue(swap, result.Val)
+		}
+		if result.Err != nil {
+			result.Val = nil
+			return result
+		}
+		if result.Val.getValueType() == varType {
+			if result.Val, result.Err = getVarValue(env, result.Val); result.Err != nil {
+				return result
+			}
+		}
+		if a.compareAndSet(version, result.Val) {
+			return result
+		}
+	}
+}
+
+func atomOperand(symbol string, operand Atom) (*atomState, error) {
+	atomVal, ok := operand.Val.(atomValue)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("For %s, expected %s to be an atom", symbol, operand.Val.Str()))
+	}
+	return atomVal.a, nil
+}
+
+// Calls fn on every value, using the given number of goroutines, and returns
+// the results in the same order as the values. Each goroutine evaluates the
+// calls in its own task environment, which lambdas take their random generator
+// and tracer from. If any of the calls fails, the error of
+// the earliest one is returned.
+func parallelMap(env *LangEnv, fn Value, values []Value, workers int) Atom {
+	var retVal Atom
+	results := make([]Atom, len(values))
+	indices := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		taskEnv := env.newTaskEnv()
+		go func() {
+			defer wg.Done()
+			for i := range indices {
+				results[i] = callOperator(taskEnv, fn, []Value{values[i]})
+				if results[i].Err == nil && results[i].Val.getValueType() == varType {
+					results[i].Val, results[i].Err = getVarValue(taskEnv, results[i].Val)
+				}
+				if results[i].Err == nil {
+					results[i].Err = checkSingleValue(pmap, results[i].Val)
+				}
+			}
+		}()
+	}
+	for i := range values {
+		indices <- i
+	}
+	close(indices)
+	wg.Wait()
+
+	mapped := make([]Value, len(values))
+	for i, result := range results {
+		if result.Err != nil {
+			return result
+		}
+		mapped[i] = result.Val
+	}
+	retVal.Val = newListValue(mapped)
+	return retVal
+}
+
+func addConcurrencyOperators(opMap map[string]*Operator) {
+	addOperator(opMap,
+		&Operator{
+			symbol:      spawn,
+			minArgCount: 1,
+			maxArgCount: 1,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				retVal.Val = spawnTask(env, astVal.astNodes[0])
+				return retVal
+			},
+		},
+	)
+
+	// Returns the result of the task, waiting for it to be done. If the task
+	// resulted in an error, so does await. Other values are returned as they
+	// are.
+	addOperator(opMap,
+		&Operator{
+			symbol:      await,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				if futureVal, ok := operands[0].Val.(futureValue); ok {
+					return futureVal.await()
+				}
+				return operands[0]
+			},
+		},
+	)
+
+	// Returns a new channel, which can buffer the given number of values. By
+	// default it has no buffer, so every send! waits for a receive!.
+	addOperator(opMap,
+		&Operator{
+			symbol:      makeChannel,
+			minArgCount: 0,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				capacity := int64(0)
+				if len(operands) == 1 {
+					n, ok := operands[0].Val.(intValue)
+					if !ok || n.value < 0 {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative integer",
+							makeChannel, operands[0].Val.Str()))
+						return retVal
+					}
+					capacity = n.value
+				}
+				retVal.Val = channelValue{&channel{values: make(chan Value, capacity)}}
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      send,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				c, err := channelOperand(send, operands[0])
+				if err == nil {
+					err = c.send(operands[1].Val)
+				}
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	// Returns the next value sent to the channel, waiting for one to be sent.
+	// Once the channel is closed, and every value sent to it was received, it
+	// results in nil.
+	addOperator(opMap,
+		&Operator{
+			symbol:      receive,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				c, err := channelOperand(receive, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				v, ok := c.receive()
+				if !ok {
+					v = newNilValue()
+				}
+				retVal.Val = v
+				return retVal
+			},
+		},
+	)
+
+	// Closes the channel, after which nothing can be sent to it.
+	addOperator(opMap,
+		&Operator{
+			symbol:      closeChannel,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				c, err := channelOperand(closeChannel, operands[0])
+				if err == nil {
+					err = c.close()
+				}
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	// Receives every value sent to the channel until it is closed, and returns
+	// the list of them, so that they can be iterated over using for/list.
+	addOperator(opMap,
+		&Operator{
+			symbol:      channelToList,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				c, err := channelOperand(channelToList, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				values := make([]Value, 0)
+				for v, ok := c.receive(); ok; v, ok = c.receive() {
+					values = append(values, v)
+				}
+				retVal.Val = newListValue(values)
+				return retVal
+			},
+		},
+	)
+
+	// Returns an atom holding the value.
+	addOperator(opMap,
+		&Operator{
+			symbol:      atomOp,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = atomValue{&atomState{value: operands[0].Val}}
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      deref,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				a, err := atomOperand(deref, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val, _ = a.get()
+				return retVal
+			},
+		},
+	)
+
+	// Changes the value of the atom to the result of the function, like
+	// (swap! counter + 1), and returns the new value.
+	addOperator(opMap,
+		&Operator{
+			symbol:      swap,
+			minArgCount: 2,
+			maxArgCount: 100,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				a, err := atomOperand(swap, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				args := make([]Value, len(operands)-2)
+				for i, o := range operands[2:] {
+					args[i] = o.Val
+				}
+				return a.swap(env, operands[1].Val, args)
+			},
+		},
+	)
+
+	// Returns the list of the results of calling the function on every element
+	// of the list, like (pmap fib (list 20 25 30)). The calls are evaluated in
+	// parallel, by as many goroutines as can run at once, unless another number
+	// is passed.
+	addOperator(opMap,
+		&Operator{
+			symbol:      pmap,
+			minArgCount: 2,
+			maxArgCount: 3,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				if resolveOperator(env, operands[0].Val) == nil {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator",
+						pmap, operands[0].Val.Str()))
+					return retVal
+				}
+				listVal, ok := operands[1].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						pmap, operands[1].Val.Str()))
+					return retVal
+				}
+				workers := runtime.GOMAXPROCS(0)
+				if len(operands) == 3 {
+					n, ok := operands[2].Val.(intValue)
+					if !ok || n.value < 1 {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a positive integer",
+							pmap, operands[2].Val.Str()))
+						return retVal
+					}
+					workers = int(n.value)
+				}
+				if workers > len(listVal.values) {
+					workers = len(listVal.values)
+				}
+				return parallelMap(env, operands[0].Val, listVal.values, workers)
+			},
+		},
+	)
+}
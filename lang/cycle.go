@@ -0,0 +1,129 @@
+package lang
+
+const (
+	hasCycle string = "has-cycle?"
+	// Printed in place of a value which contains itself, where it would be
+	// printed again.
+	circularMarker string = "#circular"
+)
+
+// Implemented by the values which contain other values. Lists and the like
+// cannot contain themselves, but atoms, promises and futures can refer to a
+// value which contains them. str is passed the ones which are being printed,
+// so that such a value is printed as #circular the second time it is reached,
+// instead of forever.
+type containerValue interface {
+	str(visiting map[interface{}]bool) string
+}
+
+func strOf(v Value, visiting map[interface{}]bool) string {
+	if c, ok := v.(containerValue); ok {
+		return c.str(visiting)
+	}
+	return v.Str()
+}
+
+func strsOf(values []Value, visiting map[interface{}]bool) []string {
+	strs := make([]string, len(values))
+	for i, v := range values {
+		strs[i] = strOf(v, visiting)
+	}
+	return strs
+}
+
+// Prints the contents of the cell, which is the shared state of an atom, a
+// promise or a future, unless it is already being printed.
+func strOfCell(cell interface{}, visiting map[interface{}]bool, print func(map[interface{}]bool) string) string {
+	if visiting[cell] {
+		return circularMarker
+	}
+	if visiting == nil {
+		visiting = make(map[interface{}]bool)
+	}
+	visiting[cell] = true
+	defer delete(visiting, cell)
+	return print(visiting)
+}
+
+// Returns the shared state of the value if it can change after it has been
+// created, like that of an atom, along with the values it contains.
+func containedValues(v Value) (interface{}, []Value) {
+	switch val := consToList(v).(type) {
+	case listValue:
+		return nil, val.values
+	case vectorValue:
+		return nil, val.values
+	case multipleValues:
+		return nil, val.values
+	case recordValue:
+		return nil, val.values
+	case setValue:
+		return nil, val.values()
+	case mapValue:
+		values := make([]Value, 0, 2*len(val.order))
+		for _, k := range val.order {
+			values = append(values, val.entries[k].key, val.entries[k].value)
+		}
+		return nil, values
+	case streamValue:
+		return nil, []Value{val.head, val.tail}
+	case atomValue:
+		value, _ := val.a.get()
+		return val.a, []Value{value}
+	case promiseValue:
+		val.p.mu.Lock()
+		defer val.p.mu.Unlock()
+		if val.p.forced {
+			return val.p, []Value{val.p.value}
+		}
+		return val.p, nil
+	case futureValue:
+		select {
+		case <-val.f.done:
+			if val.f.result.Err == nil {
+				return val.f, []Value{val.f.result.Val}
+			}
+		default:
+		}
+		return val.f, nil
+	}
+	return nil, nil
+}
+
+// Returns whether the value contains itself, through one of the cells on the
+// path from it. Values which are reached in more than one way, without
+// containing themselves, are not cycles.
+func containsCycle(v Value, path map[interface{}]bool) bool {
+	cell, values := containedValues(v)
+	if cell != nil {
+		if path[cell] {
+			return true
+		}
+		path[cell] = true
+		defer delete(path, cell)
+	}
+	for _, elem := range values {
+		if containsCycle(elem, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func addCycleOperators(opMap map[string]*Operator) {
+	// Returns whether the value contains itself, like an atom whose value is a
+	// list containing the atom. Such values are printed with #circular where
+	// they would be repeated.
+	addOperator(opMap,
+		&Operator{
+			symbol:      hasCycle,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = newBoolValue(containsCycle(operands[0].Val, make(map[interface{}]bool)))
+				return retVal
+			},
+		},
+	)
+}
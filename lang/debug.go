@@ -0,0 +1,246 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	dumpEnv   string = "dump-env"
+	trace     string = "trace"
+	traceFn   string = "trace-fn"
+	untraceFn string = "untrace-fn"
+	breakOp   string = "break"
+)
+
+// The commands understood by the REPL entered by break, besides expressions.
+const (
+	breakPrompt     string = "break> "
+	continueCommand string = ":continue"
+	varsCommand     string = ":vars"
+)
+
+// Prints the expressions being evaluated, and their results, indented by
+// how deeply nested they are.
+type tracer struct {
+	depth int
+	// Whether every evaluation step is being traced.
+	tracingAll bool
+	// Methods whose calls are being traced.
+	tracedMethods map[string]bool
+}
+
+func newTracer() *tracer {
+	t := new(tracer)
+	t.tracedMethods = make(map[string]bool)
+	return t
+}
+
+func (t *tracer) indent() string {
+	return strings.Repeat("  ", t.depth)
+}
+
+func (t *tracer) enter(env *LangEnv, exp string) {
+	fmt.Fprintf(env.out, "%s%s\n", t.indent(), exp)
+	t.depth++
+}
+
+func (t *tracer) exit(env *LangEnv, result Atom) {
+	t.depth--
+	if result.Err != nil {
+		fmt.Fprintf(env.out, "%s=> Error: %s\n", t.indent(), result.Err)
+		return
+	}
+	val := result.Val
+	if val.getValueType() == varType {
+		if resolved, err := getVarValue(env, val); err == nil {
+			val = resolved
+		}
+	}
+	fmt.Fprintf(env.out, "%s=> %s\n", t.indent(), val.Str())
+}
+
+func debugOnlyError(symbol string) error {
+	return errors.New(fmt.Sprintf("%s is only available when debugging is enabled.", symbol))
+}
+
+// Prints everything defined in the environment, in sorted order.
+func printEnv(env *LangEnv) {
+	fmt.Fprintf(env.out, "Scope depth: %d\n", env.recursionDepth)
+
+	varMap, opMap := env.bindings()
+	varNames := make([]string, 0, len(varMap))
+	for k := range varMap {
+		varNames = append(varNames, k)
+	}
+	sort.Strings(varNames)
+	fmt.Fprintf(env.out, "Variables (%d):\n", len(varNames))
+	for _, k := range varNames {
+		v := varMap[k]
+		fmt.Fprintf(env.out, "  %s = %s (%s)\n", k, v.Str(), v.getValueType())
+	}
+
+	opNames := make([]string, 0, len(opMap))
+	for k := range opMap {
+		opNames = append(opNames, k)
+	}
+	sort.Strings(opNames)
+	fmt.Fprintf(env.out, "Operators (%d):\n", len(opNames))
+	for _, k := range opNames {
+		op := opMap[k]
+		// Operators passed as method arguments are bound under the parameter name.
+		fmt.Fprintf(env.out, "  %s -> %s, args: %d..%d, rawAST: %t, resolveVars: %t\n",
+			k, op.symbol, op.minArgCount, op.maxArgCount, op.passRawAST, !op.doNotResolveVars)
+	}
+}
+
+// Reads expressions from the input, and evaluates them in env, printing their
+// results, until the continue command or the end of the input. Evaluation is
+// suspended meanwhile, and resumes when it returns.
+func breakREPL(env *LangEnv, label string) {
+	fmt.Fprintf(env.out, "Break%s. Enter %s to resume, or %s to list the variables in scope.\n",
+		label, continueCommand, varsCommand)
+	for {
+		fmt.Fprint(env.out, breakPrompt)
+		line, err := env.in.ReadString('\n')
+		line = strings.TrimSpace(line)
+		switch line {
+		case continueCommand:
+			return
+		case varsCommand:
+			varMap, _ := env.bindings()
+			names := make([]string, 0, len(varMap))
+			for k := range varMap {
+				names = append(names, k)
+			}
+			sort.Strings(names)
+			for _, k := range names {
+				fmt.Fprintf(env.out, "%s = %s\n", k, varMap[k].Str())
+			}
+		default:
+			for len(line) > 0 {
+				result := Eval(line, env)
+				if len(result.ErrStr) > 0 {
+					fmt.Fprintf(env.out, "Error: %s\n", result.ErrStr)
+					if len(result.StackTrace) > 0 {
+						fmt.Fprintf(env.out, "%s\n", result.StackTrace)
+					}
+					break
+				}
+				formatted, err := env.FormatResult(result)
+				if err != nil {
+					fmt.Fprintf(env.out, "Error: %s\n", err)
+					break
+				}
+				fmt.Fprintf(env.out, "%s\n", formatted)
+				line = result.RemainingTokens
+			}
+		}
+		if err != nil {
+			fmt.Fprintln(env.out)
+			return
+		}
+	}
+}
+
+func addDebugOperators(opMap map[string]*Operator) {
+	// Suspends evaluation, and starts a REPL in the scope where it was called,
+	// like the body of a method, to inspect its variables. The optional label
+	// is printed, to tell apart several breakpoints.
+	addOperator(opMap,
+		&Operator{
+			symbol:      breakOp,
+			minArgCount: 0,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				label := ""
+				if len(operands) > 0 {
+					label = " at " + operands[0].Val.Str()
+					if str, ok := operands[0].Val.(stringValue); ok {
+						label = " at " + str.contents()
+					}
+				}
+				breakREPL(env, label)
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      dumpEnv,
+			minArgCount: 0,
+			maxArgCount: 0,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				if !env.debug {
+					retVal.Err = debugOnlyError(dumpEnv)
+					return retVal
+				}
+				printEnv(env)
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      trace,
+			minArgCount: 1,
+			maxArgCount: 1,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				astVal, _ := operands[0].Val.(astValue)
+				wasTracing := env.tracer.tracingAll
+				env.tracer.tracingAll = true
+				result := evalASTHelper(env, astVal.astNodes[0])
+				env.tracer.tracingAll = wasTracing
+				return result
+			},
+		},
+	)
+
+	// Handlers for trace-fn and untrace-fn, which start and stop tracing the
+	// calls to a method respectively.
+	traceMethod := func(symbol string, enable bool) func(*LangEnv, []Atom) Atom {
+		return func(env *LangEnv, operands []Atom) Atom {
+			var retVal Atom
+			if operands[0].Val.getValueType() != varType || env.getOperator(operands[0].Val.Str()) == nil {
+				retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method", symbol, operands[0].Val.Str()))
+				return retVal
+			}
+			// Methods passed as arguments are bound under a different name.
+			methodName := env.getOperator(operands[0].Val.Str()).symbol
+			if enable {
+				env.tracer.tracedMethods[methodName] = true
+			} else {
+				delete(env.tracer.tracedMethods, methodName)
+			}
+			retVal.Val = newNilValue()
+			return retVal
+		}
+	}
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      traceFn,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler:     traceMethod(traceFn, true),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      untraceFn,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler:     traceMethod(untraceFn, false),
+		},
+	)
+}
@@ -0,0 +1,97 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+const (
+	deprecate string = "deprecate"
+)
+
+// Marks an operator as being phased out in favor of another one.
+type deprecation struct {
+	name        string
+	replacement string
+	// Shared by every alias of the operator, so that the warning is printed only
+	// once.
+	warned sync.Once
+}
+
+// Prints the warning for calling a deprecated operator by the name symbol, the
+// first time it is called, unless the warnings are suppressed. The operator is
+// deprecated under its own name, or under the name of the alias it is called
+// by.
+func warnIfDeprecated(env *LangEnv, symbol string, operator *Operator) {
+	if env.hideDeprecations {
+		return
+	}
+	env.mu.RLock()
+	d := env.deprecations[operator.symbol]
+	if d == nil {
+		d = env.deprecations[symbol]
+	}
+	env.mu.RUnlock()
+	if d == nil {
+		return
+	}
+	d.warned.Do(func() {
+		fmt.Fprintf(env.out, "Warning: %s is deprecated, use %s instead.\n", d.name, d.replacement)
+	})
+}
+
+// Marks the operator or method called name as deprecated in favor of
+// replacement, in the whole interpreter. Calling it still works, but prints a
+// warning the first time.
+func (e *LangEnv) Deprecate(name, replacement string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	op := e.opMap[name]
+	if op == nil {
+		return errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator", deprecate, name))
+	}
+	if e.opMap[replacement] == nil {
+		return errors.New(fmt.Sprintf("For %s, expected the replacement %s to be a method or an operator",
+			deprecate, replacement))
+	}
+	// Deprecating an operator also deprecates its aliases, but deprecating an
+	// alias leaves the operator it refers to as it is.
+	e.deprecations[name] = &deprecation{name: name, replacement: replacement}
+	return nil
+}
+
+// Enables or disables the warnings printed when calling deprecated operators.
+func (e *LangEnv) SetDeprecationWarnings(enabled bool) {
+	e.hideDeprecations = !enabled
+}
+
+func addDeprecationOperators(opMap map[string]*Operator) {
+	// Marks an operator or method as deprecated, like (deprecate old-name
+	// new-name), so that calling it warns about using the replacement instead.
+	addOperator(opMap,
+		&Operator{
+			symbol:           deprecate,
+			minArgCount:      2,
+			maxArgCount:      2,
+			doNotResolveVars: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				names := make([]string, len(operands))
+				for i, o := range operands {
+					name, err := nameOperand(deprecate, o)
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					names[i] = name
+				}
+				if retVal.Err = env.Deprecate(names[0], names[1]); retVal.Err != nil {
+					return retVal
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+}
@@ -0,0 +1,101 @@
+package lang
+
+const (
+	diff string = "diff"
+	// Stands in for an element which only one of the values has.
+	missingMarker string = "missing"
+)
+
+// Returns the elements of a list or a vector, and whether v is one.
+func sequenceElements(v Value) ([]Value, bool) {
+	switch val := consToList(v).(type) {
+	case listValue:
+		return val.values, true
+	case vectorValue:
+		return val.values, true
+	}
+	return nil, false
+}
+
+// Appends the differences between a and b to diffs, as (path a b) lists. The
+// path leads from the values which were compared to the elements which differ,
+// through the indices of lists and vectors, the keys of maps, and the fields of
+// records. Elements which only one of the values has are compared to :missing.
+func appendDiffs(diffs []Value, path []Value, a, b Value) []Value {
+	if isEqual(a, b) {
+		return diffs
+	}
+	// Copies the path, which is shared by the siblings of an element.
+	at := func(elem Value) []Value {
+		return append(append(make([]Value, 0, len(path)+1), path...), elem)
+	}
+	missing := keywordValue{missingMarker}
+
+	aElems, aIsSeq := sequenceElements(a)
+	bElems, bIsSeq := sequenceElements(b)
+	if aIsSeq && bIsSeq && consToList(a).getValueType() == consToList(b).getValueType() {
+		for i := 0; i < len(aElems) || i < len(bElems); i++ {
+			var index intValue
+			index.value = int64(i)
+			var aElem, bElem Value = missing, missing
+			if i < len(aElems) {
+				aElem = aElems[i]
+			}
+			if i < len(bElems) {
+				bElem = bElems[i]
+			}
+			diffs = appendDiffs(diffs, at(index), aElem, bElem)
+		}
+		return diffs
+	}
+
+	aMap, aIsMap := a.(mapValue)
+	bMap, bIsMap := b.(mapValue)
+	if aIsMap && bIsMap {
+		for _, k := range aMap.order {
+			entry := aMap.entries[k]
+			var bValue Value = missing
+			if bEntry, ok := bMap.entries[k]; ok {
+				bValue = bEntry.value
+			}
+			diffs = appendDiffs(diffs, at(entry.key), entry.value, bValue)
+		}
+		for _, k := range bMap.order {
+			if _, ok := aMap.entries[k]; !ok {
+				entry := bMap.entries[k]
+				diffs = appendDiffs(diffs, at(entry.key), missing, entry.value)
+			}
+		}
+		return diffs
+	}
+
+	aRec, aIsRec := a.(recordValue)
+	bRec, bIsRec := b.(recordValue)
+	if aIsRec && bIsRec && aRec.name == bRec.name {
+		for i, field := range aRec.fields {
+			diffs = appendDiffs(diffs, at(keywordValue{field}), aRec.values[i], bRec.values[i])
+		}
+		return diffs
+	}
+
+	return append(diffs, newListValue([]Value{newListValue(path), a, b}))
+}
+
+func addDiffOperators(opMap map[string]*Operator) {
+	// Returns where the values differ, as a list of (path a b) lists, like
+	// (diff (list 1 (list 2 3)) (list 1 (list 2 4))), which is (((1 1) 3 4)).
+	// Equal values have no differences.
+	addOperator(opMap,
+		&Operator{
+			symbol:      diff,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = newListValue(appendDiffs(make([]Value, 0), make([]Value, 0),
+					operands[0].Val, operands[1].Val))
+				return retVal
+			},
+		},
+	)
+}
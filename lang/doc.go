@@ -0,0 +1,136 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	docOp   string = "doc"
+	apropos string = "apropos"
+	source  string = "source"
+)
+
+// Splits the doc string off the body of a method, if the body has more than
+// one expression and the first of them is a string literal. The doc string is
+// not evaluated.
+func splitDocString(body []*ASTNode) (string, []*ASTNode) {
+	var strVal stringValue
+	if len(body) < 2 || !body[0].isValue || !strVal.ofType(body[0].value) {
+		return "", body
+	}
+	docVal, _ := strVal.newValue(body[0].value).(stringValue)
+	return docVal.contents(), body[1:]
+}
+
+// Returns the doc string of the method which the value refers to, if any.
+func docOf(env *LangEnv, v Value) string {
+	op := resolveOperator(env, v)
+	if op == nil || op.method == nil {
+		return ""
+	}
+	return op.method.doc
+}
+
+func addDocOperators(opMap map[string]*Operator) {
+	// Prints the doc string of a method defined using defun or lambda.
+	addOperator(opMap,
+		&Operator{
+			symbol:      docOp,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				op := resolveOperator(env, operands[0].Val)
+				if op == nil {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator",
+						docOp, operands[0].Val.Str()))
+					return retVal
+				}
+				if op.method == nil || op.method.doc == "" {
+					fmt.Fprintf(env.out, "No documentation for %s.\n", op.symbol)
+				} else {
+					fmt.Fprintf(env.out, "%s\n", op.method.doc)
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	// Returns the sorted names of the operators and variables containing the
+	// string, ignoring case. Names which have a doc string are followed by it,
+	// like "square: Returns the square of x.".
+	addOperator(opMap,
+		&Operator{
+			symbol:      apropos,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				query, err := stringOperand(apropos, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				query = strings.ToLower(query)
+
+				varMap, opMap := env.bindings()
+				docs := make(map[string]string)
+				for name, op := range opMap {
+					if strings.Contains(strings.ToLower(name), query) {
+						docs[name] = docOf(env, funcValue{op})
+					}
+				}
+				for name, v := range varMap {
+					if strings.Contains(strings.ToLower(name), query) {
+						docs[name] = docOf(env, v)
+					}
+				}
+				names := make([]string, 0, len(docs))
+				for name := range docs {
+					names = append(names, name)
+				}
+				sort.Strings(names)
+
+				results := make([]Value, 0, len(names))
+				for _, name := range names {
+					entry := name
+					if docs[name] != "" {
+						entry += ": " + docs[name]
+					}
+					results = append(results, newStringValue(entry))
+				}
+				retVal.Val = newListValue(results)
+				return retVal
+			},
+		},
+	)
+
+	// Returns the expression which defined a method. Builtin operators do not
+	// have one.
+	addOperator(opMap,
+		&Operator{
+			symbol:      source,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				op := resolveOperator(env, operands[0].Val)
+				if op == nil {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator",
+						source, operands[0].Val.Str()))
+					return retVal
+				}
+				str := fmt.Sprintf("%s is a native operator", op.symbol)
+				if op.method != nil && op.method.source != nil {
+					str = StringifyAST(op.method.source)
+				}
+				retVal.Val = newStringValue(str)
+				return retVal
+			},
+		},
+	)
+}
@@ -0,0 +1,128 @@
+package lang
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+const (
+	hashOp       string = "hash"
+	base64Encode string = "base64-encode"
+	base64Decode string = "base64-decode"
+)
+
+// The algorithms supported by hash, by name.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// Returns the base64 encoding to use, which is the standard one unless the
+// optional operand is :url.
+func base64Encoding(symbol string, operands []Atom) (*base64.Encoding, error) {
+	if len(operands) < 2 {
+		return base64.StdEncoding, nil
+	}
+	kw, ok := operands[1].Val.(keywordValue)
+	if !ok || (kw.name != "url" && kw.name != "std") {
+		return nil, errors.New(fmt.Sprintf("For %s, expected %s to be either :std or :url",
+			symbol, operands[1].Val.Str()))
+	}
+	if kw.name == "url" {
+		return base64.URLEncoding, nil
+	}
+	return base64.StdEncoding, nil
+}
+
+func addEncodingOperators(opMap map[string]*Operator) {
+	// Returns the hex digest of the string, using the named algorithm.
+	addOperator(opMap,
+		&Operator{
+			symbol:      hashOp,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				algorithm, err := stringOperand(hashOp, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				input, err := stringOperand(hashOp, operands[1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				newHash, ok := hashAlgorithms[algorithm]
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, unknown algorithm %s, expected one of md5, sha1 and sha256",
+						hashOp, operands[0].Val.Str()))
+					return retVal
+				}
+				h := newHash()
+				h.Write([]byte(input))
+				retVal.Val = newStringValue(hex.EncodeToString(h.Sum(nil)))
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      base64Encode,
+			minArgCount: 1,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				input, err := stringOperand(base64Encode, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				encoding, err := base64Encoding(base64Encode, operands)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newStringValue(encoding.EncodeToString([]byte(input)))
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      base64Decode,
+			minArgCount: 1,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				input, err := stringOperand(base64Decode, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				encoding, err := base64Encoding(base64Decode, operands)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				decoded, err := encoding.DecodeString(input)
+				if err != nil {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, %s is not valid base64: %s",
+						base64Decode, operands[0].Val.Str(), err))
+					return retVal
+				}
+				retVal.Val = newStringValue(string(decoded))
+				return retVal
+			},
+		},
+	)
+}
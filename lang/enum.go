@@ -0,0 +1,88 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	defenum string = "defenum"
+)
+
+// Returns the name of the enum or of one of its variants, which has to be a
+// valid variable name.
+func enumName(operand Atom) (string, error) {
+	name, err := nameOperand(defenum, operand)
+	if err != nil {
+		return "", err
+	}
+	if !new(varValue).ofType(name) {
+		return "", errors.New(fmt.Sprintf("For %s, expected %s to be a name", defenum, name))
+	}
+	return name, checkNotSpecialForm(name)
+}
+
+func addEnumOperators(opMap map[string]*Operator) {
+	// Defines an enum, like (defenum color red green blue), which binds every
+	// variant to a variable, and defines the predicate color?, which checks
+	// whether a value is one of the variants. Returns the list of the variants.
+	// Values are dispatched on by their variant using match, whose patterns can
+	// be the names of the variants, like (match c (red 1) (green 2) (_ 3)).
+	addOperator(opMap,
+		&Operator{
+			symbol:           defenum,
+			minArgCount:      2,
+			maxArgCount:      100,
+			doNotResolveVars: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				enum, err := enumName(operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+
+				variants := make([]Value, 0, len(operands)-1)
+				seen := make(map[string]bool)
+				for _, o := range operands[1:] {
+					name, err := enumName(o)
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					if seen[name] {
+						retVal.Err = errors.New(fmt.Sprintf("Variant %s of enum %s is defined more than once", name, enum))
+						return retVal
+					}
+					if env.getOperator(name) != nil {
+						retVal.Err = errors.New(fmt.Sprintf("Cannot use %s as a variant, as it is defined as an operator.", name))
+						return retVal
+					}
+					seen[name] = true
+					variants = append(variants, enumValue{enum, name})
+				}
+
+				predicate := enum + "?"
+				if retVal.Err = checkNotSpecialForm(predicate); retVal.Err != nil {
+					return retVal
+				}
+				for _, v := range variants {
+					env.setValue(v.(enumValue).name, v)
+				}
+				env.setOperator(predicate, &Operator{
+					symbol:      predicate,
+					minArgCount: 1,
+					maxArgCount: 1,
+					handler: func(env *LangEnv, operands []Atom) Atom {
+						var retVal Atom
+						v, ok := operands[0].Val.(enumValue)
+						retVal.Val = newBoolValue(ok && v.enum == enum)
+						return retVal
+					},
+				})
+				retVal.Val = newListValue(variants)
+				return retVal
+			},
+		},
+	)
+}
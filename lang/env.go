@@ -1,12 +1,61 @@
 package lang
 
+import (
+	"bufio"
+	"io"
+	"math/rand"
+	"os"
+	"sync"
+	"time"
+)
+
 // Data required for interpretation of the language.
 // We start with the default environment, and build on top of it, over time.
 type LangEnv struct {
 	opMap          map[string]*Operator
-	types          []Value
 	varMap         map[string]Value
 	recursionDepth int
+	out            io.Writer
+	// The most method calls which can be nested, beyond which a call raises a
+	// stack-overflow error, instead of overflowing the Go stack.
+	maxRecursionDepth int
+	// Buffered, and shared with the child environments, so that no input is lost
+	// between reads.
+	in *bufio.Reader
+	// Enables operators meant for debugging the interpreter itself.
+	debug bool
+	// Disables the operators which access the system, like the file system.
+	sandboxed bool
+	// Disables the warnings printed when calling deprecated operators.
+	hideDeprecations bool
+	// The deprecated operators, by name. Shared with the child environments, so
+	// that deprecating an operator within a method applies everywhere.
+	deprecations map[string]*deprecation
+	// The command-line arguments passed to the script.
+	args []string
+	// The generator used by the random operators. Every environment has its
+	// own, which is shared with its child environments.
+	rand *rand.Rand
+	// Shared with the child environments, so that tracing spans method calls.
+	tracer *tracer
+	// Shared with the child environments, like the tracer.
+	repl *replSettings
+	// The types which tokens are parsed as, in order. Shared with the child
+	// environments, so that set-precision applies to all of them.
+	types *[]Value
+	// The call to the method being evaluated, which leads to the calls it was
+	// made from. Errors raised within it carry them as their stack trace.
+	frame *stackFrame
+	// The promises being forced, which cannot be forced again until they have
+	// been evaluated.
+	forcing *promiseChain
+	// How integer arithmetic overflows and divides. It is shared with the child
+	// environments, like the REPL settings. See set-numeric-policy.
+	numericPolicy *numericPolicy
+	// Guards the variables and operators of the environment, and of all the
+	// environments created from it, which can be read by spawned tasks while
+	// they are being defined. See spawn for the concurrency model.
+	mu *sync.RWMutex
 }
 
 func NewEnv() *LangEnv {
@@ -19,15 +68,178 @@ func NewEnv() *LangEnv {
 func (e *LangEnv) Init() {
 	// e.opMap = make(map[string]*Operator)
 	e.opMap = builtinOperators()
-	e.types = builtinTypes()
+	types := builtinTypes()
+	e.types = &types
 	e.varMap = make(map[string]Value)
 	e.recursionDepth = 0
+	e.maxRecursionDepth = defaultMaxRecursionDepth
+	e.out = os.Stdout
+	e.in = bufio.NewReader(os.Stdin)
+	e.args = []string{}
+	e.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+	e.tracer = newTracer()
+	e.repl = newREPLSettings()
+	e.numericPolicy = new(numericPolicy)
+	e.deprecations = make(map[string]*deprecation)
+	e.mu = new(sync.RWMutex)
+}
+
+// Creates the environment in which a nested scope (like a method body) is
+// evaluated. Definitions made in the child do not leak into the parent.
+func (e *LangEnv) newChildEnv() *LangEnv {
+	child := new(LangEnv)
+
+	// Copy all the operators and variable values of the parent env.
+	child.varMap, child.opMap = e.bindings()
+
+	child.types = e.types
+	child.recursionDepth = e.recursionDepth
+	child.maxRecursionDepth = e.maxRecursionDepth
+	child.out = e.out
+	child.in = e.in
+	child.debug = e.debug
+	child.sandboxed = e.sandboxed
+	child.hideDeprecations = e.hideDeprecations
+	child.deprecations = e.deprecations
+	child.args = e.args
+	child.rand = e.rand
+	child.tracer = e.tracer
+	child.repl = e.repl
+	child.numericPolicy = e.numericPolicy
+	child.frame = e.frame
+	child.forcing = e.forcing
+	child.mu = e.mu
+	return child
+}
+
+// Creates the environment in which a spawned task is evaluated. Unlike a child
+// environment, it has its own random generator and tracer, which are not safe
+// to share between goroutines.
+func (e *LangEnv) newTaskEnv() *LangEnv {
+	child := e.newChildEnv()
+	child.rand = rand.New(rand.NewSource(e.rand.Int63()))
+	child.tracer = newTracer()
+	child.tracer.tracingAll = e.tracer.tracingAll
+	for name := range e.tracer.tracedMethods {
+		child.tracer.tracedMethods[name] = true
+	}
+	return child
+}
+
+// Takes the state of the evaluation in progress from the caller, for
+// evaluating code which was defined in another environment, like the body of a
+// lambda. The bindings stay those of the environment the code was defined in,
+// but the random generator and tracer have to be those of the task evaluating
+// it, which might not be the task which defined it.
+func (e *LangEnv) takeCallerState(caller *LangEnv) {
+	e.rand = caller.rand
+	e.tracer = caller.tracer
+	e.recursionDepth = caller.recursionDepth
+	e.maxRecursionDepth = caller.maxRecursionDepth
+	e.frame = caller.frame
+	e.forcing = caller.forcing
+}
+
+// Returns copies of the variables and operators defined in the environment.
+func (e *LangEnv) bindings() (map[string]Value, map[string]*Operator) {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	varMap := make(map[string]Value, len(e.varMap))
+	for k, v := range e.varMap {
+		varMap[k] = v
+	}
+	opMap := make(map[string]*Operator, len(e.opMap))
+	for k, v := range e.opMap {
+		opMap[k] = v
+	}
+	return varMap, opMap
+}
+
+// Sets the writer to which operators print their output.
+func (e *LangEnv) SetOutput(w io.Writer) {
+	e.out = w
+}
+
+// Sets the reader from which operators read their input.
+func (e *LangEnv) SetInput(r io.Reader) {
+	e.in = bufio.NewReader(r)
 }
 
 func (e *LangEnv) getOperator(sym string) *Operator {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.opMap[sym]
 }
 
 func (e *LangEnv) getValue(sym string) Value {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
 	return e.varMap[sym]
 }
+
+func (e *LangEnv) setOperator(sym string, op *Operator) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.opMap[sym] = op
+}
+
+func (e *LangEnv) setValue(sym string, val Value) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.varMap[sym] = val
+}
+
+// Removes both the variable and the operator named sym, if there are any.
+func (e *LangEnv) unbind(sym string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.opMap, sym)
+	delete(e.varMap, sym)
+}
+
+// Enables or disables the debugging operators.
+func (e *LangEnv) SetDebug(debug bool) {
+	e.debug = debug
+}
+
+// Enables or disables the sandbox, in which the operators accessing the
+// system raise an error.
+func (e *LangEnv) SetSandboxed(sandboxed bool) {
+	e.sandboxed = sandboxed
+}
+
+// Sets the most method calls which can be nested. Going deeper raises a
+// stack-overflow error, which can be caught like any other error.
+func (e *LangEnv) SetMaxRecursionDepth(depth int) {
+	e.maxRecursionDepth = depth
+}
+
+// Sets the command-line arguments, which the script can get using
+// command-line-args.
+func (e *LangEnv) SetArgs(args []string) {
+	e.args = args
+}
+
+// Returns the types which tokens are parsed as.
+func (e *LangEnv) valueTypes() []Value {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return *e.types
+}
+
+// Sets the precision (in bits) with which big float literals are parsed. The
+// types are replaced rather than changed, since they can be read by other tasks
+// while parsing.
+func (e *LangEnv) setBigFloatPrec(prec uint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	types := make([]Value, len(*e.types))
+	for i, t := range *e.types {
+		if t.getValueType() == bigFloatType {
+			types[i] = bigFloatValue{prec: prec}
+		} else {
+			types[i] = t
+		}
+	}
+	*e.types = types
+}
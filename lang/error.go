@@ -0,0 +1,219 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	raiseError   string = "error"
+	isError      string = "error?"
+	errorMessage string = "error-message"
+	errorTrace   string = "error-trace"
+	tryThread    string = "try->"
+	// The variable which try-> binds the threaded value to. It is not a valid
+	// variable name in the language, so it cannot clash with the user's names.
+	tryThreadVar string = "%try->"
+)
+
+// The most calls kept in a stack trace. Deeper stacks, like those of a method
+// recurring without recur, are cut off, keeping the innermost calls.
+const maxTraceFrames = 20
+
+// A call to a method, and the frame of the call it was made from.
+type stackFrame struct {
+	name   string
+	args   []Atom
+	parent *stackFrame
+	// Where the call was written, unless it was made by an operator, like pmap.
+	pos position
+}
+
+func (f *stackFrame) Str() string {
+	callStr := f.name
+	for _, o := range f.args {
+		callStr += " " + o.Val.Str()
+	}
+	return "(" + callStr + ")"
+}
+
+// Returns the call along with where it was written, like (f 1) at 3:5.
+func (f *stackFrame) describe() string {
+	return describeAt(f.Str(), f.pos)
+}
+
+func describeAt(exp string, pos position) string {
+	if pos.line == 0 {
+		return exp
+	}
+	return exp + " at " + pos.String()
+}
+
+// An error raised within a method, along with the calls which were being
+// evaluated when it was raised.
+type tracedError struct {
+	err error
+	// The calls, innermost first, with where they were written.
+	trace []string
+	// The number of calls left out of the trace.
+	omitted int
+}
+
+func (e *tracedError) Error() string {
+	return e.err.Error()
+}
+
+// Attaches the calls leading to frame to the error, unless it already has the
+// trace of a deeper call.
+func withStackTrace(err error, frame *stackFrame) error {
+	if _, ok := err.(*tracedError); ok || frame == nil {
+		return err
+	}
+	traced := &tracedError{err: err}
+	for f := frame; f != nil; f = f.parent {
+		if len(traced.trace) == maxTraceFrames {
+			traced.omitted++
+			continue
+		}
+		traced.trace = append(traced.trace, f.describe())
+	}
+	return traced
+}
+
+// Returns the stack trace of the error, one call per line, or an empty string
+// if it does not have one.
+func formatStackTrace(err error) string {
+	traced, ok := err.(*tracedError)
+	if !ok {
+		return ""
+	}
+	lines := make([]string, 0, len(traced.trace)+1)
+	for _, call := range traced.trace {
+		lines = append(lines, "  in "+call)
+	}
+	if traced.omitted > 0 {
+		lines = append(lines, fmt.Sprintf("  ... and %d more calls", traced.omitted))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func addErrorOperators(opMap map[string]*Operator) {
+	// Raises an error with the given message.
+	addOperator(opMap,
+		&Operator{
+			symbol:      raiseError,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				msg := operands[0].Val.Str()
+				if strVal, ok := operands[0].Val.(stringValue); ok {
+					msg = strVal.contents()
+				}
+				retVal.Err = errors.New(msg)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      isError,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = newBoolValue(operands[0].Val.getValueType() == errorType)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      errorMessage,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				errVal, ok := operands[0].Val.(errorValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be an error",
+						errorMessage, operands[0].Val.Str()))
+					return retVal
+				}
+				retVal.Val = newStringValue(errVal.err.Error())
+				return retVal
+			},
+		},
+	)
+
+	// Returns the calls which were being evaluated when the error was raised, as
+	// a list of strings, innermost first.
+	addOperator(opMap,
+		&Operator{
+			symbol:      errorTrace,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				errVal, ok := operands[0].Val.(errorValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be an error",
+						errorTrace, operands[0].Val.Str()))
+					return retVal
+				}
+				calls := make([]Value, 0)
+				if traced, ok := errVal.err.(*tracedError); ok {
+					for _, call := range traced.trace {
+						calls = append(calls, newStringValue(call))
+					}
+				}
+				retVal.Val = newListValue(calls)
+				return retVal
+			},
+		},
+	)
+
+	// Threads the value through the expressions like ->, but evaluates them one
+	// at a time. If an expression raises an error, or results in an error value,
+	// the rest are skipped and the error value is returned.
+	addOperator(opMap,
+		&Operator{
+			symbol:      tryThread,
+			minArgCount: 1,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				astVal, _ := operands[0].Val.(astValue)
+				accNode := newValueNode(tryThreadVar)
+
+				result := evalASTHelper(env, astVal.astNodes[0])
+				for _, step := range astVal.astNodes[1:] {
+					if result.Err != nil || result.Val.getValueType() == errorType {
+						break
+					}
+					var node *ASTNode
+					if step.isValue {
+						node = newListNode([]*ASTNode{step, accNode})
+					} else if len(step.children) == 0 {
+						result.Err = errStr("an expression to thread through", "()")
+						break
+					} else {
+						children := []*ASTNode{step.children[0], accNode}
+						node = newListNode(append(children, step.children[1:]...))
+					}
+					stepEnv := env.newChildEnv()
+					bindParam(env, stepEnv, tryThreadVar, result.Val)
+					result = evalASTHelper(stepEnv, node)
+				}
+
+				if result.Err != nil {
+					result.Val, result.Err = newErrorValue(result.Err), nil
+				}
+				return result
+			},
+		},
+	)
+}
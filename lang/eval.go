@@ -0,0 +1,114 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	evalOp  string = "eval"
+	evalAll string = "eval-all"
+	// Named like read-line and read-file, although it reads from a string.
+	readData string = "read-data"
+)
+
+// Parses the source of a single expression.
+func parseForm(symbol, src string) (*ASTNode, error) {
+	astNode, tokens, _, err := getAST(src)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("For %s, could not parse %s: %s", symbol, src, err))
+	}
+	if len(tokens) > 0 {
+		return nil, errors.New(fmt.Sprintf("For %s, expected a single expression in %s", symbol, src))
+	}
+	return astNode, nil
+}
+
+// Evaluates the form in env. A string is parsed as the source of a single
+// expression, and any other value is evaluated as the expression it prints as,
+// so that the expressions built by ast-walk can be evaluated.
+func evalForm(env *LangEnv, symbol string, form Value) Atom {
+	var retVal Atom
+	src := form.Str()
+	if strVal, ok := form.(stringValue); ok {
+		src = strVal.contents()
+	}
+	astNode, err := parseForm(symbol, src)
+	if err != nil {
+		retVal.Err = err
+		return retVal
+	}
+	return evalASTHelper(env, astNode)
+}
+
+func addEvalOperators(opMap map[string]*Operator) {
+	// Evaluates the form in the current environment, like (eval "(+ 1 2)").
+	addOperator(opMap,
+		&Operator{
+			symbol:      evalOp,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				return evalForm(env, evalOp, operands[0].Val)
+			},
+		},
+	)
+
+	// Evaluates the forms in the list in order, and returns the list of their
+	// results. The forms are evaluated in the current environment, so the later
+	// ones can refer to what the earlier ones defined. Evaluation stops at the
+	// first error.
+	addOperator(opMap,
+		&Operator{
+			symbol:      evalAll,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				forms, ok := operands[0].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						evalAll, operands[0].Val.Str()))
+					return retVal
+				}
+				results := make([]Value, len(forms.values))
+				for i, form := range forms.values {
+					result := evalForm(env, evalAll, form)
+					if result.Err != nil {
+						return result
+					}
+					results[i] = result.Val
+				}
+				retVal.Val = newListValue(results)
+				return retVal
+			},
+		},
+	)
+
+	// Parses the string as data, like (read-data "(:name 'lambda' :tags (a b))"),
+	// without evaluating anything. Lists are returned as lists, names as
+	// symbols, and everything else as literals, so it is safe to use on
+	// untrusted input.
+	addOperator(opMap,
+		&Operator{
+			symbol:      readData,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				src, err := stringOperand(readData, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				astNode, err := parseForm(readData, src)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = astToValue(env, astNode)
+				return retVal
+			},
+		},
+	)
+}
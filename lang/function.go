@@ -0,0 +1,280 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	partial    string = "partial"
+	curry      string = "curry"
+	compose    string = "compose"
+	identity   string = "identity"
+	constantly string = "constantly"
+	lambda     string = "lambda"
+	alias      string = "alias"
+)
+
+// Returns the name which the operand refers to, which is either a symbol or a
+// string.
+func nameOperand(symbol string, operand Atom) (string, error) {
+	switch v := operand.Val.(type) {
+	case varValue:
+		return v.varName, nil
+	case stringValue:
+		return v.contents(), nil
+	}
+	return "", errors.New(fmt.Sprintf("For %s, expected %s to be a name", symbol, operand.Val.Str()))
+}
+
+// Returns the number of arguments which the function has to be called with, or
+// an error if it accepts a varying number of them. Methods with optional
+// parameters are counted by their required ones.
+func fixedArity(env *LangEnv, fn Value) (int, error) {
+	operator := resolveOperator(env, fn)
+	if operator == nil {
+		return 0, errors.New(fmt.Sprintf("Expected %s to be a method or an operator", fn.Str()))
+	}
+	if operator.method != nil {
+		return len(operator.method.params) - len(operator.method.defaults), nil
+	}
+	if operator.minArgCount != operator.maxArgCount {
+		return 0, errors.New(fmt.Sprintf("%s does not have a fixed number of arguments", fn.Str()))
+	}
+	return operator.minArgCount, nil
+}
+
+// Returns the values of the operands.
+func operandValues(operands []Atom) []Value {
+	values := make([]Value, len(operands))
+	for i, o := range operands {
+		values[i] = o.Val
+	}
+	return values
+}
+
+// Returns a function which collects arguments until it has arity of them, and
+// then calls fn with them.
+func curried(fn Value, arity int, collected []Value) Value {
+	return newFuncValue(curry, func(env *LangEnv, operands []Atom) Atom {
+		args := make([]Value, 0, len(collected)+len(operands))
+		args = append(args, collected...)
+		args = append(args, operandValues(operands)...)
+		if len(args) >= arity {
+			return callOperator(env, fn, args)
+		}
+		var retVal Atom
+		retVal.Val = curried(fn, arity, args)
+		return retVal
+	})
+}
+
+func addFunctionOperators(opMap map[string]*Operator) {
+	// Returns an anonymous method, which takes parameters like the ones defined
+	// using defun. Unlike those, it is evaluated in the environment it was
+	// created in, so it can refer to the variables around it even after they
+	// go out of scope. A body with multiple expressions is evaluated like begin.
+	// Like with defun, a string before the body is its doc string.
+	addOperator(opMap,
+		&Operator{
+			symbol:      lambda,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				if astVal.astNodes[0].isValue {
+					retVal.Err = errors.New(fmt.Sprintf("Missing list of parameters for %s", lambda))
+					return retVal
+				}
+				params, defaults, patterns, err := parseParams(env, lambda, astVal.astNodes[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				doc, bodyNodes := splitDocString(astVal.astNodes[1:])
+				body := bodyNodes[0]
+				if len(bodyNodes) > 1 {
+					body = newListNode(append([]*ASTNode{newValueNode(begin)}, bodyNodes...))
+				}
+
+				if retVal.Err = checkRecur(body, true); retVal.Err != nil {
+					return retVal
+				}
+
+				m := &method{methodName: lambda, params: params, defaults: defaults, patterns: patterns, ast: body, doc: doc, env: env,
+					source: newListNode(append([]*ASTNode{newValueNode(lambda)}, astVal.astNodes...))}
+				retVal.Val = funcValue{&Operator{
+					symbol:      lambda,
+					minArgCount: 0,
+					maxArgCount: 2 * len(params),
+					handler: func(env *LangEnv, operands []Atom) Atom {
+						return m.call(env, operands, position{})
+					},
+					method: m,
+				}}
+				return retVal
+			},
+		},
+	)
+
+	// Returns a function which calls fn with the given arguments, followed by the
+	// ones it is called with.
+	addOperator(opMap,
+		&Operator{
+			symbol:      partial,
+			minArgCount: 1,
+			maxArgCount: 100,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				fn := operands[0].Val
+				if resolveOperator(env, fn) == nil {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator",
+						partial, fn.Str()))
+					return retVal
+				}
+				leading := operandValues(operands[1:])
+				retVal.Val = newFuncValue(partial, func(env *LangEnv, operands []Atom) Atom {
+					args := make([]Value, 0, len(leading)+len(operands))
+					args = append(args, leading...)
+					args = append(args, operandValues(operands)...)
+					return callOperator(env, fn, args)
+				})
+				return retVal
+			},
+		},
+	)
+
+	// Returns a curried version of a function with a fixed number of arguments,
+	// which can be passed any number of them at a time. Once it has all of them,
+	// the function is called.
+	addOperator(opMap,
+		&Operator{
+			symbol:      curry,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				arity, err := fixedArity(env, operands[0].Val)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = curried(operands[0].Val, arity, []Value{})
+				return retVal
+			},
+		},
+	)
+
+	// Returns a function which calls the functions from right to left. The last
+	// function is called with the arguments, and the others with the result of
+	// the function after them.
+	addOperator(opMap,
+		&Operator{
+			symbol:      compose,
+			minArgCount: 1,
+			maxArgCount: 100,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				fns := operandValues(operands)
+				for _, fn := range fns {
+					if resolveOperator(env, fn) == nil {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator",
+							compose, fn.Str()))
+						return retVal
+					}
+				}
+				retVal.Val = newFuncValue(compose, func(env *LangEnv, operands []Atom) Atom {
+					result := callOperator(env, fns[len(fns)-1], operandValues(operands))
+					for i := len(fns) - 2; i >= 0 && result.Err == nil; i-- {
+						result = callOperator(env, fns[i], []Value{result.Val})
+					}
+					return result
+				})
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:         identity,
+			minArgCount:    1,
+			maxArgCount:    1,
+			keepsConsCells: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				return operands[0]
+			},
+		},
+	)
+
+	// Returns a function which ignores its arguments, and returns the value.
+	addOperator(opMap,
+		&Operator{
+			symbol:      constantly,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				val := operands[0].Val
+				retVal.Val = newFuncValue(constantly, func(env *LangEnv, operands []Atom) Atom {
+					var retVal Atom
+					retVal.Val = val
+					return retVal
+				})
+				return retVal
+			},
+		},
+	)
+
+	// Makes a name refer to the same operator or method as another one, without
+	// copying it. Aliases can be redefined, but operators and methods cannot be
+	// replaced by one.
+	addOperator(opMap,
+		&Operator{
+			symbol:           alias,
+			minArgCount:      2,
+			maxArgCount:      2,
+			doNotResolveVars: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				name, err := nameOperand(alias, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				target, err := nameOperand(alias, operands[1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+
+				if retVal.Err = checkNotSpecialForm(name); retVal.Err != nil {
+					return retVal
+				}
+				if env.getValue(name) != nil {
+					retVal.Err = errors.New(fmt.Sprintf("Cannot use %s as an alias, as it is defined as a variable.", name))
+					return retVal
+				}
+				// An operator stored under a different name than its own is an alias.
+				if op := env.getOperator(name); op != nil && op.symbol == name {
+					retVal.Err = errors.New(fmt.Sprintf("Cannot use %s as an alias, as it is defined as an operator.", name))
+					return retVal
+				}
+
+				op := env.getOperator(target)
+				if f, ok := env.getValue(target).(funcValue); ok && op == nil {
+					op = f.op
+				}
+				if op == nil {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator", alias, target))
+					return retVal
+				}
+				env.setOperator(name, op)
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+}
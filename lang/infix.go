@@ -0,0 +1,112 @@
+package lang
+
+const infix string = "infix"
+
+// The precedence of the infix operators, and the prefix operators which they
+// are rewritten to. Operators with a higher precedence bind tighter.
+var infixOperators = map[string]struct {
+	precedence int
+	symbol     string
+}{
+	"+": {1, add},
+	"-": {1, sub},
+	"*": {2, mul},
+	"/": {2, div},
+	"^": {3, expt},
+}
+
+func isInfixOperator(node *ASTNode) bool {
+	if !node.isValue {
+		return false
+	}
+	_, ok := infixOperators[node.value]
+	return ok
+}
+
+// Parses a list of infix tokens into the equivalent prefix expression.
+type infixParser struct {
+	tokens []*ASTNode
+	pos    int
+}
+
+func parseInfix(tokens []*ASTNode) (*ASTNode, error) {
+	if len(tokens) == 0 {
+		return nil, errStr("an infix expression", "()")
+	}
+	p := &infixParser{tokens: tokens}
+	node, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, errStr("an infix operator", StringifyAST(p.tokens[p.pos]))
+	}
+	return node, nil
+}
+
+// Parses operators with at least the given precedence. All of them are left
+// associative, except for ^.
+func (p *infixParser) parseBinary(minPrecedence int) (*ASTNode, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.pos < len(p.tokens) && isInfixOperator(p.tokens[p.pos]) {
+		op := infixOperators[p.tokens[p.pos].value]
+		if op.precedence < minPrecedence {
+			break
+		}
+		p.pos++
+		nextPrecedence := op.precedence + 1
+		if op.symbol == expt {
+			nextPrecedence = op.precedence
+		}
+		rhs, err := p.parseBinary(nextPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		lhs = newListNode([]*ASTNode{newValueNode(op.symbol), lhs, rhs})
+	}
+	return lhs, nil
+}
+
+// Parses an operand, which can be negated. Like in mathematical notation,
+// -2 ^ 2 is -(2 ^ 2).
+func (p *infixParser) parseUnary() (*ASTNode, error) {
+	if p.pos == len(p.tokens) {
+		return nil, errStr("an operand", "the end of the infix expression")
+	}
+	token := p.tokens[p.pos]
+	if token.isValue && token.value == "-" {
+		p.pos++
+		operand, err := p.parseBinary(infixOperators["^"].precedence)
+		if err != nil {
+			return nil, err
+		}
+		return newListNode([]*ASTNode{newValueNode(sub), newValueNode("0"), operand}), nil
+	}
+	if isInfixOperator(token) {
+		return nil, errStr("an operand", token.value)
+	}
+	p.pos++
+	if token.isValue || !isInfixGroup(token) {
+		return token, nil
+	}
+	return parseInfix(token.children)
+}
+
+// Returns whether a list within an infix expression is a parenthesized infix
+// expression, like (1 + 2), rather than a prefix expression, like (f x).
+func isInfixGroup(node *ASTNode) bool {
+	children := node.children
+	if len(children) == 1 {
+		return true
+	}
+	return len(children) > 1 && (isInfixOperator(children[1]) ||
+		(children[0].isValue && children[0].value == "-" && len(children) == 2))
+}
+
+// Rewrites (infix 1 + 2 * 3) to (+ 1 (* 2 3)).
+func infixExpander(node *ASTNode) (*ASTNode, error) {
+	return parseInfix(node.children[1:])
+}
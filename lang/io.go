@@ -0,0 +1,133 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	readLine     string = "read-line"
+	readFile     string = "read-file"
+	writeFile    string = "write-file"
+	isFileExists string = "file-exists?"
+)
+
+// Returns an error if the operator is called in a sandboxed environment.
+func checkNotSandboxed(env *LangEnv, symbol string) error {
+	if env.sandboxed {
+		return errors.New(fmt.Sprintf("%s is disabled in the sandbox.", symbol))
+	}
+	return nil
+}
+
+// Returns the contents of a string operand.
+func stringOperand(symbol string, operand Atom) (string, error) {
+	strVal, ok := operand.Val.(stringValue)
+	if !ok {
+		return "", errors.New(fmt.Sprintf("For %s, expected %s to be a string", symbol, operand.Val.Str()))
+	}
+	return strVal.contents(), nil
+}
+
+// Returns the handler for an operator which accesses the file system, and
+// takes the path of a file as its first operand.
+func fileHandler(symbol string, handler func(path string, operands []Atom) Atom) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		if retVal.Err = checkNotSandboxed(env, symbol); retVal.Err != nil {
+			return retVal
+		}
+		path, err := stringOperand(symbol, operands[0])
+		if err != nil {
+			retVal.Err = err
+			return retVal
+		}
+		return handler(path, operands)
+	}
+}
+
+func addIOOperators(opMap map[string]*Operator) {
+	// Returns the next line of the input, without the line ending, or nil at the
+	// end of the input.
+	addOperator(opMap,
+		&Operator{
+			symbol:      readLine,
+			minArgCount: 0,
+			maxArgCount: 0,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				line, err := env.in.ReadString('\n')
+				if err == io.EOF && len(line) == 0 {
+					retVal.Val = newNilValue()
+					return retVal
+				}
+				// The last line might not end with a newline.
+				if err != nil && err != io.EOF {
+					retVal.Err = err
+					return retVal
+				}
+				line = strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r")
+				retVal.Val = newStringValue(line)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      readFile,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: fileHandler(readFile, func(path string, operands []Atom) Atom {
+				var retVal Atom
+				contents, err := ioutil.ReadFile(path)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newStringValue(string(contents))
+				return retVal
+			}),
+		},
+	)
+
+	// Writes the string to the file, replacing its contents, and returns nil.
+	addOperator(opMap,
+		&Operator{
+			symbol:      writeFile,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: fileHandler(writeFile, func(path string, operands []Atom) Atom {
+				var retVal Atom
+				contents, err := stringOperand(writeFile, operands[1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				if retVal.Err = ioutil.WriteFile(path, []byte(contents), 0644); retVal.Err != nil {
+					return retVal
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			}),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      isFileExists,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: fileHandler(isFileExists, func(path string, operands []Atom) Atom {
+				var retVal Atom
+				_, err := os.Stat(path)
+				retVal.Val = newBoolValue(err == nil)
+				return retVal
+			}),
+		},
+	)
+}
@@ -1,7 +1,6 @@
 package lang
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"strings"
@@ -11,18 +10,33 @@ import (
 type Atom struct {
 	Err error
 	Val Value
+	// Whether the operand was written as a keyword in the call, like :x in
+	// (f :x 1), which makes it the name of a keyword argument to a method.
+	keywordArg bool
 }
 
 type EvalResult struct {
-	ValStr          string
-	ErrStr          string
+	ValStr string
+	ErrStr string
+	// The calls which were being evaluated when the error was raised, one per
+	// line, innermost first. It is empty if the error was not raised within a
+	// method.
+	StackTrace string
+	// The source after the expression, which is evaluated next. It starts with
+	// the whitespace which keeps the positions of the expressions in it the same
+	// as in the source, for their stack traces.
 	RemainingTokens string
+	// The value itself, which the REPL formats. See LangEnv.FormatResult.
+	val Value
 }
 
 func Eval(exp string, env *LangEnv) *EvalResult {
-	exp = strings.TrimSpace(exp)
 	evalResult := new(EvalResult)
-	astNode, tokens, err := getAST(exp)
+	// Blank input, like an empty line in a script, evaluates to nothing.
+	if len(strings.TrimSpace(exp)) == 0 {
+		return evalResult
+	}
+	astNode, tokens, positions, err := getAST(exp)
 	if err != nil {
 		evalResult.ErrStr = err.Error()
 		return evalResult
@@ -37,20 +51,22 @@ func Eval(exp string, env *LangEnv) *EvalResult {
 	if result.Val != nil && result.Val.getValueType() == varType {
 		result.Val, result.Err = getVarValue(env, result.Val)
 	}
+	if result.Err == nil && result.Val != nil && result.Val.getValueType() == recurType {
+		result.Err = errors.New(fmt.Sprintf("%s can only be used in the body of a method", recur))
+	}
 
 	if result.Err != nil {
 		evalResult.ErrStr = result.Err.Error()
+		evalResult.StackTrace = formatStackTrace(result.Err)
 	} else if result.Val != nil {
 		evalResult.ValStr = result.Val.Str()
+		evalResult.val = result.Val
 	}
 
-	if tokens != nil && len(tokens) > 0 {
-		var buffer bytes.Buffer
-		for _, s := range tokens {
-			buffer.WriteString(s)
-			buffer.WriteString(" ")
-		}
-		evalResult.RemainingTokens = strings.TrimSpace(buffer.String())
+	if len(tokens) > 0 {
+		next := positions[0]
+		evalResult.RemainingTokens = strings.Repeat("\n", next.line-1) + strings.Repeat(" ", next.col-1) +
+			string([]rune(exp)[next.offset:])
 	}
 	return evalResult
 }
@@ -64,13 +80,136 @@ func evalASTHelper(env *LangEnv, node *ASTNode) Atom {
 	return result
 }
 
+// Evaluates a sequence of expressions in order, and returns the result of the
+// last one. Evaluation stops at the first error.
+func evalASTs(env *LangEnv, nodes []*ASTNode) Atom {
+	var retVal Atom
+	retVal.Val = newNilValue()
+	for _, node := range nodes {
+		retVal = evalASTHelper(env, node)
+		if retVal.Err != nil {
+			return retVal
+		}
+	}
+	return retVal
+}
+
+// Checks that an operator can be called with argCount arguments.
+func checkArgCount(operator *Operator, symbol string, argCount int) error {
+	if operator.minArgCount == operator.maxArgCount {
+		if argCount != operator.minArgCount {
+			return errors.New(
+				fmt.Sprintf("Received %d arguments for operator %s, expected: %d",
+					argCount, symbol, operator.minArgCount))
+		}
+	} else {
+		if argCount < operator.minArgCount {
+			return errors.New(
+				fmt.Sprintf("Received %d arguments for operator %s, minimum expected arguments: %d",
+					argCount, symbol, operator.minArgCount))
+		} else if argCount > operator.maxArgCount {
+			return errors.New(
+				fmt.Sprintf("Received %d arguments for operator %s, maximum expected arguments: %d",
+					argCount, symbol, operator.maxArgCount))
+		}
+	}
+	return nil
+}
+
+// Returns the operator which fn refers to, which is either the name of a method
+// or an operator, or a function. Returns nil if fn is neither.
+func resolveOperator(env *LangEnv, fn Value) *Operator {
+	switch f := fn.(type) {
+	case varValue:
+		return env.getOperator(f.varName)
+	case funcValue:
+		return f.op
+	}
+	return nil
+}
+
+// Calls the method, operator or function which fn refers to, with already
+// evaluated arguments. Operators which work on the raw AST cannot be called
+// this way.
+func callOperator(env *LangEnv, fn Value, args []Value) Atom {
+	var retVal Atom
+	operator := resolveOperator(env, fn)
+	if operator == nil {
+		retVal.Err = errors.New(fmt.Sprintf("Expected %s to be a method or an operator", fn.Str()))
+		return retVal
+	}
+	if operator.passRawAST || operator.expander != nil {
+		retVal.Err = errors.New(fmt.Sprintf("Operator %s cannot be called with evaluated arguments", operator.symbol))
+		return retVal
+	}
+	retVal.Err = checkArgCount(operator, operator.symbol, len(args))
+	if retVal.Err != nil {
+		return retVal
+	}
+	symbol := operator.symbol
+	if v, ok := fn.(varValue); ok {
+		symbol = v.varName
+	}
+	warnIfDeprecated(env, symbol, operator)
+
+	operands := make([]Atom, len(args))
+	for i, arg := range args {
+		if retVal.Err = checkSingleValue(operator.symbol, arg); retVal.Err != nil {
+			return retVal
+		}
+		operands[i].Val = prepareOperand(operator, arg)
+	}
+	return operator.handler(env, operands)
+}
+
+// Converts a cons cell passed to the operator to a list, unless the operator
+// works on cons cells, or calls a method which might.
+func prepareOperand(operator *Operator, v Value) Value {
+	if operator.keepsConsCells || operator.method != nil {
+		return v
+	}
+	return consToList(v)
+}
+
+// Evaluates the node, and returns the function it results in, or nil if it
+// does not result in one.
+func evalFunc(env *LangEnv, node *ASTNode) (Value, Atom) {
+	result := evalASTHelper(env, node)
+	if result.Err != nil {
+		return nil, result
+	}
+	if f, ok := result.Val.(funcValue); ok {
+		return f, result
+	}
+	return nil, result
+}
+
 func evalAST(env *LangEnv, node *ASTNode) Atom {
+	if !env.tracer.tracingAll {
+		return evalASTNode(env, node)
+	}
+	exp := StringifyAST(node)
+	if !node.isValue {
+		exp = describeAt(exp, node.pos)
+	}
+	env.tracer.enter(env, exp)
+	result := evalASTNode(env, node)
+	env.tracer.exit(env, result)
+	return result
+}
+
+func evalASTNode(env *LangEnv, node *ASTNode) Atom {
 	// printVarMap(env.varMap)
 	var retVal Atom
 	retVal.Err = nil
 
 	if node.isValue {
 		value, err := getValue(env, node.value)
+		if err != nil && env.getOperator(node.value) != nil {
+			// Operators like + are not valid variable names, but can still be
+			// passed around like methods.
+			value, err = new(varValue).newValue(node.value), nil
+		}
 		if err != nil {
 			retVal.Err = errors.New(fmt.Sprintf("%s %s", errStr("value", node.value), err))
 		} else {
@@ -82,38 +221,54 @@ func evalAST(env *LangEnv, node *ASTNode) Atom {
 		retVal.Err = errors.New("Cannot evaluate an empty expression")
 		return retVal
 	}
+	// A single element list is evaluated as the element itself, unless it is
+	// an operator or results in a function, in which case it is invoked without
+	// any arguments.
 	if len(node.children) == 1 {
-		return evalAST(env, node.children[0])
+		child := node.children[0]
+		if !child.isValue || env.getOperator(child.value) == nil {
+			f, result := evalFunc(env, child)
+			if f != nil {
+				return callOperator(env, f, []Value{})
+			}
+			return result
+		}
 	}
 
 	// Assuming that the first child is an operand
 	symbol := node.children[0].value
 	operator := env.getOperator(symbol)
-	if operator == nil {
-		retVal.Err = errors.New(fmt.Sprintf("Unknown operator '%s'", symbol))
+	if operator == nil || !node.children[0].isValue {
+		// The first child can also be an expression, or a variable, resulting in
+		// a function.
+		f, result := evalFunc(env, node.children[0])
+		if f == nil && node.children[0].isValue {
+			retVal.Err = errors.New(fmt.Sprintf("Unknown operator '%s'", symbol))
+			return retVal
+		}
+		if f == nil {
+			if result.Err == nil {
+				result.Err = errors.New(fmt.Sprintf("Expected %s to be a function, got %s",
+					StringifyAST(node.children[0]), result.Val.Str()))
+			}
+			return result
+		}
+		operator, symbol = f.(funcValue).op, f.Str()
+	}
+
+	retVal.Err = checkArgCount(operator, symbol, len(node.children)-1)
+	if retVal.Err != nil {
 		return retVal
 	}
+	warnIfDeprecated(env, symbol, operator)
 
-	if operator.minArgCount == operator.maxArgCount {
-		argCount := operator.minArgCount
-		if len(node.children)-1 != argCount {
-			retVal.Err = errors.New(
-				fmt.Sprintf("Received %d arguments for operator %s, expected: %d",
-					len(node.children)-1, symbol, argCount))
-			return retVal
-		}
-	} else {
-		if len(node.children)-1 < operator.minArgCount {
-			retVal.Err = errors.New(
-				fmt.Sprintf("Received %d arguments for operator %s, minimum expected arguments: %d",
-					len(node.children)-1, symbol, operator.minArgCount))
-			return retVal
-		} else if len(node.children)-1 > operator.maxArgCount {
-			retVal.Err = errors.New(
-				fmt.Sprintf("Received %d arguments for operator %s, maximum expected arguments: %d",
-					len(node.children)-1, symbol, operator.maxArgCount))
+	if operator.expander != nil {
+		expanded, err := operator.expander(node)
+		if err != nil {
+			retVal.Err = err
 			return retVal
 		}
+		return evalAST(env, expanded)
 	}
 
 	operands := make([]Atom, 0)
@@ -133,10 +288,22 @@ func evalAST(env *LangEnv, node *ASTNode) Atom {
 					return v
 				}
 			}
+			if v.Err = checkSingleValue(symbol, v.Val); v.Err != nil {
+				v.Val = nil
+				return v
+			}
+			v.Val = prepareOperand(operator, v.Val)
+			v.keywordArg = node.children[i].isValue && keywordValue{}.ofType(node.children[i].value)
 			operands = append(operands, v)
 		}
 	}
-	v := operator.handler(env, operands)
+	var v Atom
+	if operator.method != nil {
+		// Methods get where they were called from, for their stack traces.
+		v = operator.method.call(env, operands, node.pos)
+	} else {
+		v = operator.handler(env, operands)
+	}
 	if v.Err != nil {
 		return v
 	}
@@ -1,8 +1,14 @@
 package lang
 
 import (
+	"bytes"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -92,6 +98,7 @@ func TestBasicLang(t *testing.T) {
 	checkExprResultTest("(- -9223372036854775808 1)", "-9223372036854775809", t, env)
 	checkExprResultTest("(- 9223372036854775807 -1)", "9223372036854775808", t, env)
 	checkExprResultTest("(/ -9223372036854775808 -1)", "9223372036854775808", t, env)
+	checkExprResultTest("(divmod -9223372036854775808 -1)", "9223372036854775808 0", t, env)
 	checkExprResultTest("(* -9223372036854775808 -1)", "9223372036854775808", t, env)
 	checkExprResultTest("(* 9223372036854775807 2)", "18446744073709551614", t, env)
 
@@ -157,9 +164,7 @@ func TestMethods(t *testing.T) {
 
 	saneExprTest("(defun foo (x) (+ 1 x))", t, env)
 	checkExprResultTest("(foo 4)", "5", t, env)
-	// TODO: This should fail
-	// See https://github.com/reddragon/lambda/issues/10
-	// malformedExprTest("(foo)", t, env)
+	malformedExprTest("(foo)", t, env)
 	malformedExprTest("(foo 4 5)", t, env)
 
 	saneExprTest("(defvar p 1)", t, env)
@@ -260,3 +265,2863 @@ func TestValueInitializations(t *testing.T) {
 	checkTypeInitUsingStrMatches(iv, "12345678912345", t)
 	checkTypeInitUsingStrMatches(iv, "-12345678912345", t)
 }
+
+func TestAssert(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(assert true)", "nil", t, env)
+	checkExprResultTest("(assert (= 1 1))", "nil", t, env)
+	checkExprResultTest("(assert 0)", "nil", t, env)
+	malformedExprTest("(assert false)", t, env)
+	malformedExprTest("(assert nil)", t, env)
+	malformedExprTest("(assert (> 1 2))", t, env)
+	malformedExprTest("(assert undefinedVar)", t, env)
+
+	checkExprResultTest("(assert-equal (+ 1 2) 3)", "nil", t, env)
+	checkExprResultTest("(assert-equal (+ \"a\" \"b\") \"ab\")", "nil", t, env)
+	malformedExprTest("(assert-equal (+ 1 2) 4)", t, env)
+	malformedExprTest("(assert-equal 2 2.0)", t, env)
+
+	val := Eval("(assert-equal \"abc\" \"abd\")", env)
+	expected := "Assertion failed: expected: \"abd\", actual: \"abc\", first difference at index 2"
+	if val.ErrStr != expected {
+		t.Errorf("Expected the error to be %s, but was %s", expected, val.ErrStr)
+	}
+
+	// The index counts characters rather than bytes, from 0, and in the
+	// contents of strings.
+	for expr, want := range map[string]string{
+		"(assert-equal \"héllo\" \"héllö\")": "expected: \"héllö\", actual: \"héllo\", first difference at index 4",
+		"(assert-equal 12 13)":               "expected: 13, actual: 12, first difference at index 1",
+		"(assert-equal \"12\" \"13\")":       "expected: \"13\", actual: \"12\", first difference at index 1",
+		"(assert-equal 12 \"12\")":           "expected: \"12\", actual: 12, first difference at index 0",
+	} {
+		val = Eval(expr, env)
+		if val.ErrStr != "Assertion failed: "+want {
+			t.Errorf("Expected the error of %s to be %s, but was %s", expr, "Assertion failed: "+want, val.ErrStr)
+		}
+	}
+}
+
+func TestDefTest(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+
+	checkExprResultTest("(run-tests)", "true", t, env)
+	checkExprResultTest("(deftest addition (assert-equal (+ 1 2) 3))", "nil", t, env)
+	checkExprResultTest("(deftest comparison (defvar x 2) (assert (> x 1)) (assert (> x 3)))", "nil", t, env)
+	checkExprResultTest("(deftest scoping (assert-equal x 2))", "nil", t, env)
+	malformedExprTest("(deftest (foo) (assert true))", t, env)
+
+	out.Reset()
+	checkExprResultTest("(run-tests)", "false", t, env)
+	expected := "PASS: addition\n" +
+		"FAIL: comparison: Assertion failed: (> x 3)\n" +
+		"FAIL: scoping: Undefined variable: x\n" +
+		"3 tests, 1 passed, 2 failed\n"
+	if out.String() != expected {
+		t.Errorf("Expected the output of run-tests to be %q, but was %q", expected, out.String())
+	}
+
+	// Redefining a test replaces it.
+	checkExprResultTest("(deftest comparison (assert (> 2 1)))", "nil", t, env)
+	checkExprResultTest("(deftest scoping (assert true))", "nil", t, env)
+	checkExprResultTest("(run-tests)", "true", t, env)
+}
+
+func TestWhenUnless(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(when true 1)", "1", t, env)
+	checkExprResultTest("(when (> 2 1) (defvar x 1) (+ x 1))", "2", t, env)
+	checkExprResultTest("(when false 1)", "nil", t, env)
+	checkExprResultTest("(when nil 1)", "nil", t, env)
+	checkExprResultTest("(when 0 1)", "1", t, env)
+	malformedExprTest("(when true (/ 1 0) 2)", t, env)
+	malformedExprTest("(when undefinedVar 1)", t, env)
+
+	checkExprResultTest("(unless false 1)", "1", t, env)
+	checkExprResultTest("(unless nil (defvar y 2) (* y 3))", "6", t, env)
+	checkExprResultTest("(unless true 1)", "nil", t, env)
+	checkExprResultTest("(unless (> 2 1) (/ 1 0))", "nil", t, env)
+}
+
+func TestKeywordArguments(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	saneExprTest("(defun sub-xy (x y) (- x y))", t, env)
+	checkExprResultTest("(sub-xy 5 3)", "2", t, env)
+	checkExprResultTest("(sub-xy :x 5 :y 3)", "2", t, env)
+	checkExprResultTest("(sub-xy :y 3 :x 5)", "2", t, env)
+	checkExprResultTest("(sub-xy 5 :y 3)", "2", t, env)
+	checkExprResultTest("(:foo)", ":foo", t, env)
+
+	// Parameters without defaults cannot be left out.
+	saneExprTest("(defun x-unless-y (x y) (unless y x))", t, env)
+	checkExprResultTest("(x-unless-y :x 1 :y nil)", "1", t, env)
+	checkExprResultTest("(x-unless-y :x 1 :y 2)", "nil", t, env)
+	malformedExprTest("(x-unless-y :x 1)", t, env)
+	malformedExprTest("(x-unless-y :y 2)", t, env)
+
+	malformedExprTest("(sub-xy 5)", t, env)
+	malformedExprTest("(sub-xy 5 3 1)", t, env)
+	malformedExprTest("(sub-xy :z 1)", t, env)
+	malformedExprTest("(sub-xy :x 1 :x 2)", t, env)
+	malformedExprTest("(sub-xy 5 :x 1)", t, env)
+	malformedExprTest("(sub-xy :x 5 3)", t, env)
+	malformedExprTest("(sub-xy 5 :y)", t, env)
+
+	// Keyword values which are not written in the call are positional.
+	saneExprTest("(defun id (x) x)", t, env)
+	checkExprResultTest("(id :x)", ":x", t, env)
+	checkExprResultTest("((lambda (x) x) :url)", ":url", t, env)
+	saneExprTest("(defvar k :x)", t, env)
+	checkExprResultTest("(id k)", ":x", t, env)
+	malformedExprTest("(sub-xy k 1)", t, env)
+	checkExprResultTest("(pmap id (list :a :b))", "(:a :b)", t, env)
+}
+
+func TestDefaultParameters(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	saneExprTest("(defun add-b (a (b 10)) (+ a b))", t, env)
+	checkExprResultTest("(add-b 1)", "11", t, env)
+	checkExprResultTest("(add-b 1 2)", "3", t, env)
+	checkExprResultTest("(add-b :a 1)", "11", t, env)
+	checkExprResultTest("(add-b :b 1 :a 2)", "3", t, env)
+	if val := Eval("(add-b :b 5)", env); val.ErrStr != "Missing value for parameter a of method add-b." {
+		t.Errorf("Expected a missing argument error, but got %q", val.ErrStr)
+	}
+	malformedExprTest("(add-b)", t, env)
+	malformedExprTest("(add-b 1 2 3)", t, env)
+
+	// Defaults are evaluated at call time, and can refer to earlier parameters.
+	saneExprTest("(defvar offset 1)", t, env)
+	saneExprTest("(defun shifted (a (b (+ a offset))) b)", t, env)
+	checkExprResultTest("(shifted 1)", "2", t, env)
+	saneExprTest("(defvar offset 5)", t, env)
+	checkExprResultTest("(shifted 1)", "6", t, env)
+	checkExprResultTest("(shifted 1 0)", "0", t, env)
+
+	saneExprTest("(defun broken-default ((a (/ 1 0))) a)", t, env)
+	checkExprResultTest("(broken-default 1)", "1", t, env)
+	malformedExprTest("(broken-default)", t, env)
+	// A keyword without a value is passed positionally.
+	checkExprResultTest("(broken-default :a)", ":a", t, env)
+
+	malformedExprTest("(defun required-after-optional ((a 1) b) b)", t, env)
+	malformedExprTest("(defun malformed-default ((a 1 2)) a)", t, env)
+	malformedExprTest("(defun malformed-default (((a) 1)) a)", t, env)
+}
+
+func TestBigFloat(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("2.675M", "2.675", t, env)
+	checkExprResultTest("(+ 0.1M 0.2M)", "0.3", t, env)
+	checkExprResultTest("(+ 0.1 0.2)", "0.30000000000000004", t, env)
+	checkExprResultTest("(- 1M 0.9M)", "0.1", t, env)
+	checkExprResultTest("(* 1.1M 1.1M)", "1.21", t, env)
+	checkExprResultTest("(/ 1M 4M)", "0.25", t, env)
+	checkExprResultTest("(/ 1M 3M)",
+		"0.333333333333333333333333333333333333333333333333333333333333333333333333333", t, env)
+	malformedExprTest("(/ 1M 0M)", t, env)
+
+	// Other numeric types are promoted to big floats.
+	checkExprResultTest("(+ 0.1M 1)", "1.1", t, env)
+	checkExprResultTest("(+ 0.1M 0.2)", "0.3", t, env)
+	checkExprResultTest("(* 0.5M 111111111111111111111111111111)", "55555555555555555555555555555.5", t, env)
+
+	checkExprResultTest("(> 0.3M 0.2M)", "true", t, env)
+	checkExprResultTest("(>= 0.3M 0.3)", "true", t, env)
+	checkExprResultTest("(< 0.3M 1)", "true", t, env)
+	checkExprResultTest("(<= 0.3M 0.2M)", "false", t, env)
+	checkExprResultTest("(= (+ 0.1M 0.2M) 0.3M)", "true", t, env)
+
+	checkExprResultTest("(set-precision 64)", "64", t, env)
+	checkExprResultTest("(/ 1M 3M)", "0.33333333333333333", t, env)
+	malformedExprTest("(set-precision 0)", t, env)
+	malformedExprTest("(set-precision 1.5)", t, env)
+	// The precision also changes when it is set within a method.
+	saneExprTest("(defun low-precision () (set-precision 8))", t, env)
+	checkExprResultTest("(low-precision)", "8", t, env)
+	checkExprResultTest("(/ 1M 3M)", "0.3", t, env)
+}
+
+func TestRoundTo(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(round-to 2.675 2)", "2.68", t, env)
+	checkExprResultTest("(round-to 2.665 2)", "2.66", t, env)
+	checkExprResultTest("(round-to 2.5 0)", "2", t, env)
+	checkExprResultTest("(round-to 3.5 0)", "4", t, env)
+	checkExprResultTest("(round-to -2.675 2)", "-2.68", t, env)
+	checkExprResultTest("(round-to -2.5 0)", "-2", t, env)
+	checkExprResultTest("(round-to 1.2349 3)", "1.235", t, env)
+	checkExprResultTest("(round-to 0.125M 2)", "0.12", t, env)
+	checkExprResultTest("(round-to (/ 1M 3M) 4)", "0.3333", t, env)
+	checkExprResultTest("(round-to 7 2)", "7", t, env)
+	checkExprResultTest("(round-to 12345678901234567890123 1)", "12345678901234567890123", t, env)
+
+	malformedExprTest("(round-to 2.675 -1)", t, env)
+	malformedExprTest("(round-to 2.675 1.5)", t, env)
+	malformedExprTest("(round-to \"abc\" 1)", t, env)
+}
+
+func TestDumpEnv(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+
+	malformedExprTest("(dump-env)", t, env)
+
+	env.SetDebug(true)
+	saneExprTest("(defvar x 2)", t, env)
+	saneExprTest("(defun show (f) (dump-env))", t, env)
+	checkExprResultTest("(dump-env)", "nil", t, env)
+	for _, expected := range []string{"Scope depth: 0\n", "  x = 2 (intType)\n",
+		"  + -> +, args: 2..100, rawAST: false, resolveVars: true\n",
+		"  show -> show, args: 0..2, rawAST: false, resolveVars: true\n"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the output of dump-env to contain %q, but was %q", expected, out.String())
+		}
+	}
+
+	out.Reset()
+	checkExprResultTest("(show show)", "nil", t, env)
+	for _, expected := range []string{"Scope depth: 1\n", "  f -> show, args: 0..2"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected the output of dump-env to contain %q, but was %q", expected, out.String())
+		}
+	}
+}
+
+func TestTrace(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+
+	saneExprTest("(defvar x 2)", t, env)
+	checkExprResultTest("(trace (+ x (* 2 3)))", "8", t, env)
+	expected := "(+ x (* 2 3)) at 1:8\n" +
+		"  x\n" +
+		"  => 2\n" +
+		"  (* 2 3) at 1:13\n" +
+		"    2\n" +
+		"    => 2\n" +
+		"    3\n" +
+		"    => 3\n" +
+		"  => 6\n" +
+		"=> 8\n"
+	if out.String() != expected {
+		t.Errorf("Expected the trace to be %q, but was %q", expected, out.String())
+	}
+
+	out.Reset()
+	malformedExprTest("(trace (+ 1 (/ 1 0)))", t, env)
+	if !strings.HasSuffix(out.String(), "=> Error: divide by zero\n") {
+		t.Errorf("Expected the trace to end with the error, but was %q", out.String())
+	}
+
+	// Tracing is switched off after the traced expression.
+	out.Reset()
+	checkExprResultTest("(+ 1 2)", "3", t, env)
+	if out.Len() != 0 {
+		t.Errorf("Expected no trace output, but got %q", out.String())
+	}
+
+	saneExprTest("(defun fact (n) (cond ((= n 0) 1) (true (* n (fact (- n 1))))))", t, env)
+	checkExprResultTest("(trace-fn fact)", "nil", t, env)
+	checkExprResultTest("(fact 2)", "2", t, env)
+	expected = "(fact 2) at 1:1\n" +
+		"  (fact 1) at 1:46\n" +
+		"    (fact 0) at 1:46\n" +
+		"    => 1\n" +
+		"  => 1\n" +
+		"=> 2\n"
+	if out.String() != expected {
+		t.Errorf("Expected the trace to be %q, but was %q", expected, out.String())
+	}
+
+	out.Reset()
+	checkExprResultTest("(untrace-fn fact)", "nil", t, env)
+	checkExprResultTest("(fact 2)", "2", t, env)
+	if out.Len() != 0 {
+		t.Errorf("Expected no trace output, but got %q", out.String())
+	}
+	malformedExprTest("(trace-fn x)", t, env)
+	malformedExprTest("(trace-fn 1)", t, env)
+}
+
+func TestBlankInput(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	for _, query := range []string{"", " ", "\t", "  \n  "} {
+		val := Eval(query, env)
+		if len(val.ValStr) != 0 || len(val.ErrStr) != 0 || len(val.RemainingTokens) != 0 {
+			t.Errorf("Expected %q to evaluate to nothing, got value %q and error %q", query, val.ValStr, val.ErrStr)
+		}
+	}
+	checkExprResultTest("  (+ 1 2)  ", "3", t, env)
+}
+
+func TestSpecialFloats(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("nan", "nan", t, env)
+	checkExprResultTest("inf", "inf", t, env)
+	checkExprResultTest("-inf", "-inf", t, env)
+	checkExprResultTest("(/ 1.0 0)", "inf", t, env)
+	checkExprResultTest("(/ -1.0 0)", "-inf", t, env)
+	checkExprResultTest("(/ 0.0 0)", "nan", t, env)
+	checkExprResultTest("(+ inf 1)", "inf", t, env)
+	checkExprResultTest("(- inf inf)", "nan", t, env)
+	checkExprResultTest("(> inf 1)", "true", t, env)
+	checkExprResultTest("(< -inf 1)", "true", t, env)
+	malformedExprTest("(/ 1 0)", t, env)
+	malformedExprTest("(+ nan 1M)", t, env)
+
+	// Other spellings are identifiers.
+	malformedExprTest("NaN", t, env)
+	malformedExprTest("Infinity", t, env)
+	checkExprResultTest("(defvar Infinity 1)", "1", t, env)
+}
+
+func TestSafeDiv(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(safe-div 7 2)", "3", t, env)
+	checkExprResultTest("(safe-div 7.0 2)", "3.5", t, env)
+	checkExprResultTest("(safe-div 7 0)", "nil", t, env)
+	checkExprResultTest("(safe-div 7.0 0.0)", "nil", t, env)
+	checkExprResultTest("(safe-div 111111111111111111111111111111 0)", "nil", t, env)
+	checkExprResultTest("(safe-div 1M 0M)", "nil", t, env)
+	malformedExprTest("(safe-div \"a\" 1)", t, env)
+	malformedExprTest("(/ 7 0)", t, env)
+}
+
+func TestThreadingMacros(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(-> 5 (+ 3) (* 2))", "16", t, env)
+	checkExprResultTest("(-> 5 (- 3))", "2", t, env)
+	checkExprResultTest("(->> 5 (- 3))", "-2", t, env)
+	checkExprResultTest("(-> 5)", "5", t, env)
+	checkExprResultTest("(-> 5 inc (* 2) inc)", "13", t, env)
+	checkExprResultTest("(-> 5 (->> (- 8)))", "3", t, env)
+	malformedExprTest("(-> 5 (/ 0))", t, env)
+	malformedExprTest("(->)", t, env)
+
+	checkExprResultTest("(macroexpand (-> 5 (+ 3) (* 2)))", "\"(* (+ 5 3) 2)\"", t, env)
+	checkExprResultTest("(macroexpand (->> 5 (+ 3) (* 2)))", "\"(* 2 (+ 3 5))\"", t, env)
+	checkExprResultTest("(macroexpand (-> x inc))", "\"(inc x)\"", t, env)
+	checkExprResultTest("(macroexpand (-> 5 (->> (- 8))))", "\"(- 8 5)\"", t, env)
+	checkExprResultTest("(macroexpand (+ 1 2))", "\"(+ 1 2)\"", t, env)
+}
+
+func TestLet(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(list 1 2 3)", "(1 2 3)", t, env)
+	checkExprResultTest("(list 1 (list \"a\" 2.5) (list))", "(1 (\"a\" 2.5) ())", t, env)
+	checkExprResultTest("(list)", "()", t, env)
+
+	checkExprResultTest("(let ((a 1) (b 2)) (+ a b))", "3", t, env)
+	checkExprResultTest("(let ((a 1)) (defvar b 2) (+ a b))", "3", t, env)
+	malformedExprTest("b", t, env)
+	saneExprTest("(defvar a 10)", t, env)
+	checkExprResultTest("(let ((a 1) (b a)) b)", "10", t, env)
+	checkExprResultTest("(let* ((a 1) (b a)) b)", "1", t, env)
+	checkExprResultTest("a", "10", t, env)
+
+	checkExprResultTest("(let (((a b) (list 1 2))) (+ a b))", "3", t, env)
+	checkExprResultTest("(let (((a (b c)) (list 1 (list 2 3)))) (+ a (* b c)))", "7", t, env)
+	checkExprResultTest("(let (((a . rest) (list 1 2 3))) rest)", "(2 3)", t, env)
+	checkExprResultTest("(let (((a b . rest) (list 1 2))) rest)", "()", t, env)
+	checkExprResultTest("(let* (((a . rest) (list 1 2 3)) ((b c) rest)) (+ a b c))", "6", t, env)
+
+	malformedExprTest("(let (((a b) (list 1 2 3))) a)", t, env)
+	malformedExprTest("(let (((a b c) (list 1 2))) a)", t, env)
+	malformedExprTest("(let (((a b . rest) (list 1))) a)", t, env)
+	malformedExprTest("(let (((a b) 1)) a)", t, env)
+	malformedExprTest("(let ((1 2)) 1)", t, env)
+	malformedExprTest("(let ((a)) a)", t, env)
+	malformedExprTest("(let a a)", t, env)
+
+	// Parameters of methods and lambdas are destructured like let bindings,
+	// except for two-element lists starting with a name, which are defaults.
+	saneExprTest("(defun sum-pair (((x y) z) w) (+ x y z w))", t, env)
+	checkExprResultTest("(sum-pair (list (list 1 2) 3) 4)", "10", t, env)
+	checkExprResultTest("((lambda ((a . rest)) rest) (list 1 2 3))", "(2 3)", t, env)
+	checkExprResultTest("((lambda ((a b c)) (+ a b c)) (list 1 2 3))", "6", t, env)
+	checkExprResultTest("((lambda ((k (a b) . rest) (scale 1)) (list k (* scale (+ a b)) rest)) (list :x (list 1 2)) 10)", "(:x 30 ())", t, env)
+	checkExprResultTest("((lambda (x (y 2)) (list x y)) 1)", "(1 2)", t, env)
+	malformedExprTest("(sum-pair (list (list 1 2 3) 3) 4)", t, env)
+	malformedExprTest("(sum-pair 1 4)", t, env)
+	malformedExprTest("(sum-pair (list (list 1 2) 3))", t, env)
+	malformedExprTest("(defun bad-pattern ((x 1) (a b c)) a)", t, env)
+	malformedExprTest("(defun bad-pattern ((x 1 2)) x)", t, env)
+	malformedExprTest("(defun bad-pattern (()) 1)", t, env)
+	result := Eval("(defun bad-pattern ((x 1) (a b c)) a)", env)
+	if want := "Required parameter (a b c) follows optional parameters in method bad-pattern."; !strings.HasPrefix(result.ErrStr, want) {
+		t.Errorf("Expected the error to start with %q, but was %q", want, result.ErrStr)
+	}
+	result = Eval("(defun ambiguous ((a b) c) a)", env)
+	if want := "like (a b), is an optional parameter rather than a pattern."; !strings.HasSuffix(result.ErrStr, want) {
+		t.Errorf("Expected the error to end with %q, but was %q", want, result.ErrStr)
+	}
+}
+
+func TestMultipleValues(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(values 1 2)", "1 2", t, env)
+	checkExprResultTest("(values 1)", "1", t, env)
+	checkExprResultTest("(+ (values 1) 2)", "3", t, env)
+	malformedExprTest("(+ (values 1 2) 3)", t, env)
+	malformedExprTest("(+)", t, env)
+
+	saneExprTest("(defun two () (values 7 3))", t, env)
+	checkExprResultTest("(let-values (((q r) (two))) (list q r))", "(7 3)", t, env)
+	checkExprResultTest("(let-values (((q r) (values 7 3)) ((s) 1)) (+ q r s))", "11", t, env)
+	checkExprResultTest("(let-values (((q . rest) (values 1 2 3))) rest)", "(2 3)", t, env)
+	malformedExprTest("(let-values (((q r) (values 1 2 3))) q)", t, env)
+	malformedExprTest("(let-values ((q (values 1 2))) q)", t, env)
+
+	checkExprResultTest("(call-with-values two -)", "4", t, env)
+	checkExprResultTest("(call-with-values two list)", "(7 3)", t, env)
+	malformedExprTest("(call-with-values two 1)", t, env)
+	malformedExprTest("(call-with-values two let)", t, env)
+	saneExprTest("(defun three () (values 1 2 3))", t, env)
+	malformedExprTest("(call-with-values three -)", t, env)
+
+	// Multiple values cannot be passed where a single value is expected, so
+	// they do not end up in containers.
+	malformedExprTest("(list 0 (values 1 2) 3)", t, env)
+	malformedExprTest("(car (list (values 1 2)))", t, env)
+	malformedExprTest("(make-set (values 1 2))", t, env)
+	malformedExprTest("(equal? (list (two)) (list 7 3))", t, env)
+	malformedExprTest("((lambda (x) x) (two))", t, env)
+	malformedExprTest("(pmap (lambda (x) (values x x)) (list 1 2))", t, env)
+	malformedExprTest("(defvar both (two))", t, env)
+	checkExprResultTest("(list (values 1) 2)", "(1 2)", t, env)
+	// Methods can still return them.
+	saneExprTest("(defun also-two () (two))", t, env)
+	checkExprResultTest("(let-values (((q r) (also-two))) (- q r))", "4", t, env)
+}
+
+func TestDivMod(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(divmod 7 3)", "2 1", t, env)
+	checkExprResultTest("(let-values (((q r) (divmod 7 3))) (list q r))", "(2 1)", t, env)
+	checkExprResultTest("(divmod -7 2)", "-4 1", t, env)
+	checkExprResultTest("(divmod 7 -2)", "-3 1", t, env)
+	checkExprResultTest("(divmod -7 -2)", "4 1", t, env)
+	checkExprResultTest("(divmod 6 3)", "2 0", t, env)
+	checkExprResultTest("(divmod 111111111111111111111111111111 10)", "11111111111111111111111111111 1", t, env)
+	checkExprResultTest("(divmod -9223372036854775808 -1)", "9223372036854775808 0", t, env)
+	malformedExprTest("(divmod 7 0)", t, env)
+	malformedExprTest("(divmod 7.5 2)", t, env)
+}
+
+func TestForList(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(range 5)", "(0 1 2 3 4)", t, env)
+	checkExprResultTest("(range 2 5)", "(2 3 4)", t, env)
+	checkExprResultTest("(range 5 0 -2)", "(5 3 1)", t, env)
+	checkExprResultTest("(range 0)", "()", t, env)
+	malformedExprTest("(range 0 5 0)", t, env)
+	malformedExprTest("(range 1.5)", t, env)
+
+	checkExprResultTest("(for/list ((x (range 5))) (* x x))", "(0 1 4 9 16)", t, env)
+	checkExprResultTest("(for/list ((x (range 5)) :when (> x 2)) x)", "(3 4)", t, env)
+	checkExprResultTest("(for/list ((x (range 3)) (y (range x))) (list x y))",
+		"((1 0) (2 0) (2 1))", t, env)
+	checkExprResultTest("(for/list ((x (range 3)) :when (> x 0) (y (list 10 20))) (+ x y))",
+		"(11 21 12 22)", t, env)
+	checkExprResultTest("(for/list (((a b) (list (list 1 2) (list 3 4)))) (+ a b))", "(3 7)", t, env)
+	checkExprResultTest("(for/list ((x (list))) x)", "()", t, env)
+	// The iteration variable is not visible after the comprehension.
+	malformedExprTest("x", t, env)
+	malformedExprTest("(for/list ((x 5)) x)", t, env)
+	malformedExprTest("(for/list ((x (range 3)) :when) x)", t, env)
+	malformedExprTest("(for/list (x) x)", t, env)
+}
+
+func TestInterp(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("\"hello, world\"", "\"hello, world\"", t, env)
+	checkExprResultTest("(+ \"a (b) \" \"c\")", "\"a (b) c\"", t, env)
+	checkExprResultTest("(interp \"sum is ${(+ 1 2)}\")", "\"sum is 3\"", t, env)
+	Eval("(defvar name 'world')", env)
+	checkExprResultTest("(interp \"hello, ${name}!\")", "\"hello, world!\"", t, env)
+	checkExprResultTest("(interp \"${1}${2} ${(list 1 2)}\")", "\"12 (1 2)\"", t, env)
+	checkExprResultTest("(interp \"no placeholders\")", "\"no placeholders\"", t, env)
+	checkExprResultTest("(interp \"literal \\${(+ 1 2)}\")", "\"literal ${(+ 1 2)}\"", t, env)
+	checkExprResultTest("(interp \"${(+ 'a}' 'b')}\")", "\"a}b\"", t, env)
+	checkExprResultTest("(let ((x 5)) (interp \"x is ${x}\"))", "\"x is 5\"", t, env)
+	malformedExprTest("(interp \"${(+ 1 2)\")", t, env)
+	malformedExprTest("(interp \"${undefined}\")", t, env)
+	malformedExprTest("(interp \"${1 2}\")", t, env)
+	malformedExprTest("(interp 5)", t, env)
+}
+
+func TestCharPredicates(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("#\\a", "#\\a", t, env)
+	checkExprResultTest("#\\(", "#\\(", t, env)
+	checkExprResultTest("(list #\\space #\\newline #\\tab #\\é)", "(#\\space #\\newline #\\tab #\\é)", t, env)
+
+	checkExprResultTest("(alpha? #\\a)", "true", t, env)
+	checkExprResultTest("(alpha? #\\λ)", "true", t, env)
+	checkExprResultTest("(alpha? #\\1)", "false", t, env)
+	checkExprResultTest("(digit? #\\7)", "true", t, env)
+	checkExprResultTest("(digit? #\\x)", "false", t, env)
+	checkExprResultTest("(whitespace? #\\space)", "true", t, env)
+	checkExprResultTest("(whitespace? #\\tab)", "true", t, env)
+	checkExprResultTest("(whitespace? #\\))", "false", t, env)
+	checkExprResultTest("(upper? #\\A)", "true", t, env)
+	checkExprResultTest("(upper? #\\a)", "false", t, env)
+	checkExprResultTest("(lower? #\\ß)", "true", t, env)
+	checkExprResultTest("(char-upcase #\\a)", "#\\A", t, env)
+	checkExprResultTest("(char-upcase #\\ä)", "#\\Ä", t, env)
+	checkExprResultTest("(char-downcase #\\Q)", "#\\q", t, env)
+	checkExprResultTest("(char-downcase #\\1)", "#\\1", t, env)
+
+	malformedExprTest("(alpha? 'a')", t, env)
+	malformedExprTest("(digit? 7)", t, env)
+	malformedExprTest("(char-upcase 'a')", t, env)
+}
+
+func TestStringListConversion(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(string->list \"abc\")", "(#\\a #\\b #\\c)", t, env)
+	checkExprResultTest("(string->list \"a b\")", "(#\\a #\\space #\\b)", t, env)
+	checkExprResultTest("(string->list \"\")", "()", t, env)
+	checkExprResultTest("(string->list \"héllo, 世界\")",
+		"(#\\h #\\é #\\l #\\l #\\o #\\, #\\space #\\世 #\\界)", t, env)
+	checkExprResultTest("(list->string (list #\\h #\\i))", "\"hi\"", t, env)
+	checkExprResultTest("(list->string (list))", "\"\"", t, env)
+	checkExprResultTest("(list->string (string->list \"héllo, 世界 🎉\"))", "\"héllo, 世界 🎉\"", t, env)
+	checkExprResultTest("(list->string (for/list ((c (string->list \"abc\"))) (char-upcase c)))",
+		"\"ABC\"", t, env)
+	malformedExprTest("(string->list 5)", t, env)
+	malformedExprTest("(list->string \"abc\")", t, env)
+	malformedExprTest("(list->string (list #\\a 1))", t, env)
+}
+
+func TestAssoc(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(equal? 1 1)", "true", t, env)
+	checkExprResultTest("(equal? 1 1.0)", "false", t, env)
+	checkExprResultTest("(equal? (list 1 (list 2 'a')) (list 1 (list 2 'a')))", "true", t, env)
+	checkExprResultTest("(equal? (list 1 2) (list 1 2 3))", "false", t, env)
+	checkExprResultTest("(equal? \"a\" 'a')", "true", t, env)
+
+	Eval("(defvar colors (list (list :red 1) (list 'green' 2) (list (list 1 2) 3)))", env)
+	checkExprResultTest("(assoc :red colors)", "(:red 1)", t, env)
+	checkExprResultTest("(assoc 'green' colors)", "('green' 2)", t, env)
+	checkExprResultTest("(assoc (list 1 2) colors)", "((1 2) 3)", t, env)
+	checkExprResultTest("(assoc :blue colors)", "nil", t, env)
+	checkExprResultTest("(assoc 1 (list))", "nil", t, env)
+	checkExprResultTest("(assoc 1 (list (list 1 'a') (list 1 'b')))", "(1 'a')", t, env)
+
+	checkExprResultTest("(assq :red colors)", "(:red 1)", t, env)
+	// A list with the same elements is equal, but not identical.
+	checkExprResultTest("(assq (list 1 2) colors)", "nil", t, env)
+	Eval("(defvar key (list 1 2))", env)
+	Eval("(defvar table (list (list key 'found')))", env)
+	checkExprResultTest("(assq key table)", "((1 2) 'found')", t, env)
+
+	malformedExprTest("(assoc 1 2)", t, env)
+	malformedExprTest("(assoc 1 (list 1 2))", t, env)
+	malformedExprTest("(assq 1 (list (list 1 2 3)))", t, env)
+}
+
+func TestMember(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(member 2 (list 1 2 3))", "(2 3)", t, env)
+	checkExprResultTest("(member 1 (list 1 2 1))", "(1 2 1)", t, env)
+	checkExprResultTest("(member 4 (list 1 2 3))", "nil", t, env)
+	checkExprResultTest("(member 'b' (list :a 'b' 1.5))", "('b' 1.5)", t, env)
+	checkExprResultTest("(member 1 (list))", "nil", t, env)
+	// Nested lists are compared as a whole, and are not searched.
+	checkExprResultTest("(member (list 2 3) (list 1 (list 2 3) 4))", "((2 3) 4)", t, env)
+	checkExprResultTest("(member 2 (list 1 (list 2 3) 4))", "nil", t, env)
+
+	checkExprResultTest("(member? 2 (list 1 2 3))", "true", t, env)
+	checkExprResultTest("(member? 2.0 (list 1 2 3))", "false", t, env)
+	checkExprResultTest("(member? (list) (list 1 (list)))", "true", t, env)
+	malformedExprTest("(member 1 2)", t, env)
+	malformedExprTest("(member? 1 'abc')", t, env)
+}
+
+func TestTakeDrop(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(take 2 (list 1 2 3))", "(1 2)", t, env)
+	checkExprResultTest("(take 0 (list 1 2 3))", "()", t, env)
+	checkExprResultTest("(take 5 (list 1 2 3))", "(1 2 3)", t, env)
+	checkExprResultTest("(take 2 (list))", "()", t, env)
+	checkExprResultTest("(drop 2 (list 1 2 3))", "(3)", t, env)
+	checkExprResultTest("(drop 0 (list 1 2 3))", "(1 2 3)", t, env)
+	checkExprResultTest("(drop 5 (list 1 2 3))", "()", t, env)
+	checkExprResultTest("(take 2 (drop 2 (range 10)))", "(2 3)", t, env)
+	malformedExprTest("(take -1 (list 1 2 3))", t, env)
+	malformedExprTest("(drop -1 (list 1 2 3))", t, env)
+	malformedExprTest("(take 1.5 (list 1 2 3))", t, env)
+	malformedExprTest("(drop 1 2)", t, env)
+}
+
+func TestFlatten(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(flatten (list 1 (list 2 (list 3 (list 4))) 5))", "(1 2 3 4 5)", t, env)
+	checkExprResultTest("(flatten (list 1 (list 2 (list 3 (list 4))) 5) 1)", "(1 2 (3 (4)) 5)", t, env)
+	checkExprResultTest("(flatten (list 1 (list 2 (list 3 (list 4))) 5) 2)", "(1 2 3 (4) 5)", t, env)
+	checkExprResultTest("(flatten (list 1 (list 2)) 0)", "(1 (2))", t, env)
+	checkExprResultTest("(flatten (list 'a' :b (list) (list nil)))", "('a' :b nil)", t, env)
+	checkExprResultTest("(flatten (list))", "()", t, env)
+	checkExprResultTest("(flatten (for/list ((x (range 3))) (list x x)))", "(0 0 1 1 2 2)", t, env)
+	malformedExprTest("(flatten 1)", t, env)
+	malformedExprTest("(flatten (list 1) -1)", t, env)
+}
+
+func TestZip(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(zip (list 1 2) (list \"a\" \"b\"))", "((1 \"a\") (2 \"b\"))", t, env)
+	checkExprResultTest("(zip (list 1 2 3) (list :a :b))", "((1 :a) (2 :b))", t, env)
+	checkExprResultTest("(zip (list 1 2) (list 3 4) (list 5 6))", "((1 3 5) (2 4 6))", t, env)
+	checkExprResultTest("(zip (list 1 2))", "((1) (2))", t, env)
+	checkExprResultTest("(zip (list 1 2) (list))", "()", t, env)
+	malformedExprTest("(zip (list 1 2) 3)", t, env)
+
+	checkExprResultTest("(unzip (list (list 1 :a) (list 2 :b)))", "((1 2) (:a :b))", t, env)
+	checkExprResultTest("(unzip (zip (list 1 2) (list 3 4) (list 5 6)))", "((1 2) (3 4) (5 6))", t, env)
+	checkExprResultTest("(unzip (list))", "()", t, env)
+	checkExprResultTest("(let (((xs ys) (unzip (list (list 1 2) (list 3 4))))) ys)", "(2 4)", t, env)
+	malformedExprTest("(unzip (list (list 1 2) (list 3)))", t, env)
+	malformedExprTest("(unzip (list 1 2))", t, env)
+}
+
+func TestPartition(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defun big (x) (> x 2))", env)
+	checkExprResultTest("(partition big (list 1 5 2 4 3))", "(5 4 3) (1 2)", t, env)
+	checkExprResultTest("(partition big (list))", "() ()", t, env)
+	checkExprResultTest("(partition digit? (string->list \"a1b2\"))", "(#\\1 #\\2) (#\\a #\\b)", t, env)
+	checkExprResultTest("(let-values (((matching rest) (partition big (range 5)))) rest)", "(0 1 2)", t, env)
+	malformedExprTest("(partition big 5)", t, env)
+	malformedExprTest("(partition 5 (list 1))", t, env)
+	malformedExprTest("(partition digit? (list 1))", t, env)
+}
+
+func TestGroupBy(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(even? 4)", "true", t, env)
+	checkExprResultTest("(even? -3)", "false", t, env)
+	checkExprResultTest("(odd? -3)", "true", t, env)
+	checkExprResultTest("(odd? 100000000000000000000001)", "true", t, env)
+	malformedExprTest("(even? 2.0)", t, env)
+
+	checkExprResultTest("(group-by even? (range 6))", "{true: (0 2 4), false: (1 3 5)}", t, env)
+	checkExprResultTest("(get (group-by even? (range 6)) false)", "(1 3 5)", t, env)
+	checkExprResultTest("(get (group-by even? (range 6)) 1)", "nil", t, env)
+	checkExprResultTest("(get (group-by even? (range 6)) 1 (list))", "()", t, env)
+	checkExprResultTest("(group-by even? (list))", "{}", t, env)
+
+	Eval("(defun first-char (s) (take 1 (string->list s)))", env)
+	checkExprResultTest("(group-by first-char (list 'apple' 'avocado' 'banana'))",
+		"{(#\\a): ('apple' 'avocado'), (#\\b): ('banana')}", t, env)
+	// Keys are looked up using equal?.
+	checkExprResultTest("(get (group-by first-char (list 'apple')) (list #\\a))", "('apple')", t, env)
+	checkExprResultTest("(equal? (group-by even? (list 1 2)) (group-by even? (list 2 1)))", "true", t, env)
+
+	malformedExprTest("(group-by even? 5)", t, env)
+	malformedExprTest("(group-by even? (list 1.5))", t, env)
+	malformedExprTest("(get (list 1 2) 0)", t, env)
+}
+
+func TestFrequencies(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(frequencies (list \"a\" \"b\" \"a\"))", "{\"a\": 2, \"b\": 1}", t, env)
+	checkExprResultTest("(get (frequencies (list \"a\" \"b\" \"a\")) 'a')", "2", t, env)
+	checkExprResultTest("(get (frequencies (list 1 2)) 3 0)", "0", t, env)
+	checkExprResultTest("(frequencies (list 1 1.5 (list 1) (list 1)))", "{1: 1, 1.5: 1, (1): 2}", t, env)
+	checkExprResultTest("(frequencies (list))", "{}", t, env)
+	malformedExprTest("(frequencies 'abc')", t, env)
+}
+
+func TestDistinct(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(distinct (list 3 1 3 2 1))", "(3 1 2)", t, env)
+	checkExprResultTest("(distinct (list 1 1.5 'a' \"a\" :a))", "(1 1.5 'a' :a)", t, env)
+	checkExprResultTest("(distinct (list (list 1 2) (list 1 2) (list 2 1)))", "((1 2) (2 1))", t, env)
+	checkExprResultTest("(distinct (list))", "()", t, env)
+	checkExprResultTest("(distinct (flatten (for/list ((x (range 3))) (range x))))", "(0 1)", t, env)
+	malformedExprTest("(distinct 1)", t, env)
+}
+
+func TestSets(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(make-set 1 2 3)", "#{1 2 3}", t, env)
+	checkExprResultTest("(make-set 1 2 1 'a' \"a\")", "#{1 2 'a'}", t, env)
+	checkExprResultTest("(make-set)", "#{}", t, env)
+
+	Eval("(defvar s (make-set 1 2 3))", env)
+	checkExprResultTest("(set-add s 4 1)", "#{1 2 3 4}", t, env)
+	// Sets are never modified in place.
+	checkExprResultTest("s", "#{1 2 3}", t, env)
+	checkExprResultTest("(set-member? s 2)", "true", t, env)
+	checkExprResultTest("(set-member? s 2.5)", "false", t, env)
+	checkExprResultTest("(set-member? (make-set (list 1 2)) (list 1 2))", "true", t, env)
+
+	checkExprResultTest("(set-union s (make-set 3 4) (make-set 5))", "#{1 2 3 4 5}", t, env)
+	checkExprResultTest("(set-intersection s (make-set 3 2 7))", "#{2 3}", t, env)
+	checkExprResultTest("(set-intersection s (make-set 3 2) (make-set 3))", "#{3}", t, env)
+	checkExprResultTest("(set-difference s (make-set 2) (make-set 3))", "#{1}", t, env)
+	checkExprResultTest("(set-difference s)", "#{1 2 3}", t, env)
+	checkExprResultTest("s", "#{1 2 3}", t, env)
+
+	checkExprResultTest("(equal? (make-set 1 2) (make-set 2 1))", "true", t, env)
+	checkExprResultTest("(equal? (make-set 1 2) (make-set 1 2 3))", "false", t, env)
+	checkExprResultTest("(distinct (list (make-set 1 2) (make-set 2 1)))", "(#{1 2})", t, env)
+
+	malformedExprTest("(set-add (list 1) 2)", t, env)
+	malformedExprTest("(set-member? 1 1)", t, env)
+	malformedExprTest("(set-union s (list 1))", t, env)
+}
+
+func TestTryThread(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	malformedExprTest("(error 'boom')", t, env)
+	checkExprResultTest("(try-> 5 (+ 3) (* 2))", "16", t, env)
+	checkExprResultTest("(try-> 2 (take (list 1 2 3)))", "(1 2)", t, env)
+	checkExprResultTest("(try-> 5 (/ 0) (+ 1))", "#<error: divide by zero>", t, env)
+	checkExprResultTest("(try-> (error 'boom') (+ 1))", "#<error: boom>", t, env)
+	checkExprResultTest("(error? (try-> 1 (/ 0)))", "true", t, env)
+	checkExprResultTest("(error? (try-> 1 (/ 1)))", "false", t, env)
+	checkExprResultTest("(error-message (try-> 1 (/ 0)))", "\"divide by zero\"", t, env)
+
+	// The steps after an error are not evaluated.
+	Eval("(defun fail (x) (error (interp \"bad ${x}\")))", env)
+	checkExprResultTest("(try-> 1 inc fail (undefined))", "#<error: bad 2>", t, env)
+	// A step which results in an error value also short-circuits.
+	Eval("(defun inner (x) (try-> x (/ 0)))", env)
+	checkExprResultTest("(try-> 1 inner inc)", "#<error: divide by zero>", t, env)
+	checkExprResultTest("(try-> 1 ())", "#<error: Expected an expression to thread through, got ().>", t, env)
+	malformedExprTest("(error-message 1)", t, env)
+}
+
+func TestBeginPrint(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+
+	checkExprResultTest("(begin 1 2 3)", "3", t, env)
+	checkExprResultTest("(print \"a b\" 1 (list 'c'))", "nil", t, env)
+	checkExprResultTest("(begin (print 'x') (+ 1 1))", "2", t, env)
+	if out.String() != "a b 1 ('c')\nx\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+	malformedExprTest("(begin 1 (undefined) 3)", t, env)
+}
+
+func TestDelayForce(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+
+	Eval("(defvar p (delay (begin (print \"once\") 42)))", env)
+	checkExprResultTest("p", "#<promise>", t, env)
+	if out.Len() != 0 {
+		t.Errorf("Expected the promise to not be evaluated yet, got output %q", out.String())
+	}
+	checkExprResultTest("(force p)", "42", t, env)
+	checkExprResultTest("(force p)", "42", t, env)
+	if out.String() != "once\n" {
+		t.Errorf("Expected the promise to be evaluated once, got output %q", out.String())
+	}
+	checkExprResultTest("p", "#<promise: 42>", t, env)
+	checkExprResultTest("(force 5)", "5", t, env)
+
+	// Promises capture the environment they were created in.
+	Eval("(defun make-promise (x) (delay (* x 2)))", env)
+	checkExprResultTest("(force (make-promise 21))", "42", t, env)
+	checkExprResultTest("(let ((x 1)) (force (let ((x 2)) (delay x))))", "2", t, env)
+
+	// Errors are raised when forcing, and the promise can be forced again.
+	Eval("(defvar bad (delay (/ 1 0)))", env)
+	malformedExprTest("(force bad)", t, env)
+	checkExprResultTest("bad", "#<promise>", t, env)
+
+	// Forcing a promise from within its own evaluation is an error.
+	Eval("(defvar self (delay (+ 1 (force self))))", env)
+	malformedExprTest("(force self)", t, env)
+	checkExprResultTest("self", "#<promise>", t, env)
+
+	// Tasks forcing a promise at the same time wait for a single evaluation.
+	Eval("(defvar runs (atom 0))", env)
+	Eval("(defvar shared (delay (begin (swap! runs inc) (sleep 0.02) 42)))", env)
+	checkExprResultTest("(pmap (lambda (i) (force shared)) (range 8))", "(42 42 42 42 42 42 42 42)", t, env)
+	checkExprResultTest("(deref runs)", "1", t, env)
+	// If the evaluation fails, the waiting tasks evaluate it again.
+	Eval("(defvar failing (delay (begin (sleep 0.02) (/ 1 0))))", env)
+	checkExprResultTest("(pmap (lambda (i) (error? (try-> (force failing)))) (range 4))", "(true true true true)", t, env)
+}
+
+func TestStreams(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+
+	Eval("(defun naturals-from (n) (stream-cons n (naturals-from (+ n 1))))", env)
+	Eval("(defvar naturals (naturals-from 0))", env)
+	checkExprResultTest("naturals", "#<stream: 0 ...>", t, env)
+	checkExprResultTest("(stream-car naturals)", "0", t, env)
+	checkExprResultTest("(stream-car (stream-cdr (stream-cdr naturals)))", "2", t, env)
+	checkExprResultTest("(stream-take 5 naturals)", "(0 1 2 3 4)", t, env)
+	checkExprResultTest("(stream-take 0 naturals)", "()", t, env)
+
+	Eval("(defun double (x) (* x 2))", env)
+	checkExprResultTest("(stream-take 5 (stream-iterate double 1))", "(1 2 4 8 16)", t, env)
+
+	// Streams end with a nil tail.
+	Eval("(defvar short (stream-cons 1 (stream-cons 2 nil)))", env)
+	checkExprResultTest("(stream-take 5 short)", "(1 2)", t, env)
+	checkExprResultTest("(stream-cdr (stream-cdr short))", "nil", t, env)
+
+	// Tails are only computed when needed, and at most once.
+	Eval("(defvar noisy (stream-cons 1 (begin (print 'tail') (stream-cons 2 nil))))", env)
+	checkExprResultTest("(stream-take 1 noisy)", "(1)", t, env)
+	checkExprResultTest("(stream-take 2 noisy)", "(1 2)", t, env)
+	checkExprResultTest("(stream-take 2 noisy)", "(1 2)", t, env)
+	if out.String() != "tail\n" {
+		t.Errorf("Expected the tail to be computed once, got output %q", out.String())
+	}
+
+	malformedExprTest("(stream-car (list 1))", t, env)
+	malformedExprTest("(stream-cdr (stream-cons 1 2))", t, env)
+	malformedExprTest("(stream-take -1 naturals)", t, env)
+	malformedExprTest("(stream-cons (undefined) 1)", t, env)
+}
+
+func TestPartialCurry(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("((partial + 10) 5)", "15", t, env)
+	checkExprResultTest("((partial + 1 2) 3 4)", "10", t, env)
+	checkExprResultTest("((partial list 1 2))", "(1 2)", t, env)
+	checkExprResultTest("(partial + 10)", "#<function partial>", t, env)
+	Eval("(defvar add10 (partial + 10))", env)
+	checkExprResultTest("(add10 5)", "15", t, env)
+	checkExprResultTest("(partition (partial > 3) (list 1 5 2 4))", "(1 2) (5 4)", t, env)
+	Eval("(defun apply-twice (f x) (f (f x)))", env)
+	checkExprResultTest("(apply-twice add10 1)", "21", t, env)
+	checkExprResultTest("((partial (partial + 1) 2) 3)", "6", t, env)
+	malformedExprTest("(partial 5 1)", t, env)
+	malformedExprTest("((partial divmod 1) 2 3)", t, env)
+
+	Eval("(defun add3 (a b c) (+ a (+ b c)))", env)
+	checkExprResultTest("((((curry add3) 1) 2) 3)", "6", t, env)
+	checkExprResultTest("(((curry add3) 1 2) 3)", "6", t, env)
+	checkExprResultTest("((curry add3) 1 2 3)", "6", t, env)
+	checkExprResultTest("(((curry divmod) 7) 2)", "3 1", t, env)
+	Eval("(defun scale (x (factor 2)) (* x factor))", env)
+	checkExprResultTest("((curry scale) 4)", "8", t, env)
+	malformedExprTest("(curry +)", t, env)
+	malformedExprTest("(curry 5)", t, env)
+
+	malformedExprTest("(5 1 2)", t, env)
+	malformedExprTest("((+ 1 2) 3)", t, env)
+}
+
+func TestCompose(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("((compose inc (partial * 2)) 5)", "11", t, env)
+	checkExprResultTest("((compose (partial * 2) inc) 5)", "12", t, env)
+	checkExprResultTest("((compose inc) 5)", "6", t, env)
+	checkExprResultTest("((compose inc inc +) 1 2 3)", "8", t, env)
+	checkExprResultTest("((compose list->string (partial take 2) string->list) 'hello')", "\"he\"", t, env)
+	// Errors from any of the functions are raised.
+	malformedExprTest("((compose inc (partial / 1)) 0)", t, env)
+	malformedExprTest("((compose (partial / 1) inc) -1)", t, env)
+	malformedExprTest("(compose inc 5)", t, env)
+}
+
+func TestIdentityConstantly(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(identity 5)", "5", t, env)
+	checkExprResultTest("(identity (list 1 'a'))", "(1 'a')", t, env)
+	checkExprResultTest("(partition identity (list 1 nil false 2))", "(1 2) (nil false)", t, env)
+	checkExprResultTest("(group-by identity (list 1 2 1))", "{1: (1 1), 2: (2)}", t, env)
+
+	checkExprResultTest("((constantly 5))", "5", t, env)
+	checkExprResultTest("((constantly 5) 1 2 3)", "5", t, env)
+	checkExprResultTest("(group-by (constantly :all) (list 1 2))", "{:all: (1 2)}", t, env)
+	checkExprResultTest("((compose (constantly 'x') identity) 1)", "'x'", t, env)
+	malformedExprTest("(identity 1 2)", t, env)
+}
+
+func TestLambda(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("((lambda (a b) (+ a b)) 1 2)", "3", t, env)
+	checkExprResultTest("((lambda () 5))", "5", t, env)
+	checkExprResultTest("((lambda (a (b 10)) (+ a b)) 1)", "11", t, env)
+	checkExprResultTest("((lambda (a b) (- a b)) :b 1 :a 5)", "4", t, env)
+	checkExprResultTest("((lambda (x) (defvar y (* x 2)) (+ y 1)) 4)", "9", t, env)
+	checkExprResultTest("(partition (lambda (x) (> x 2)) (range 5))", "(3 4) (0 1 2)", t, env)
+	checkExprResultTest("(((curry (lambda (a b c) (list a b c))) 1) 2 3)", "(1 2 3)", t, env)
+
+	// Lambdas are evaluated in the environment they were created in.
+	Eval("(defun adder (n) (lambda (x) (+ x n)))", env)
+	Eval("(defvar add5 (adder 5))", env)
+	checkExprResultTest("(add5 1)", "6", t, env)
+	Eval("(defvar n 100)", env)
+	checkExprResultTest("(add5 1)", "6", t, env)
+	checkExprResultTest("(let ((n 1)) ((lambda () n)))", "1", t, env)
+
+	malformedExprTest("(lambda x x)", t, env)
+	malformedExprTest("(lambda (1) 1)", t, env)
+	malformedExprTest("((lambda (a) a))", t, env)
+	malformedExprTest("((lambda (a) a) 1 2)", t, env)
+}
+
+func TestAnonFnSyntax(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(#(+ %1 %2) 1 2)", "3", t, env)
+	checkExprResultTest("(#(* % %) 3)", "9", t, env)
+	checkExprResultTest("(#(list %2 %1) 1 2)", "(2 1)", t, env)
+	checkExprResultTest("(#(list %3) 1 2 3)", "(3)", t, env)
+	checkExprResultTest("(#(+ 1 2))", "3", t, env)
+	checkExprResultTest("(partition #(> % 2) (range 5))", "(3 4) (0 1 2)", t, env)
+	checkExprResultTest("(for/list ((f (list #(+ % 1) #(* % 2)))) (f 5))", "(6 10)", t, env)
+	checkExprResultTest("(macroexpand #(+ %1 %2))", "\"(lambda (%arg1 %arg2) (+ %arg1 %arg2))\"", t, env)
+	// The arguments are counted from the highest one referred to.
+	malformedExprTest("(#(list %2) 1)", t, env)
+	malformedExprTest("#(", t, env)
+}
+
+func TestIf(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(if true 1 2)", "1", t, env)
+	checkExprResultTest("(if nil 1 2)", "2", t, env)
+	checkExprResultTest("(if (> 1 2) 1)", "nil", t, env)
+	checkExprResultTest("(if 0 'zero' 'other')", "'zero'", t, env)
+	// Only the branch taken is evaluated.
+	checkExprResultTest("(if true 1 (undefined))", "1", t, env)
+	malformedExprTest("(if (undefined) 1 2)", t, env)
+}
+
+func TestRecur(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defun sum-to (n acc) (if (= n 0) acc (recur (- n 1) (+ acc n))))", env)
+	checkExprResultTest("(sum-to 10 0)", "55", t, env)
+	// Deeper than the recursion limit, since recur does not nest calls.
+	checkExprResultTest("(sum-to 120000 0)", "7200060000", t, env)
+
+	Eval("(defun count-down (n) (cond ((= n 0) 'done') (true (begin (+ 1 1) (recur (- n 1))))))", env)
+	checkExprResultTest("(count-down 5)", "'done'", t, env)
+	checkExprResultTest("((lambda (n acc) (if (= n 0) acc (recur (- n 1) (* acc n)))) 20 1)", "2432902008176640000", t, env)
+
+	// recur can only be used in tail position.
+	malformedExprTest("(defun bad (n) (+ 1 (recur n)))", t, env)
+	malformedExprTest("(defun bad (n) (if (recur n) 1 2))", t, env)
+	malformedExprTest("(defun bad (n) (begin (recur n) 1))", t, env)
+	malformedExprTest("(lambda (n) (list (recur n)))", t, env)
+	malformedExprTest("bad", t, env)
+	// A lambda inside a method has its own tail positions.
+	Eval("(defun make (n) (lambda (x) (if (= x 0) n (recur (- x 1)))))", env)
+	checkExprResultTest("((make 7) 3)", "7", t, env)
+
+	malformedExprTest("(recur 1)", t, env)
+	malformedExprTest("(sum-to 1)", t, env)
+	Eval("(defun wrong (n) (recur))", env)
+	malformedExprTest("(wrong 1)", t, env)
+}
+
+func TestRecursionLimit(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defun deep (n) (if (= n 0) 0 (+ 1 (deep (- n 1)))))", env)
+	checkExprResultTest("(deep 1000)", "1000", t, env)
+	// Deeper than the Go stack allows for a body this nested, which would crash
+	// the process without the limit.
+	Eval("(defun nested (n) (let ((m n)) (cond ((= m 0) 0) (true (begin (+ 1 (let ((k m)) (when true (if true (+ 0 (nested (- k 1))) 0)))))))))", env)
+	malformedExprTest("(nested 200000)", t, env)
+	checkExprResultTest("(error-message (try-> 20000 deep))",
+		"\"stack-overflow: reached the recursion limit of 10000 calls in deep\"", t, env)
+	// The environment can still be used after the error.
+	checkExprResultTest("(deep 10)", "10", t, env)
+
+	env.SetMaxRecursionDepth(50)
+	checkExprResultTest("(deep 49)", "49", t, env)
+	malformedExprTest("(deep 50)", t, env)
+	// Methods defined before the limit was set, and lambdas, have it too.
+	checkExprResultTest("(error? (try-> 100 nested))", "true", t, env)
+	checkExprResultTest("(error? (try-> 100 (lambda (n) (deep n))))", "true", t, env)
+	// recur does not nest calls.
+	Eval("(defun sum-to (n acc) (if (= n 0) acc (recur (- n 1) (+ acc n))))", env)
+	checkExprResultTest("(sum-to 1000 0)", "500500", t, env)
+}
+
+func TestLoop(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(inc 1)", "2", t, env)
+	checkExprResultTest("(dec 1.5)", "0.5", t, env)
+	checkExprResultTest("(inc 9223372036854775807)", "9223372036854775808", t, env)
+	malformedExprTest("(inc 'a')", t, env)
+
+	checkExprResultTest("(loop ((i 0) (acc 0)) (if (= i 10) acc (recur (inc i) (+ acc i))))", "45", t, env)
+	checkExprResultTest("(loop ((i 120000)) (if (= i 0) 'done' (recur (dec i))))", "'done'", t, env)
+	checkExprResultTest("(loop ((x 1)) (defvar y (* x 2)) (if (> y 100) y (recur y)))", "128", t, env)
+	checkExprResultTest("(loop () 5)", "5", t, env)
+	// Bindings are evaluated in the surrounding environment.
+	Eval("(defvar start 3)", env)
+	checkExprResultTest("(loop ((i start) (acc (list))) (if (= i 0) acc (recur (dec i) (list i acc))))", "(1 (2 (3 ())))", t, env)
+	// A loop inside a method has its own recur target.
+	Eval("(defun fact (n) (loop ((i n) (acc 1)) (if (= i 0) acc (recur (dec i) (* acc i)))))", env)
+	checkExprResultTest("(fact 10)", "3628800", t, env)
+	Eval("(defun outer (n) (if (= n 0) 'end' (recur (loop ((i n)) (dec i)))))", env)
+	checkExprResultTest("(outer 3)", "'end'", t, env)
+
+	malformedExprTest("(loop ((i 0)) (+ 1 (recur i)))", t, env)
+	malformedExprTest("(defun bad (n) (loop ((i (recur n))) i))", t, env)
+	malformedExprTest("(loop ((i 0)) (recur))", t, env)
+	malformedExprTest("(loop (i 0) i)", t, env)
+	malformedExprTest("(loop ((1 0)) 1)", t, env)
+}
+
+func TestReadLine(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	env.SetInput(strings.NewReader("first line\r\nsecond\n\nlast"))
+
+	checkExprResultTest("(read-line)", "\"first line\"", t, env)
+	checkExprResultTest("((lambda () (read-line)))", "\"second\"", t, env)
+	checkExprResultTest("(read-line)", "\"\"", t, env)
+	checkExprResultTest("(read-line)", "\"last\"", t, env)
+	checkExprResultTest("(read-line)", "nil", t, env)
+	checkExprResultTest("(read-line)", "nil", t, env)
+	malformedExprTest("(read-line 1)", t, env)
+}
+
+func TestFiles(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	dir, err := ioutil.TempDir("", "lambda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Eval(fmt.Sprintf("(defvar path '%s/notes.txt')", dir), env)
+
+	checkExprResultTest("(file-exists? path)", "false", t, env)
+	checkExprResultTest("(write-file path 'hello, world')", "nil", t, env)
+	checkExprResultTest("(file-exists? path)", "true", t, env)
+	checkExprResultTest("(read-file path)", "\"hello, world\"", t, env)
+	checkExprResultTest("(write-file path \"\")", "nil", t, env)
+	checkExprResultTest("(read-file path)", "\"\"", t, env)
+	// Errors from the file system can be caught.
+	checkExprResultTest(fmt.Sprintf("(error? (try-> '%s/missing.txt' read-file))", dir), "true", t, env)
+	malformedExprTest(fmt.Sprintf("(write-file '%s/missing/notes.txt' 'x')", dir), t, env)
+	malformedExprTest("(read-file 1)", t, env)
+	malformedExprTest("(write-file path 1)", t, env)
+
+	env.SetSandboxed(true)
+	malformedExprTest("(read-file path)", t, env)
+	malformedExprTest("(write-file path 'x')", t, env)
+	malformedExprTest("(file-exists? path)", t, env)
+	env.SetSandboxed(false)
+	checkExprResultTest("(read-file path)", "\"\"", t, env)
+}
+
+func TestCommandLineArgs(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(command-line-args)", "()", t, env)
+	env.SetArgs([]string{"first", "two words", "3"})
+	checkExprResultTest("(command-line-args)", "(\"first\" \"two words\" \"3\")", t, env)
+	checkExprResultTest("((lambda () (take 1 (command-line-args))))", "(\"first\")", t, env)
+}
+
+func TestEnvironmentVariables(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	os.Unsetenv("LAMBDA_TEST_VAR")
+	defer os.Unsetenv("LAMBDA_TEST_VAR")
+
+	checkExprResultTest("(getenv 'LAMBDA_TEST_VAR')", "nil", t, env)
+	checkExprResultTest("(setenv 'LAMBDA_TEST_VAR' 'a value')", "nil", t, env)
+	checkExprResultTest("(getenv 'LAMBDA_TEST_VAR')", "\"a value\"", t, env)
+	if os.Getenv("LAMBDA_TEST_VAR") != "a value" {
+		t.Errorf("Expected setenv to set the variable for the process")
+	}
+	checkExprResultTest("(setenv 'LAMBDA_TEST_VAR' '')", "nil", t, env)
+	checkExprResultTest("(getenv 'LAMBDA_TEST_VAR')", "\"\"", t, env)
+	malformedExprTest("(getenv 1)", t, env)
+	malformedExprTest("(setenv 'LAMBDA_TEST_VAR' 1)", t, env)
+
+	env.SetSandboxed(true)
+	malformedExprTest("(getenv 'LAMBDA_TEST_VAR')", t, env)
+	malformedExprTest("(setenv 'LAMBDA_TEST_VAR' 'x')", t, env)
+}
+
+func TestTime(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	before := time.Now().Unix()
+	val := Eval("(now)", env)
+	after := time.Now().Unix()
+	if ts, err := strconv.ParseInt(val.ValStr, 10, 64); err != nil || ts < before || ts > after {
+		t.Errorf("Expected (now) to be between %d and %d, but was %s", before, after, val.ValStr)
+	}
+
+	checkExprResultTest("(format-time 0 '2006-01-02 15:04:05')", "\"1970-01-01 00:00:00\"", t, env)
+	checkExprResultTest("(format-time 1234567890 'Jan 2, 2006 at 3:04pm (MST)')", "\"Feb 13, 2009 at 11:31pm (UTC)\"", t, env)
+	malformedExprTest("(format-time 1.5 '2006')", t, env)
+	malformedExprTest("(format-time 0 2006)", t, env)
+
+	start := time.Now()
+	checkExprResultTest("(sleep 0.05)", "nil", t, env)
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected (sleep 0.05) to sleep for at least 50ms, slept for %s", elapsed)
+	}
+	checkExprResultTest("(sleep 0)", "nil", t, env)
+	malformedExprTest("(sleep -1)", t, env)
+	malformedExprTest("(sleep nan)", t, env)
+	malformedExprTest("(sleep '1')", t, env)
+}
+
+func TestRandom(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	for i := 0; i < 20; i++ {
+		checkExprResultTest("(let ((x (random))) (and (>= x 0.0) (< x 1.0)))", "true", t, env)
+		checkExprResultTest("(let ((x (random 3))) (and (>= x 0) (< x 3)))", "true", t, env)
+	}
+	checkExprResultTest("(random 1)", "0", t, env)
+	malformedExprTest("(random 0)", t, env)
+	malformedExprTest("(random 1.5)", t, env)
+
+	// The same seed results in the same values, also in child environments.
+	Eval("(set-seed 42)", env)
+	first := Eval("(list (random 1000) ((lambda () (random))) (shuffle (range 10)))", env).ValStr
+	Eval("(set-seed 42)", env)
+	second := Eval("(list (random 1000) ((lambda () (random))) (shuffle (range 10)))", env).ValStr
+	if first != second {
+		t.Errorf("Expected the same values after seeding, got %s and %s", first, second)
+	}
+	malformedExprTest("(set-seed 'a')", t, env)
+
+	// Environments do not share their generators.
+	other := new(LangEnv)
+	other.Init()
+	Eval("(set-seed 42)", env)
+	Eval("(set-seed 42)", other)
+	Eval("(random 10)", env)
+	if a, b := Eval("(random 1000000)", env).ValStr, Eval("(random 1000000)", other).ValStr; a == b {
+		t.Errorf("Expected the generators of the environments to be independent, both returned %s", a)
+	}
+
+	checkExprResultTest("(equal? (frequencies (shuffle (range 5))) (frequencies (range 5)))", "true", t, env)
+	checkExprResultTest("(shuffle (list))", "()", t, env)
+	checkExprResultTest("(shuffle (list 1))", "(1)", t, env)
+	checkExprResultTest("(let ((l (list 1 2 3))) (shuffle l) l)", "(1 2 3)", t, env)
+	malformedExprTest("(shuffle 5)", t, env)
+}
+
+func TestUUID(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	uuidRegex := regexp.MustCompile(`^"[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}"$`)
+	seen := make(map[string]bool)
+	for i := 0; i < 10; i++ {
+		result := Eval("(uuid)", env)
+		if result.ErrStr != "" {
+			t.Fatalf("Expected (uuid) to succeed, got %s", result.ErrStr)
+		}
+		if !uuidRegex.MatchString(result.ValStr) {
+			t.Errorf("Expected %s to be a version 4 UUID", result.ValStr)
+		}
+		if seen[result.ValStr] {
+			t.Errorf("Expected the UUIDs to be unique, got %s twice", result.ValStr)
+		}
+		seen[result.ValStr] = true
+	}
+
+	// Seeding the generator does not affect the UUIDs.
+	Eval("(set-seed 1)", env)
+	first := Eval("(uuid)", env).ValStr
+	Eval("(set-seed 1)", env)
+	if second := Eval("(uuid)", env).ValStr; first == second {
+		t.Errorf("Expected the UUIDs to differ after seeding, got %s twice", first)
+	}
+	malformedExprTest("(uuid 1)", t, env)
+}
+
+func TestHash(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(hash 'md5' 'hello')", "\"5d41402abc4b2a76b9719d911017c592\"", t, env)
+	checkExprResultTest("(hash 'sha1' 'hello')", "\"aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d\"", t, env)
+	checkExprResultTest("(hash 'sha256' 'hello')",
+		"\"2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824\"", t, env)
+	checkExprResultTest("(hash \"md5\" \"\")", "\"d41d8cd98f00b204e9800998ecf8427e\"", t, env)
+	checkExprResultTest("(equal? (hash 'sha256' 'a') (hash 'sha256' 'b'))", "false", t, env)
+
+	malformedExprTest("(hash 'sha512' 'hello')", t, env)
+	malformedExprTest("(hash 'MD5' 'hello')", t, env)
+	malformedExprTest("(hash 'md5' 1)", t, env)
+	malformedExprTest("(hash 1 'hello')", t, env)
+	malformedExprTest("(hash 'md5')", t, env)
+}
+
+func TestBase64(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(base64-encode 'hello')", "\"aGVsbG8=\"", t, env)
+	checkExprResultTest("(base64-encode '')", "\"\"", t, env)
+	checkExprResultTest("(base64-decode 'aGVsbG8=')", "\"hello\"", t, env)
+	checkExprResultTest("(base64-decode (base64-encode 'round trip'))", "\"round trip\"", t, env)
+
+	// "??>" encodes to characters which differ between the alphabets.
+	checkExprResultTest("(base64-encode '??>')", "\"Pz8+\"", t, env)
+	checkExprResultTest("(base64-encode '??>' :std)", "\"Pz8+\"", t, env)
+	checkExprResultTest("(base64-encode '??>' :url)", "\"Pz8-\"", t, env)
+	checkExprResultTest("(base64-decode 'Pz8-' :url)", "\"??>\"", t, env)
+	checkExprResultTest("(base64-encode '???' :url)", "\"Pz8_\"", t, env)
+
+	malformedExprTest("(base64-decode 'Pz8-')", t, env)
+	malformedExprTest("(base64-decode 'not base64!')", t, env)
+	malformedExprTest("(base64-decode 'aGVsbG8')", t, env)
+	malformedExprTest("(base64-encode 'hello' :hex)", t, env)
+	malformedExprTest("(base64-encode 'hello' 'url')", t, env)
+	malformedExprTest("(base64-encode 1)", t, env)
+
+	// Invalid input raises an error which can be caught.
+	checkExprResultTest("(error? (try-> 'not base64!' base64-decode))", "true", t, env)
+}
+
+func TestDocStrings(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+
+	Eval("(defun square (x) \"Returns the square of x.\" (* x x))", env)
+	checkExprResultTest("(square 3)", "9", t, env)
+	checkExprResultTest("(doc square)", "nil", t, env)
+	if out.String() != "Returns the square of x.\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	// A string which is the only expression in the body is the body itself.
+	out.Reset()
+	Eval("(defun greeting () 'hello')", env)
+	checkExprResultTest("(greeting)", "'hello'", t, env)
+	checkExprResultTest("(doc greeting)", "nil", t, env)
+	if out.String() != "No documentation for greeting.\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	out.Reset()
+	Eval("(defvar cube (lambda (x) 'Returns the cube of x.' (* x x x)))", env)
+	checkExprResultTest("(cube 2)", "8", t, env)
+	checkExprResultTest("(doc cube)", "nil", t, env)
+	checkExprResultTest("(doc +)", "nil", t, env)
+	if out.String() != "Returns the cube of x.\nNo documentation for +.\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	// The doc string is not evaluated as part of the body.
+	out.Reset()
+	checkExprResultTest("((lambda () \"Doc.\" (print 'body') 1))", "1", t, env)
+	if out.String() != "body\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+
+	malformedExprTest("(defun twice (x) (print x) (* 2 x))", t, env)
+	malformedExprTest("(doc 1)", t, env)
+}
+
+func TestApropos(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(apropos \"base64\")", "(\"base64-decode\" \"base64-encode\")", t, env)
+	checkExprResultTest("(apropos \"BASE64-E\")", "(\"base64-encode\")", t, env)
+	checkExprResultTest("(apropos \"no-such-operator\")", "()", t, env)
+
+	Eval("(defun square-it (x) \"Returns the square of x.\" (* x x))", env)
+	Eval("(defun square-twice (x) (square-it (square-it x)))", env)
+	Eval("(defvar square-root-of-two 1.41)", env)
+	Eval("(defvar Square-fn (lambda (x) 'Squares x.' (* x x)))", env)
+	checkExprResultTest("(apropos 'square')",
+		"(\"Square-fn: Squares x.\" \"square-it: Returns the square of x.\" \"square-root-of-two\" \"square-twice\")", t, env)
+
+	malformedExprTest("(apropos 1)", t, env)
+	malformedExprTest("(apropos)", t, env)
+}
+
+func TestSource(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defun add-sq (x (y 2)) (+ (* x x) (* y y)))", env)
+	checkExprResultTest("(source add-sq)", "\"(defun add-sq (x (y 2)) (+ (* x x) (* y y)))\"", t, env)
+	Eval("(defun documented (x) 'Returns x.' x)", env)
+	checkExprResultTest("(source documented)", "\"(defun documented (x) 'Returns x.' x)\"", t, env)
+
+	Eval("(defvar adder (lambda (a b) (+ a b)))", env)
+	checkExprResultTest("(source adder)", "\"(lambda (a b) (+ a b))\"", t, env)
+	checkExprResultTest("(source #(* % 2))", "\"(lambda (%arg1) (* %arg1 2))\"", t, env)
+
+	// Methods passed as arguments keep their source.
+	Eval("(defun source-of (f) (source f))", env)
+	checkExprResultTest("(source-of add-sq)", "\"(defun add-sq (x (y 2)) (+ (* x x) (* y y)))\"", t, env)
+
+	checkExprResultTest("(source +)", "\"+ is a native operator\"", t, env)
+	checkExprResultTest("(source (partial + 1))", "\"partial is a native operator\"", t, env)
+	malformedExprTest("(source 1)", t, env)
+}
+
+func TestAlias(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(alias total +)", "nil", t, env)
+	checkExprResultTest("(total 1 2 3)", "6", t, env)
+	checkExprResultTest("(alias 'times' '*')", "nil", t, env)
+	checkExprResultTest("(times 2 3)", "6", t, env)
+	checkExprResultTest("(if (> 2 1) (total 1 1) 0)", "2", t, env)
+
+	Eval("(defun square (x) (* x x))", env)
+	checkExprResultTest("(alias sq square)", "nil", t, env)
+	checkExprResultTest("(sq 4)", "16", t, env)
+	Eval("(defun apply-to (f x) (f x))", env)
+	checkExprResultTest("(apply-to sq 3)", "9", t, env)
+	checkExprResultTest("(source sq)", "\"(defun square (x) (* x x))\"", t, env)
+
+	Eval("(defvar adder (lambda (a b) (+ a b)))", env)
+	checkExprResultTest("(alias add-two adder)", "nil", t, env)
+	checkExprResultTest("(add-two 1 2)", "3", t, env)
+
+	// Redefining the alias does not affect the original.
+	checkExprResultTest("(alias total -)", "nil", t, env)
+	checkExprResultTest("(total 5 3)", "2", t, env)
+	checkExprResultTest("(+ 5 3)", "8", t, env)
+
+	malformedExprTest("(alias + -)", t, env)
+	malformedExprTest("(alias square +)", t, env)
+	malformedExprTest("(alias adder +)", t, env)
+	malformedExprTest("(alias plus undefined-op)", t, env)
+	malformedExprTest("(alias plus 1)", t, env)
+	malformedExprTest("(alias 1 +)", t, env)
+}
+
+func TestExpt(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(expt 2 10)", "1024", t, env)
+	checkExprResultTest("(expt 2 0)", "1", t, env)
+	checkExprResultTest("(expt -3 3)", "-27", t, env)
+	checkExprResultTest("(expt 2 100)", "1267650600228229401496703205376", t, env)
+	checkExprResultTest("(expt 2 -1)", "0.5", t, env)
+	checkExprResultTest("(expt 2.5 2)", "6.25", t, env)
+	checkExprResultTest("(expt 4 0.5)", "2", t, env)
+	checkExprResultTest("(expt 2.0 -18446744073709551615)", "0", t, env)
+	checkExprResultTest("(expt 100000000000000000000 0.5)", "1e+10", t, env)
+	checkExprResultTest("(expt 1 100000000000)", "1", t, env)
+	checkExprResultTest("(expt -1 100000000001)", "-1", t, env)
+	malformedExprTest("(expt 2 -18446744073709551615)", t, env)
+	malformedExprTest("(expt 2 18446744073709551615)", t, env)
+	malformedExprTest("(expt 2 100000000000)", t, env)
+	malformedExprTest("(expt 100000000000000000000 10000000)", t, env)
+	malformedExprTest("(expt 2 'a')", t, env)
+	malformedExprTest("(expt 2)", t, env)
+}
+
+func TestInfix(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(infix 1 + 2 * 3)", "7", t, env)
+	checkExprResultTest("(infix (1 + 2) * 3)", "9", t, env)
+	checkExprResultTest("(infix 10 - 4 - 3)", "3", t, env)
+	checkExprResultTest("(infix 24 / 4 / 2)", "3", t, env)
+	checkExprResultTest("(infix 2 ^ 3 ^ 2)", "512", t, env)
+	checkExprResultTest("(infix 2 * 3 ^ 2)", "18", t, env)
+	checkExprResultTest("(infix - 2 ^ 2)", "-4", t, env)
+	checkExprResultTest("(infix 2 ^ - 1)", "0.5", t, env)
+	checkExprResultTest("(infix 5 - (- 3))", "8", t, env)
+	checkExprResultTest("(infix 42)", "42", t, env)
+	checkExprResultTest("(infix ((1 + 2) * (3 + 4)))", "21", t, env)
+
+	// Method calls within the expression are evaluated as they are.
+	Eval("(defvar x 4)", env)
+	Eval("(defun square (n) (* n n))", env)
+	checkExprResultTest("(infix x * (square 3) + 1)", "37", t, env)
+
+	checkExprResultTest("(macroexpand (infix 1 + 2 * 3))", "\"(+ 1 (* 2 3))\"", t, env)
+	checkExprResultTest("(macroexpand (infix (1 - 2) - 3))", "\"(- (- 1 2) 3)\"", t, env)
+
+	malformedExprTest("(infix 1 +)", t, env)
+	malformedExprTest("(infix 1 2)", t, env)
+	malformedExprTest("(infix * 2)", t, env)
+	malformedExprTest("(infix 1 + + 2)", t, env)
+	malformedExprTest("(infix 1 + ())", t, env)
+	malformedExprTest("(infix)", t, env)
+}
+
+func TestStringQuotes(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("\"it's\"", "\"it's\"", t, env)
+	checkExprResultTest("'say \"hi\"'", "'say \"hi\"'", t, env)
+	checkExprResultTest("\"a\\\"b\"", "\"a\\\"b\"", t, env)
+	malformedExprTest("\"a\"b\"", t, env)
+	malformedExprTest("'''", t, env)
+	malformedExprTest("(+ \"a\"b\" \"c\")", t, env)
+
+	// Escaped quotes and backslashes are unescaped, and escaped again when the
+	// string is printed as a value.
+	checkExprResultTest("(string->list \"a\\\"b\")", "(#\\a #\\\" #\\b)", t, env)
+	checkExprResultTest("(string->list \"a\\\\b\")", "(#\\a #\\\\ #\\b)", t, env)
+	checkExprResultTest("(list->string (string->list \"a\\\"b\"))", "\"a\\\"b\"", t, env)
+	checkExprResultTest("(equal? \"a\\\"b\" 'a\"b')", "true", t, env)
+	checkExprResultTest("\"a\\\\\"", "\"a\\\\\"", t, env)
+	checkExprResultTest("(read-data \"\\\"a\\\\\\\"b\\\"\")", "\"a\\\"b\"", t, env)
+	var out bytes.Buffer
+	env.SetOutput(&out)
+	checkExprResultTest("(print \"say \\\"hi\\\"\" 'it\\'s' \"a\\\\b\")", "nil", t, env)
+	if out.String() != "say \"hi\" it's a\\b\n" {
+		t.Errorf("Unexpected output: %q", out.String())
+	}
+}
+
+func TestSpecialForms(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	malformedExprTest("(defvar if 5)", t, env)
+	malformedExprTest("(defvar lambda 5)", t, env)
+	malformedExprTest("(defun if (x) x)", t, env)
+	malformedExprTest("(defun defun (x) x)", t, env)
+	malformedExprTest("(defun f (let) let)", t, env)
+	malformedExprTest("(lambda (cond) cond)", t, env)
+	malformedExprTest("(let ((when 1)) when)", t, env)
+	malformedExprTest("(let (((a begin) (list 1 2))) a)", t, env)
+	malformedExprTest("(alias if when)", t, env)
+	checkExprResultTest("(if true 1 2)", "1", t, env)
+
+	// Ordinary operators and methods can be redefined.
+	Eval("(defun area (r) (* r r))", env)
+	Eval("(defun area (w h) (* w h))", env)
+	checkExprResultTest("(area 2 3)", "6", t, env)
+	Eval("(defun inc (x) (+ x 10))", env)
+	checkExprResultTest("(inc 1)", "11", t, env)
+
+	// Operators still cannot be used as variables.
+	malformedExprTest("(defvar area 5)", t, env)
+	Eval("(defvar radius 5)", env)
+	malformedExprTest("(defun radius (x) x)", t, env)
+}
+
+func TestWithRedefs(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defun roll () (+ 1 (random 6)))", env)
+	checkExprResultTest("(with-redefs ((random (lambda (n) 2))) (roll))", "3", t, env)
+	checkExprResultTest("(let ((r (roll))) (and (>= r 1) (<= r 6)))", "true", t, env)
+	checkExprResultTest("(with-redefs ((random (lambda () 0.5))) (random))", "0.5", t, env)
+
+	// Methods, operators and variables can be redefined, and are restored.
+	Eval("(defvar limit 10)", env)
+	Eval("(defun over-limit? (x) (> x limit))", env)
+	checkExprResultTest("(with-redefs ((limit 100) (+ -)) (list (over-limit? 50) (+ 5 3) limit))",
+		"(false 2 100)", t, env)
+	checkExprResultTest("(over-limit? 50)", "true", t, env)
+	checkExprResultTest("(+ 5 3)", "8", t, env)
+	checkExprResultTest("limit", "10", t, env)
+
+	// Variables can be redefined as methods, and methods as values.
+	checkExprResultTest("(with-redefs ((limit (lambda () 1))) (limit))", "1", t, env)
+	checkExprResultTest("(with-redefs ((roll 4)) roll)", "4", t, env)
+	checkExprResultTest("limit", "10", t, env)
+
+	// Names which were not defined before are removed again.
+	checkExprResultTest("(with-redefs ((fresh 1)) (+ fresh 1))", "2", t, env)
+	malformedExprTest("fresh", t, env)
+
+	// The definitions are restored even if the body results in an error.
+	malformedExprTest("(with-redefs ((limit 0) (roll (lambda () 1))) (error 'failed'))", t, env)
+	checkExprResultTest("limit", "10", t, env)
+	checkExprResultTest("(let ((r (roll))) (and (>= r 1) (<= r 6)))", "true", t, env)
+
+	malformedExprTest("(with-redefs ((if 1)) 1)", t, env)
+	malformedExprTest("(with-redefs ((1 2)) 1)", t, env)
+	malformedExprTest("(with-redefs (limit 1) limit)", t, env)
+	malformedExprTest("(with-redefs limit 1)", t, env)
+	malformedExprTest("(with-redefs ((limit (undefined))) limit)", t, env)
+}
+
+func TestParameterize(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defvar precision (make-parameter 2))", env)
+	Eval("(defun show (x) (round-to x (precision)))", env)
+	checkExprResultTest("(precision)", "2", t, env)
+	checkExprResultTest("(show 3.14159)", "3.14", t, env)
+
+	// The new value is visible to the methods called within the body.
+	checkExprResultTest("(parameterize ((precision 4)) (show 3.14159))", "3.1416", t, env)
+	checkExprResultTest("(parameterize ((precision 4)) (parameterize ((precision 1)) (show 3.14159)))", "3.1", t, env)
+	checkExprResultTest("(parameterize ((precision 4)) (list (parameterize ((precision 1)) (precision)) (precision)))",
+		"(1 4)", t, env)
+	checkExprResultTest("(precision)", "2", t, env)
+
+	// Unlike let, the value is not captured lexically.
+	Eval("(defvar shower (let ((p 0)) (lambda () (precision))))", env)
+	checkExprResultTest("(parameterize ((precision 3)) (shower))", "3", t, env)
+
+	// Parameters are independent, and all the values are evaluated first.
+	Eval("(defvar verbose (make-parameter false))", env)
+	checkExprResultTest("(parameterize ((precision 5) (verbose (precision))) (list (precision) (verbose)))",
+		"(5 2)", t, env)
+
+	// The previous value is restored even if the body results in an error.
+	malformedExprTest("(parameterize ((precision 8)) (error 'failed'))", t, env)
+	checkExprResultTest("(precision)", "2", t, env)
+
+	malformedExprTest("(parameterize ((show 1)) 1)", t, env)
+	malformedExprTest("(parameterize ((5 1)) 1)", t, env)
+	malformedExprTest("(parameterize ((precision)) 1)", t, env)
+	malformedExprTest("(parameterize precision 1)", t, env)
+	malformedExprTest("(precision 1)", t, env)
+}
+
+func TestCopy(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(copy 1)", "1", t, env)
+	checkExprResultTest("(copy 'a')", "'a'", t, env)
+	checkExprResultTest("(copy (list))", "()", t, env)
+	checkExprResultTest("(copy (list 1 (list 2 (list 3))))", "(1 (2 (3)))", t, env)
+	checkExprResultTest("(copy (group-by even? (range 4)))", "{true: (0 2), false: (1 3)}", t, env)
+	checkExprResultTest("(copy (make-set 1 (list 2) 3))", "#{1 (2) 3}", t, env)
+
+	// The copy is equal to the original, but not identical to it, down to the
+	// nested lists.
+	Eval("(defvar inner (list 1 2))", env)
+	Eval("(defvar outer (list inner))", env)
+	checkExprResultTest("(equal? (copy outer) outer)", "true", t, env)
+	checkExprResultTest("(assq outer (list (list outer 'found')))", "(((1 2)) 'found')", t, env)
+	checkExprResultTest("(assq (copy outer) (list (list outer 'found')))", "nil", t, env)
+	checkExprResultTest("(assq (let (((x) (copy outer))) x) (list (list inner 'found')))", "nil", t, env)
+	malformedExprTest("(copy)", t, env)
+}
+
+func TestVectors(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(list->vector (list 1 'a' (list 2)))", "[1 'a' (2)]", t, env)
+	checkExprResultTest("(list->vector (list))", "[]", t, env)
+	checkExprResultTest("(vector->list (list->vector (range 4)))", "(0 1 2 3)", t, env)
+	checkExprResultTest("(vector->list (list->vector (list)))", "()", t, env)
+
+	Eval("(defvar v (list->vector (list 'a' 'b' 'c')))", env)
+	checkExprResultTest("(vector-ref v 0)", "'a'", t, env)
+	checkExprResultTest("(vector-ref v 2)", "'c'", t, env)
+	malformedExprTest("(vector-ref v 3)", t, env)
+	malformedExprTest("(vector-ref v -1)", t, env)
+	malformedExprTest("(vector-ref v 1.0)", t, env)
+	malformedExprTest("(vector-ref (list 1) 0)", t, env)
+
+	checkExprResultTest("(equal? v (list->vector (list \"a\" \"b\" \"c\")))", "true", t, env)
+	checkExprResultTest("(equal? v (list 'a' 'b' 'c'))", "false", t, env)
+	checkExprResultTest("(distinct (list v (copy v) (vector->list v)))", "(['a' 'b' 'c'] ('a' 'b' 'c'))", t, env)
+
+	malformedExprTest("(list->vector v)", t, env)
+	malformedExprTest("(vector->list (list 1))", t, env)
+	malformedExprTest("(list->vector 1)", t, env)
+}
+
+func TestCons(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(cons 1 (list))", "(1)", t, env)
+	checkExprResultTest("(cons 1 (cons 2 (list 3 4)))", "(1 2 3 4)", t, env)
+	checkExprResultTest("(car (cons 1 (list 2)))", "1", t, env)
+	checkExprResultTest("(cdr (cons 1 (list 2)))", "(2)", t, env)
+	checkExprResultTest("(car (list 1 2))", "1", t, env)
+	checkExprResultTest("(cdr (list 1 2 3))", "(2 3)", t, env)
+	checkExprResultTest("(cdr (list 1))", "()", t, env)
+	checkExprResultTest("(cons (list 1) (list))", "((1))", t, env)
+	malformedExprTest("(car (list))", t, env)
+	malformedExprTest("(cdr (list))", t, env)
+	malformedExprTest("(car 1)", t, env)
+	malformedExprTest("(cons 1 2)", t, env)
+	malformedExprTest("(cons 1 nil)", t, env)
+
+	// The tail is shared with the result, rather than copied.
+	Eval("(defvar tail (list 2 3))", env)
+	Eval("(defvar c (cons 1 tail))", env)
+	Eval("(defvar d (cons 0 c))", env)
+	checkExprResultTest("(assq (cdr c) (list (list tail 'shared')))", "((2 3) 'shared')", t, env)
+	checkExprResultTest("(assq (cdr d) (list (list c 'shared')))", "((1 2 3) 'shared')", t, env)
+	checkExprResultTest("(assq (cons 1 tail) (list (list c 'shared')))", "nil", t, env)
+
+	// Cons cells can be used like any other list.
+	checkExprResultTest("(equal? d (list 0 1 2 3))", "true", t, env)
+	checkExprResultTest("(take 2 d)", "(0 1)", t, env)
+	checkExprResultTest("(flatten (list d (cons (cons 5 (list)) (list))))", "(0 1 2 3 5)", t, env)
+	checkExprResultTest("(let (((a b . rest) d)) (list a b rest))", "(0 1 (2 3))", t, env)
+	checkExprResultTest("(for/list ((x d)) (* x x))", "(0 1 4 9)", t, env)
+	checkExprResultTest("(get (frequencies (list d (list 0 1 2 3))) d)", "2", t, env)
+
+	// Building and consuming a long list is linear in its length.
+	Eval("(defun build (n acc) (if (= n 0) acc (recur (dec n) (cons n acc))))", env)
+	Eval("(defun total (l acc) (if (equal? l (list)) acc (recur (cdr l) (+ acc (car l)))))", env)
+	start := time.Now()
+	checkExprResultTest("(total (build 20000 (list)) 0)", "200010000", t, env)
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Expected building a list with cons to be fast, took %s", elapsed)
+	}
+}
+
+func TestBigIntArithmetic(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(* 3037000500 3037000500)", "9223372037000250000", t, env)
+	checkExprResultTest("(* 4611686018427387904 2)", "9223372036854775808", t, env)
+	checkExprResultTest("(* -4611686018427387904 2)", "-9223372036854775808", t, env)
+	checkExprResultTest("(* -1 -9223372036854775808)", "9223372036854775808", t, env)
+	checkExprResultTest("(* -9223372036854775808 -1)", "9223372036854775808", t, env)
+	checkExprResultTest("(* 0 -9223372036854775808)", "0", t, env)
+	checkExprResultTest("(* 2 3 -4)", "-24", t, env)
+
+	// The operands are shared, so they are not modified by the operations.
+	Eval("(defvar a 100000000000000000000)", env)
+	checkExprResultTest("(* a a)", "10000000000000000000000000000000000000000", t, env)
+	checkExprResultTest("(* a 2 3)", "600000000000000000000", t, env)
+	checkExprResultTest("(+ a a a)", "300000000000000000000", t, env)
+	checkExprResultTest("(+ a 1)", "100000000000000000001", t, env)
+	checkExprResultTest("(- a 1)", "99999999999999999999", t, env)
+	checkExprResultTest("(/ a 3)", "33333333333333333333", t, env)
+	checkExprResultTest("a", "100000000000000000000", t, env)
+	malformedExprTest("(/ a 0)", t, env)
+}
+
+// Computes 300! by repeatedly calling the * operator, like a method computing a
+// factorial would.
+func BenchmarkBigIntFactorial(b *testing.B) {
+	env := new(LangEnv)
+	env.Init()
+	mul := env.getOperator("*")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var acc Value = intValue{1}
+		for n := int64(2); n <= 300; n++ {
+			result := mul.handler(env, []Atom{{Val: acc}, {Val: intValue{n}}})
+			acc = result.Val
+		}
+	}
+}
+
+func TestCombinatorics(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(factorial 0)", "1", t, env)
+	checkExprResultTest("(factorial 1)", "1", t, env)
+	checkExprResultTest("(factorial 20)", "2432902008176640000", t, env)
+	checkExprResultTest("(factorial 100)", "93326215443944152681699238856266700490715968264381621468592963895217599993229915608941463976156518286253697920827223758251185210916864000000000000000000000000", t, env)
+	checkExprResultTest("(= (factorial 21) (* 21 (factorial 20)))", "true", t, env)
+
+	checkExprResultTest("(choose 5 2)", "10", t, env)
+	checkExprResultTest("(choose 5 0)", "1", t, env)
+	checkExprResultTest("(choose 5 5)", "1", t, env)
+	checkExprResultTest("(choose 2 5)", "0", t, env)
+	checkExprResultTest("(choose 100 50)", "100891344545564193334812497256", t, env)
+
+	checkExprResultTest("(permutations 5 2)", "20", t, env)
+	checkExprResultTest("(permutations 5 0)", "1", t, env)
+	checkExprResultTest("(permutations 5 5)", "120", t, env)
+	checkExprResultTest("(permutations 2 5)", "0", t, env)
+	checkExprResultTest("(permutations 30 20)", "73096577329197271449600000", t, env)
+
+	malformedExprTest("(factorial -1)", t, env)
+	malformedExprTest("(factorial 2.0)", t, env)
+	malformedExprTest("(choose 5 -1)", t, env)
+	malformedExprTest("(choose -5 1)", t, env)
+	malformedExprTest("(permutations 'a' 1)", t, env)
+}
+
+func TestPrimes(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(for/list ((n (range 20)) :when (prime? n)) n)", "(2 3 5 7 11 13 17 19)", t, env)
+	checkExprResultTest("(prime? -7)", "false", t, env)
+	checkExprResultTest("(prime? 618970019642690137449562111)", "true", t, env)
+	checkExprResultTest("(prime? 618970019642690137449562113)", "false", t, env)
+	malformedExprTest("(prime? 7.0)", t, env)
+
+	checkExprResultTest("(next-prime -5)", "2", t, env)
+	checkExprResultTest("(next-prime 2)", "3", t, env)
+	checkExprResultTest("(next-prime 13)", "17", t, env)
+	checkExprResultTest("(next-prime 1000000)", "1000003", t, env)
+	checkExprResultTest("(next-prime 9223372036854775807)", "9223372036854775837", t, env)
+	malformedExprTest("(next-prime 'a')", t, env)
+
+	checkExprResultTest("(factorize 360)", "(2 2 2 3 3 5)", t, env)
+	checkExprResultTest("(factorize 1)", "()", t, env)
+	checkExprResultTest("(factorize 97)", "(97)", t, env)
+	checkExprResultTest("(factorize (* 1000003 1000033))", "(1000003 1000033)", t, env)
+	checkExprResultTest("(factorize (* 12 2305843009213693951 2147483647))", "(2 2 3 2147483647 2305843009213693951)", t, env)
+	checkExprResultTest("(factorize 9223372036854775837)", "(9223372036854775837)", t, env)
+	malformedExprTest("(factorize 0)", t, env)
+	malformedExprTest("(factorize -12)", t, env)
+	malformedExprTest("(factorize 1.5)", t, env)
+}
+
+func TestWrap32(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(wrap-add32 1 2)", "3", t, env)
+	checkExprResultTest("(wrap-add32 4294967295 1)", "0", t, env)
+	checkExprResultTest("(wrap-add32 4294967295 4294967295 2)", "0", t, env)
+	checkExprResultTest("(wrap-add32 -1 0)", "4294967295", t, env)
+	checkExprResultTest("(wrap-mul32 65536 65536)", "0", t, env)
+	checkExprResultTest("(wrap-mul32 16777619 2166136261)", "84696351", t, env)
+	checkExprResultTest("(wrap-mul32 3 5 7)", "105", t, env)
+	checkExprResultTest("(wrap-and32 4294967296 4294967295)", "0", t, env)
+	checkExprResultTest("(wrap-and32 12 10)", "8", t, env)
+	checkExprResultTest("(wrap-and32 -1 -1)", "4294967295", t, env)
+	malformedExprTest("(wrap-add32 1 1.0)", t, env)
+	malformedExprTest("(wrap-add32 1 100000000000000000000)", t, env)
+	malformedExprTest("(wrap-mul32 1)", t, env)
+
+	checkExprResultTest("(uint32? 0)", "true", t, env)
+	checkExprResultTest("(uint32? 4294967295)", "true", t, env)
+	checkExprResultTest("(uint32? 4294967296)", "false", t, env)
+	checkExprResultTest("(uint32? -1)", "false", t, env)
+	checkExprResultTest("(uint32? 'a')", "false", t, env)
+	checkExprResultTest("(uint32? (wrap-mul32 123456789 987654321))", "true", t, env)
+}
+
+func TestASTMetrics(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(ast-size 1)", "1", t, env)
+	checkExprResultTest("(ast-size (+ 1 2))", "4", t, env)
+	checkExprResultTest("(ast-size (+ 1 (* 2 3)))", "7", t, env)
+	checkExprResultTest("(ast-size ())", "1", t, env)
+	checkExprResultTest("(ast-depth 1)", "0", t, env)
+	checkExprResultTest("(ast-depth ())", "1", t, env)
+	checkExprResultTest("(ast-depth (+ 1 2))", "1", t, env)
+	checkExprResultTest("(ast-depth (+ 1 (* 2 (- 3 4)) 5))", "3", t, env)
+
+	// The expression is not evaluated.
+	checkExprResultTest("(ast-size (undefined-method (error 'never')))", "5", t, env)
+	checkExprResultTest("(ast-depth (defvar x 1))", "1", t, env)
+	malformedExprTest("x", t, env)
+	malformedExprTest("(ast-size 1 2)", t, env)
+}
+
+func TestASTWalk(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(ast-walk (+ 1 (* 2 3)) identity)", "(+ 1 (* 2 3))", t, env)
+	checkExprResultTest("(ast-walk (+ x 1) (lambda (n) (if (equal? n (symbol \"x\")) 2 n)))", "(+ 2 1)", t, env)
+	checkExprResultTest("(ast-walk (f 'a' true) identity)", "(f 'a' true)", t, env)
+
+	// Nodes are replaced bottom-up, so a replacement can complete a pattern.
+	Eval("(defun fold (n) (if (equal? n (list (symbol \"+\") 1 1)) 1 n))", env)
+	checkExprResultTest("(ast-walk (* y (+ 1 (+ 1 1))) fold)", "(* y 1)", t, env)
+
+	// The expression is not evaluated.
+	checkExprResultTest("(ast-walk (error 'never') identity)", "(error 'never')", t, env)
+	checkExprResultTest("(symbol? (ast-walk undefined identity))", "true", t, env)
+	checkExprResultTest("(symbol? 'x')", "false", t, env)
+	checkExprResultTest("(equal? (symbol \"+\") (symbol '+'))", "true", t, env)
+	malformedExprTest("(ast-walk (+ 1 2) 3)", t, env)
+	malformedExprTest("(ast-walk (+ 1 2) (lambda (n) (error 'bad')))", t, env)
+	malformedExprTest("(symbol 1)", t, env)
+}
+
+func TestPad(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(pad-left \"ab\" 5)", "\"   ab\"", t, env)
+	checkExprResultTest("(pad-right \"ab\" 5)", "\"ab   \"", t, env)
+	checkExprResultTest("(pad-left \"7\" 3 #\\0)", "\"007\"", t, env)
+	checkExprResultTest("(pad-right 'it\"s' 6 #\\.)", "'it\"s..'", t, env)
+	checkExprResultTest("(pad-left \"ab\" 0)", "\"ab\"", t, env)
+
+	// Widths are measured in characters, not bytes.
+	checkExprResultTest("(pad-right \"größe\" 7 #\\*)", "\"größe**\"", t, env)
+	checkExprResultTest("(pad-left \"ab\" 4 #\\世)", "\"世世ab\"", t, env)
+
+	// Wider strings are only cut when asked to.
+	checkExprResultTest("(pad-right \"abcdef\" 3)", "\"abcdef\"", t, env)
+	checkExprResultTest("(pad-right \"abcdef\" 3 :truncate)", "\"abc\"", t, env)
+	checkExprResultTest("(pad-left \"größe\" 3 #\\space :truncate)", "\"grö\"", t, env)
+	checkExprResultTest("(pad-left \"ab\" 4 :truncate #\\-)", "\"--ab\"", t, env)
+
+	malformedExprTest("(pad-left 12 4)", t, env)
+	malformedExprTest("(pad-left \"ab\" -1)", t, env)
+	malformedExprTest("(pad-left \"ab\" 4 \"-\")", t, env)
+	malformedExprTest("(pad-left \"ab\" 4 :cut)", t, env)
+}
+
+func TestRepeat(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(repeat \"ab\" 3)", "\"ababab\"", t, env)
+	checkExprResultTest("(repeat '=' 1)", "'='", t, env)
+	checkExprResultTest("(repeat \"ab\" 0)", "\"\"", t, env)
+	checkExprResultTest("(repeat \"\" 5)", "\"\"", t, env)
+	checkExprResultTest("(repeat \"世\" 2)", "\"世世\"", t, env)
+	malformedExprTest("(repeat \"ab\" -1)", t, env)
+	malformedExprTest("(repeat \"ab\" 1.5)", t, env)
+	malformedExprTest("(repeat 1 2)", t, env)
+	malformedExprTest("(repeat \"ab\" 100000000000)", t, env)
+	malformedExprTest("(repeat \"ab\" 9223372036854775807)", t, env)
+	checkExprResultTest("(repeat \"\" 100000000000)", "\"\"", t, env)
+}
+
+func TestAffixPredicates(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(starts-with? \"hello world\" \"hello\")", "true", t, env)
+	checkExprResultTest("(starts-with? \"hello\" \"world\")", "false", t, env)
+	checkExprResultTest("(starts-with? \"hi\" \"hello\")", "false", t, env)
+	checkExprResultTest("(starts-with? \"hello\" \"\")", "true", t, env)
+	checkExprResultTest("(ends-with? \"report.txt\" '.txt')", "true", t, env)
+	checkExprResultTest("(ends-with? \"report.txt\" \".csv\")", "false", t, env)
+	checkExprResultTest("(starts-with? \"größe\" \"grö\")", "true", t, env)
+	checkExprResultTest("(ends-with? \"世界\" \"界\")", "true", t, env)
+	malformedExprTest("(starts-with? \"hello\" #\\h)", t, env)
+	malformedExprTest("(ends-with? 12 \"2\")", t, env)
+}
+
+func TestIndexOf(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(index-of \"banana\" \"an\")", "1", t, env)
+	checkExprResultTest("(index-of \"banana\" \"an\" 2)", "3", t, env)
+	checkExprResultTest("(index-of \"banana\" \"an\" 4)", "-1", t, env)
+	checkExprResultTest("(index-of \"banana\" \"x\")", "-1", t, env)
+	checkExprResultTest("(index-of \"banana\" \"\")", "0", t, env)
+	checkExprResultTest("(index-of \"banana\" \"a\" 100)", "-1", t, env)
+
+	// Indices count characters, not bytes.
+	checkExprResultTest("(index-of \"größe\" \"e\")", "4", t, env)
+	checkExprResultTest("(index-of \"世界世界\" \"界\" 2)", "3", t, env)
+
+	checkExprResultTest("(index-of (list 1 2 3 2) 2)", "1", t, env)
+	checkExprResultTest("(index-of (list 1 2 3 2) 2 2)", "3", t, env)
+	checkExprResultTest("(index-of (list (list 1) 'a') (list 1))", "0", t, env)
+	checkExprResultTest("(index-of (list 1 2) 3)", "-1", t, env)
+	checkExprResultTest("(index-of (cons 1 (cons 2 (list))) 2)", "1", t, env)
+
+	malformedExprTest("(index-of \"banana\" #\\a)", t, env)
+	malformedExprTest("(index-of 12 1)", t, env)
+	malformedExprTest("(index-of \"banana\" \"a\" -1)", t, env)
+}
+
+func TestConditionalLet(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	Eval("(defvar colors (list (list :red 1) (list :green 2)))", env)
+
+	checkExprResultTest("(if-let (pair (assoc :red colors)) (car (cdr pair)) 0)", "1", t, env)
+	checkExprResultTest("(if-let (pair (assoc :blue colors)) (car (cdr pair)) 0)", "0", t, env)
+	checkExprResultTest("(if-let (x false) x)", "nil", t, env)
+	checkExprResultTest("(if-let (x 0) x 1)", "0", t, env)
+	checkExprResultTest("(if-let ((k v) (assoc :green colors)) v)", "2", t, env)
+
+	checkExprResultTest("(when-let (pair (assoc :green colors)) (car (cdr pair)) (car pair))", ":green", t, env)
+	checkExprResultTest("(when-let (pair (assoc :blue colors)) (error 'never'))", "nil", t, env)
+
+	// The binding is only in scope of the body.
+	malformedExprTest("(if-let (pair (assoc :blue colors)) 1 pair)", t, env)
+	malformedExprTest("(begin (if-let (pair (assoc :red colors)) pair) pair)", t, env)
+
+	malformedExprTest("(if-let pair 1)", t, env)
+	malformedExprTest("(if-let (pair) 1)", t, env)
+	malformedExprTest("(if-let (x 1) 1 2 3)", t, env)
+	malformedExprTest("(defvar if-let 1)", t, env)
+}
+
+func TestEval(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(eval \"(+ 1 2)\")", "3", t, env)
+	checkExprResultTest("(eval 4)", "4", t, env)
+	checkExprResultTest("(eval (ast-walk (+ x 1) (lambda (n) (if (equal? n (symbol \"x\")) 2 n))))", "3", t, env)
+
+	// Later forms see what the earlier ones defined.
+	checkExprResultTest("(eval-all (list \"(defvar x 2)\" \"(defun twice (n) (* 2 n))\" \"(twice x)\"))",
+		"(2 <Method: twice> 4)", t, env)
+	checkExprResultTest("x", "2", t, env)
+	checkExprResultTest("(eval-all (list))", "()", t, env)
+	checkExprResultTest("(eval-all (list \"'a'\" 1.5))", "('a' 1.5)", t, env)
+
+	malformedExprTest("(eval-all (list \"(defvar y 1)\" \"(error 'bad')\"))", t, env)
+	checkExprResultTest("y", "1", t, env)
+	malformedExprTest("(eval \"(+ 1 2\")", t, env)
+	malformedExprTest("(eval \"1 2\")", t, env)
+	malformedExprTest("(eval-all \"(+ 1 2)\")", t, env)
+}
+
+func TestSpawn(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(await (spawn (+ 1 2)))", "3", t, env)
+	checkExprResultTest("(await 3)", "3", t, env)
+	Eval("(defun sum-to (n) (loop ((i 0) (acc 0)) (if (> i n) acc (recur (inc i) (+ acc i)))))", env)
+	Eval("(defvar tasks (list (spawn (sum-to 1000)) (spawn (sum-to 2000)) (spawn (sum-to 3000))))", env)
+	checkExprResultTest("(for/list ((task tasks)) (await task))", "(500500 2001000 4501500)", t, env)
+
+	// The result is printed once the task is done.
+	Eval("(defvar f (spawn 5))", env)
+	checkExprResultTest("(await f)", "5", t, env)
+	checkExprResultTest("f", "#<future: 5>", t, env)
+
+	// Errors are raised when awaiting the task.
+	Eval("(defvar failing (spawn (error 'bad')))", env)
+	malformedExprTest("(await failing)", t, env)
+
+	// Definitions made by the task are not visible outside of it.
+	checkExprResultTest("(await (spawn (defvar task-local 1)))", "1", t, env)
+	malformedExprTest("task-local", t, env)
+
+	// Methods can be redefined while tasks call them.
+	Eval("(defun value-of () 1)", env)
+	Eval("(defvar running (spawn (loop ((i 0)) (if (= i 2000) 'done' (begin (value-of) (recur (inc i)))))))", env)
+	for i := 0; i < 100; i++ {
+		Eval(fmt.Sprintf("(defun value-of () %d)", i), env)
+		Eval(fmt.Sprintf("(defvar counter%d %d)", i, i), env)
+	}
+	checkExprResultTest("(await running)", "'done'", t, env)
+
+	// Lambdas and streams defined outside of the tasks use the random generator
+	// and the tracer of the task calling them.
+	env.SetOutput(ioutil.Discard)
+	Eval("(defvar roll (lambda () (random 10)))", env)
+	Eval("(defvar rolls (stream-iterate (lambda (x) (+ x (random 1))) 0))", env)
+	Eval("(defvar rollers (for/list ((i (range 0 4))) (spawn (begin (trace (roll)) (stream-take 50 rolls) (roll)))))", env)
+	checkExprResultTest("(for/list ((r rollers)) (< (await r) 10))", "(true true true true)", t, env)
+	checkExprResultTest("(stream-take 3 rolls)", "(0 0 0)", t, env)
+
+	malformedExprTest("(defvar spawn 1)", t, env)
+}
+
+func TestChannels(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defvar c (make-channel))", env)
+	checkExprResultTest("c", "#<channel>", t, env)
+	Eval("(defvar producer (spawn (loop ((i 1)) (if (> i 5) (close-channel! c) (begin (send! c (* i i)) (recur (inc i)))))))", env)
+	checkExprResultTest("(receive! c)", "1", t, env)
+	checkExprResultTest("(channel->list c)", "(4 9 16 25)", t, env)
+	checkExprResultTest("(receive! c)", "nil", t, env)
+	checkExprResultTest("(await producer)", "nil", t, env)
+	malformedExprTest("(send! c 1)", t, env)
+	malformedExprTest("(close-channel! c)", t, env)
+
+	// Buffered channels do not wait for the values to be received.
+	Eval("(defvar buffered (make-channel 2))", env)
+	checkExprResultTest("(send! buffered 'a')", "nil", t, env)
+	checkExprResultTest("(send! buffered (list 1 2))", "nil", t, env)
+	checkExprResultTest("(close-channel! buffered)", "nil", t, env)
+	checkExprResultTest("(channel->list buffered)", "('a' (1 2))", t, env)
+
+	// Consumers can be tasks as well.
+	Eval("(defvar jobs (make-channel 10))", env)
+	Eval("(defvar consumer (spawn (for/list ((job (channel->list jobs))) (* 2 job))))", env)
+	Eval("(for/list ((i (range 0 4))) (send! jobs i))", env)
+	Eval("(close-channel! jobs)", env)
+	checkExprResultTest("(await consumer)", "(0 2 4 6)", t, env)
+
+	malformedExprTest("(make-channel -1)", t, env)
+	malformedExprTest("(receive! 1)", t, env)
+	malformedExprTest("(send! (list) 1)", t, env)
+}
+
+func TestAtoms(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defvar counter (atom 0))", env)
+	checkExprResultTest("(deref counter)", "0", t, env)
+	checkExprResultTest("(swap! counter + 1)", "1", t, env)
+	checkExprResultTest("(swap! counter (lambda (n) (* n 10)))", "10", t, env)
+	checkExprResultTest("counter", "#<atom: 10>", t, env)
+	checkExprResultTest("(swap! counter + 1 2 3)", "16", t, env)
+
+	// The function can refer to the atom itself.
+	checkExprResultTest("(swap! counter (lambda (n) (+ n (deref counter))))", "32", t, env)
+
+	// A failing function leaves the value unchanged.
+	malformedExprTest("(swap! counter (lambda (n) (error 'bad')))", t, env)
+	checkExprResultTest("(deref counter)", "32", t, env)
+
+	// Concurrent swaps are not lost.
+	Eval("(defvar hits (atom 0))", env)
+	Eval("(defun hit (times) (loop ((i 0)) (if (= i times) 'done' (begin (swap! hits inc) (recur (inc i))))))", env)
+	Eval("(defvar workers (for/list ((i (range 0 4))) (spawn (hit 250))))", env)
+	checkExprResultTest("(for/list ((w workers)) (await w))", "('done' 'done' 'done' 'done')", t, env)
+	checkExprResultTest("(deref hits)", "1000", t, env)
+
+	// Atoms are only equal to themselves, whatever they hold.
+	Eval("(defvar a (atom 1))", env)
+	Eval("(defvar b (atom 1))", env)
+	checkExprResultTest("(equal? a a)", "true", t, env)
+	checkExprResultTest("(equal? a b)", "false", t, env)
+	checkExprResultTest("(equal? (list a) (list a))", "true", t, env)
+	Eval("(defvar atoms (make-set a b))", env)
+	checkExprResultTest("(set-member? atoms a)", "true", t, env)
+	checkExprResultTest("(swap! a inc)", "2", t, env)
+	checkExprResultTest("(set-member? atoms a)", "true", t, env)
+	checkExprResultTest("(set-member? atoms (atom 2))", "false", t, env)
+	Eval("(defvar self-a (atom nil))", env)
+	Eval("(defvar self-b (atom nil))", env)
+	Eval("(swap! self-a (lambda (x) self-a))", env)
+	Eval("(swap! self-b (lambda (x) self-b))", env)
+	checkExprResultTest("(equal? self-a self-b)", "false", t, env)
+	checkExprResultTest("(equal? (make-channel) (make-channel))", "false", t, env)
+	checkExprResultTest("(equal? (delay 1) (delay 1))", "false", t, env)
+	Eval("(defvar p (delay 1))", env)
+	checkExprResultTest("(equal? p p)", "true", t, env)
+
+	malformedExprTest("(deref 1)", t, env)
+	malformedExprTest("(swap! 1 inc)", t, env)
+	malformedExprTest("(swap! counter 1)", t, env)
+}
+
+func TestPmap(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defun sum-to (n) (loop ((i 0) (acc 0)) (if (> i n) acc (recur (inc i) (+ acc i)))))", env)
+	checkExprResultTest("(pmap sum-to (list 10 100 1000 10000))", "(55 5050 500500 50005000)", t, env)
+	checkExprResultTest("(pmap inc (range 0 20) 3)", "(1 2 3 4 5 6 7 8 9 10 11 12 13 14 15 16 17 18 19 20)", t, env)
+	checkExprResultTest("(pmap (lambda (x) (* x x)) (list 1 2 3) 1)", "(1 4 9)", t, env)
+	checkExprResultTest("(pmap inc (list))", "()", t, env)
+
+	// Lambdas use the random generator and the tracer of the worker calling
+	// them.
+	env.SetOutput(ioutil.Discard)
+	checkExprResultTest("(equal? (pmap (lambda (x) (random 1)) (range 50) 4) (pmap (lambda (x) 0) (range 50)))", "true", t, env)
+	checkExprResultTest("(pmap (lambda (x) (trace (* x 2))) (range 5) 4)", "(0 2 4 6 8)", t, env)
+
+	// The error of the earliest failing element is raised.
+	result := Eval("(pmap (lambda (x) (if (> x 2) (error (interp \"bad ${x}\")) x)) (range 0 10))", env)
+	if result.ErrStr != "bad 3" {
+		t.Errorf("Expected the error of the earliest element, got %q", result.ErrStr)
+	}
+
+	malformedExprTest("(pmap 1 (list 1))", t, env)
+	malformedExprTest("(pmap inc 1)", t, env)
+	malformedExprTest("(pmap inc (list 1) 0)", t, env)
+}
+
+func TestEnums(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(defenum color red green blue)", "(#color.red #color.green #color.blue)", t, env)
+	checkExprResultTest("red", "#color.red", t, env)
+	checkExprResultTest("(equal? red red)", "true", t, env)
+	checkExprResultTest("(equal? red green)", "false", t, env)
+	checkExprResultTest("(equal? red 'red')", "false", t, env)
+	checkExprResultTest("(equal? red (symbol \"red\"))", "false", t, env)
+	checkExprResultTest("(color? blue)", "true", t, env)
+	checkExprResultTest("(color? 'blue')", "false", t, env)
+
+	// Variants of different enums are distinct, even with the same name.
+	Eval("(defvar color-red (identity red))", env)
+	checkExprResultTest("(defenum light red amber)", "(#light.red #light.amber)", t, env)
+	checkExprResultTest("(equal? red color-red)", "false", t, env)
+	checkExprResultTest("(color? red)", "false", t, env)
+	checkExprResultTest("(light? red)", "true", t, env)
+
+	Eval("(defun next (c) (cond ((equal? c red) green) ((equal? c green) blue) (true red)))", env)
+	checkExprResultTest("(next green)", "#color.blue", t, env)
+	Eval("(defun light-next (l) (match l (red amber) (amber red)))", env)
+	checkExprResultTest("(light-next red)", "#light.amber", t, env)
+	checkExprResultTest("(light-next (light-next red))", "#light.red", t, env)
+	malformedExprTest("(light-next color-red)", t, env)
+	checkExprResultTest("(frequencies (list green blue green))", "{#color.green: 2, #color.blue: 1}", t, env)
+
+	malformedExprTest("(defenum size small small)", t, env)
+	malformedExprTest("(defenum op plus +)", t, env)
+	malformedExprTest("(defenum fn list car)", t, env)
+	malformedExprTest("(defenum form if)", t, env)
+	malformedExprTest("(defenum 1 a)", t, env)
+}
+
+func TestRecords(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(defstruct point x y)", "nil", t, env)
+	checkExprResultTest("(make-point 1 2)", "#point{x: 1, y: 2}", t, env)
+	Eval("(defvar p (make-point 1 (list 2 3)))", env)
+	checkExprResultTest("(point-x p)", "1", t, env)
+	checkExprResultTest("(point-y p)", "(2 3)", t, env)
+	checkExprResultTest("(point-with-x p 5)", "#point{x: 5, y: (2 3)}", t, env)
+	checkExprResultTest("p", "#point{x: 1, y: (2 3)}", t, env)
+	checkExprResultTest("(point? p)", "true", t, env)
+	checkExprResultTest("(point? (list 1 2))", "false", t, env)
+
+	// Records are compared structurally.
+	checkExprResultTest("(equal? p (make-point 1 (list 2 3)))", "true", t, env)
+	checkExprResultTest("(equal? p (point-with-x p 2))", "false", t, env)
+	checkExprResultTest("(distinct (list (make-point 1 2) (make-point 1 2) (make-point 2 1)))",
+		"(#point{x: 1, y: 2} #point{x: 2, y: 1})", t, env)
+	checkExprResultTest("(equal? (copy p) p)", "true", t, env)
+
+	// Records of different structs are distinct.
+	checkExprResultTest("(defstruct vec x y)", "nil", t, env)
+	checkExprResultTest("(equal? (make-vec 1 2) (make-point 1 2))", "false", t, env)
+	malformedExprTest("(point-x (make-vec 1 2))", t, env)
+	checkExprResultTest("(defstruct empty)", "nil", t, env)
+	checkExprResultTest("(make-empty)", "#empty{}", t, env)
+
+	malformedExprTest("(make-point 1)", t, env)
+	malformedExprTest("(point-with-x 1 2)", t, env)
+	malformedExprTest("(defstruct pair a a)", t, env)
+	malformedExprTest("(defstruct 1 a)", t, env)
+	Eval("(defvar q-a 1)", env)
+	malformedExprTest("(defstruct q a)", t, env)
+	malformedExprTest("(make-q 1)", t, env)
+}
+
+func TestMatch(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(match (list 1 2) ((list a b) (+ a b)) (_ 0))", "3", t, env)
+	checkExprResultTest("(match (list 1 2 3) ((list a b) (+ a b)) (_ 0))", "0", t, env)
+	checkExprResultTest("(match 5 (1 'one') (5 'five') (_ 'other'))", "'five'", t, env)
+	checkExprResultTest("(match \"a\" ('a' 1) (_ 2))", "1", t, env)
+	checkExprResultTest("(match :b (:a 1) (:b 2))", "2", t, env)
+	checkExprResultTest("(match 7 (n (* n 2)))", "14", t, env)
+	checkExprResultTest("(match (list 1 2 3) ((a . rest) rest))", "(2 3)", t, env)
+	checkExprResultTest("(match (list 1 (list 2 3)) ((list 1 (x y)) (+ x y)))", "5", t, env)
+	checkExprResultTest("(match (list 1 (list 2 3)) ((list 2 (x y)) (+ x y)) ((list _ _) 'two'))", "'two'", t, env)
+	checkExprResultTest("(match (cons 1 (cons 2 (list))) ((a b) b))", "2", t, env)
+	checkExprResultTest("(match 1 ((a) a) (_ 'not a list'))", "'not a list'", t, env)
+
+	// Records are matched by their struct and fields.
+	Eval("(defstruct point x y)", env)
+	Eval("(defstruct vec x y)", env)
+	checkExprResultTest("(match (make-point 1 2) ((vec x y) 'vec') ((point 0 y) y) ((point x y) (list x y)))", "(1 2)", t, env)
+	checkExprResultTest("(match (make-point 0 2) ((point 0 y) y) (_ 0))", "2", t, env)
+	checkExprResultTest("(match (make-point 1 (list 2 3)) ((point _ (a b)) b))", "3", t, env)
+
+	// The bindings are only in scope of the clause.
+	malformedExprTest("(match (list 1 2) ((a 3) 'first') ((b c) a))", t, env)
+
+	// The names of enum variants match the variants, rather than being bound.
+	Eval("(defenum color red green)", env)
+	checkExprResultTest("(match green (red 1) (_ 2))", "2", t, env)
+	checkExprResultTest("(match green (red 1) (green 2))", "2", t, env)
+	checkExprResultTest("(match (list red 1) ((list green n) n) ((list red n) (+ n 1)))", "2", t, env)
+	checkExprResultTest("(match green (red 1) (c c))", "#color.green", t, env)
+	malformedExprTest("(match 1 (red 1) (green 2))", t, env)
+
+	// Other names are bound, even if they are already defined.
+	Eval("(defvar c red)", env)
+	checkExprResultTest("(match green (c c))", "#color.green", t, env)
+
+	malformedExprTest("(match 3 (1 'one'))", t, env)
+	malformedExprTest("(match 3 (+ 1))", t, env)
+	malformedExprTest("(match 3 x)", t, env)
+	malformedExprTest("(match 3 (if 1))", t, env)
+}
+
+func TestMatchGuards(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defun sign (x) (match x ((n :when (> n 0)) \"positive\") ((n :when (< n 0)) \"negative\") (_ \"zero\")))", env)
+	checkExprResultTest("(sign 5)", "\"positive\"", t, env)
+	checkExprResultTest("(sign -2)", "\"negative\"", t, env)
+	checkExprResultTest("(sign 0)", "\"zero\"", t, env)
+
+	// Guards see the bindings of the pattern.
+	checkExprResultTest("(match (list 3 1) (((list a b) :when (< a b)) 'ascending') ((a b) 'descending'))", "'descending'", t, env)
+	checkExprResultTest("(match (list 1 3) (((list a b) :when (< a b)) (- b a)) ((a b) 0))", "2", t, env)
+
+	// A pattern which does not match is not guarded.
+	checkExprResultTest("(match 1 (((a b) :when (error 'never')) 0) (_ 1))", "1", t, env)
+	malformedExprTest("(match 1 ((n :when (error 'bad')) 0) (_ 1))", t, env)
+	malformedExprTest("(match -1 ((n :when (> n 0)) 0))", t, env)
+}
+
+func TestGroupDigits(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(group-digits 1234567)", "\"1,234,567\"", t, env)
+	checkExprResultTest("(group-digits 123)", "\"123\"", t, env)
+	checkExprResultTest("(group-digits 1000)", "\"1,000\"", t, env)
+	checkExprResultTest("(group-digits 0)", "\"0\"", t, env)
+	checkExprResultTest("(group-digits -1234)", "\"-1,234\"", t, env)
+	checkExprResultTest("(group-digits -123456)", "\"-123,456\"", t, env)
+	checkExprResultTest("(group-digits 123456789012345678901234567890)", "\"123,456,789,012,345,678,901,234,567,890\"", t, env)
+
+	// The decimals of floats are kept as they are.
+	checkExprResultTest("(group-digits 1234567.891)", "\"1,234,567.891\"", t, env)
+	checkExprResultTest("(group-digits -1234.5)", "\"-1,234.5\"", t, env)
+	checkExprResultTest("(group-digits 1e21)", "\"1,000,000,000,000,000,000,000\"", t, env)
+	checkExprResultTest("(group-digits 1234.5M)", "\"1,234.5\"", t, env)
+
+	checkExprResultTest("(group-digits 1234567 \" \")", "\"1 234 567\"", t, env)
+	checkExprResultTest("(group-digits 1234567.25 \".\" \",\")", "\"1.234.567,25\"", t, env)
+	checkExprResultTest("(group-digits 1234567 \"\")", "\"1234567\"", t, env)
+
+	malformedExprTest("(group-digits \"12\")", t, env)
+	malformedExprTest("(group-digits (/ 1.0 0))", t, env)
+	malformedExprTest("(group-digits 12 #\\,)", t, env)
+}
+
+func TestReadData(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(read-data \"(1 2.5 'a' :b true nil)\")", "(1 2.5 'a' :b true nil)", t, env)
+	checkExprResultTest("(read-data \"(:name 'lambda' :tags (lisp go))\")", "(:name 'lambda' :tags (lisp go))", t, env)
+	checkExprResultTest("(read-data \"42\")", "42", t, env)
+	checkExprResultTest("(read-data \"()\")", "()", t, env)
+	checkExprResultTest("(symbol? (car (read-data \"(name 1)\")))", "true", t, env)
+	checkExprResultTest("(assoc :b (read-data \"((:a 1) (:b (2 3)))\"))", "(:b (2 3))", t, env)
+
+	// Nothing is evaluated, so calls are read as lists.
+	checkExprResultTest("(read-data \"(defvar x (+ 1 2))\")", "(defvar x (+ 1 2))", t, env)
+	malformedExprTest("x", t, env)
+	checkExprResultTest("(read-data \"(error 'never')\")", "(error 'never')", t, env)
+
+	malformedExprTest("(read-data \"(1 2\")", t, env)
+	malformedExprTest("(read-data \"1 2\")", t, env)
+	malformedExprTest("(read-data 1)", t, env)
+}
+
+func TestSizeof(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(> (sizeof 1) 0)", "true", t, env)
+	checkExprResultTest("(- (sizeof \"abcdef\") (sizeof \"abc\"))", "3", t, env)
+	checkExprResultTest("(> (sizeof 100000000000000000000000000000000) (sizeof 1))", "true", t, env)
+	checkExprResultTest("(> (sizeof (list 1 2 3)) (sizeof (list 1 2)))", "true", t, env)
+	// Nested structures are counted along with their elements.
+	checkExprResultTest("(> (sizeof (list (list 1 2 3))) (sizeof (list (list 1))))", "true", t, env)
+	checkExprResultTest("(> (sizeof (list->vector (list 1 2 3))) (sizeof (list->vector (list 1))))", "true", t, env)
+	checkExprResultTest("(> (sizeof (cons 1 (list 2 3))) (sizeof (list 2 3)))", "true", t, env)
+	checkExprResultTest("(> (sizeof (make-set 1 2 3)) (sizeof (make-set 1)))", "true", t, env)
+	checkExprResultTest("(defstruct point x y)", "nil", t, env)
+	checkExprResultTest("(> (sizeof (make-point \"a long name\" 2)) (sizeof (make-point \"a\" 2)))", "true", t, env)
+	checkExprResultTest("(> (sizeof (lambda (x) x)) 0)", "true", t, env)
+
+	malformedExprTest("(sizeof)", t, env)
+	malformedExprTest("(sizeof 1 2)", t, env)
+}
+
+func TestNumericPolicy(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	// By default, overflowing results are promoted, and division truncates.
+	checkExprResultTest("(+ 9223372036854775807 1)", "9223372036854775808", t, env)
+	checkExprResultTest("(/ 7 2)", "3", t, env)
+
+	checkExprResultTest("(set-numeric-policy :strict)", "(:strict :truncate)", t, env)
+	malformedExprTest("(+ 9223372036854775807 1)", t, env)
+	malformedExprTest("(- -9223372036854775808 1)", t, env)
+	malformedExprTest("(* 9223372036854775807 2)", t, env)
+	malformedExprTest("(/ -9223372036854775808 -1)", t, env)
+	malformedExprTest("(divmod -9223372036854775808 -1)", t, env)
+	checkExprResultTest("(divmod -9223372036854775807 -1)", "9223372036854775807 0", t, env)
+	checkExprResultTest("(divmod -9223372036854775809 -1)", "9223372036854775809 0", t, env)
+	malformedExprTest("(inc 9223372036854775807)", t, env)
+	malformedExprTest("(expt 2 64)", t, env)
+	checkExprResultTest("(expt 2 62)", "4611686018427387904", t, env)
+	checkExprResultTest("(+ 1 2)", "3", t, env)
+	// Big ints which were written as such are still allowed.
+	checkExprResultTest("(+ 9223372036854775808 1)", "9223372036854775809", t, env)
+	checkExprResultTest("(factorial 25)", "15511210043330985984000000", t, env)
+	// Methods are evaluated with the policy of the environment calling them.
+	checkExprResultTest("(defun double (x) (* x 2))", "<Method: double>", t, env)
+	malformedExprTest("(double 9223372036854775807)", t, env)
+
+	checkExprResultTest("(set-numeric-policy :float)", "(:strict :float)", t, env)
+	checkExprResultTest("(/ 7 2)", "3.5", t, env)
+	// Floats with integral values are printed without a fraction.
+	checkExprResultTest("(/ 6 3)", "2", t, env)
+	checkExprResultTest("(type-of (/ 12 4))", ":float", t, env)
+	checkExprResultTest("(/ 9223372036854775808 2)", "4.611686018427388e+18", t, env)
+	malformedExprTest("(/ 7 0)", t, env)
+
+	checkExprResultTest("(set-numeric-policy :promote :truncate)", "(:promote :truncate)", t, env)
+	checkExprResultTest("(* 9223372036854775807 2)", "18446744073709551614", t, env)
+	checkExprResultTest("(/ 7 2)", "3", t, env)
+	checkExprResultTest("(type-of (/ 12 4))", ":int", t, env)
+
+	// The policy is shared by the whole interpreter, so setting it within a
+	// method changes it for the callers too.
+	saneExprTest("(defun strictly () (set-numeric-policy :strict))", t, env)
+	checkExprResultTest("(strictly)", "(:strict :truncate)", t, env)
+	malformedExprTest("(+ 9223372036854775807 1)", t, env)
+	checkExprResultTest("((lambda () (set-numeric-policy :promote)))", "(:promote :truncate)", t, env)
+	checkExprResultTest("(+ 9223372036854775807 1)", "9223372036854775808", t, env)
+
+	malformedExprTest("(set-numeric-policy :fast)", t, env)
+	malformedExprTest("(set-numeric-policy 'strict')", t, env)
+	malformedExprTest("(set-numeric-policy)", t, env)
+}
+
+func TestBreak(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+	env.SetInput(strings.NewReader("x\n(+ x 1) (* x 3)\nundefined\n:vars\n\n:continue\n"))
+
+	checkExprResultTest("(defun f (x) (begin (break 'f') (* x 2)))", "<Method: f>", t, env)
+	checkExprResultTest("(f 5)", "10", t, env)
+	expected := "Break at f. Enter :continue to resume, or :vars to list the variables in scope.\n" +
+		"break> 5\n" +
+		"break> 6\n15\n" +
+		"break> Error: Undefined variable: undefined\n" +
+		"break> x = 5\n" +
+		"break> break> "
+	if out.String() != expected {
+		t.Errorf("Expected the break REPL to print %q, but it printed %q", expected, out.String())
+	}
+
+	// The REPL also ends at the end of the input.
+	out.Reset()
+	checkExprResultTest("(begin (break) 1)", "1", t, env)
+	expected = "Break. Enter :continue to resume, or :vars to list the variables in scope.\n" +
+		"break> \n"
+	if out.String() != expected {
+		t.Errorf("Expected the break REPL to print %q, but it printed %q", expected, out.String())
+	}
+	malformedExprTest("(break 1 2)", t, env)
+}
+
+func TestStackTraces(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defun inner (x) (error (interp \"bad ${x}\")))", env)
+	Eval("(defun middle (x) (+ 1 (inner (* x 2))))", env)
+	Eval("(defun outer (x) (middle (inc x)))", env)
+	result := Eval("(outer 1)", env)
+	if result.ErrStr != "bad 4" {
+		t.Errorf("Expected the error to be %q, but was %q", "bad 4", result.ErrStr)
+	}
+	expected := "  in (inner 4) at 1:24\n  in (middle 2) at 1:18\n  in (outer 1) at 1:1"
+	if result.StackTrace != expected {
+		t.Errorf("Expected the stack trace to be %q, but was %q", expected, result.StackTrace)
+	}
+
+	// The trace is also kept by error values. Calls made by operators have
+	// no position in the source.
+	checkExprResultTest("(error-trace (try-> 1 outer))", "(\"(inner 4) at 1:24\" \"(middle 2) at 1:18\" \"(outer 1)\")", t, env)
+	checkExprResultTest("(error-message (try-> 1 outer))", "\"bad 4\"", t, env)
+	checkExprResultTest("(error-trace (try-> 1 (error \"top\")))", "()", t, env)
+	malformedExprTest("(error-trace 1)", t, env)
+
+	// Errors raised outside of methods do not have a trace.
+	result = Eval("(error \"top\")", env)
+	if result.StackTrace != "" {
+		t.Errorf("Expected no stack trace, but got %q", result.StackTrace)
+	}
+
+	// recur replaces the frame of the call, and deep stacks are cut off.
+	Eval("(defun countdown (n) (if (= n 0) (error \"done\") (recur (dec n))))", env)
+	result = Eval("(countdown 3)", env)
+	if result.StackTrace != "  in (countdown 0) at 1:1" {
+		t.Errorf("Expected the stack trace to be %q, but was %q", "  in (countdown 0) at 1:1", result.StackTrace)
+	}
+	Eval("(defun deep (n) (if (= n 0) (error \"done\") (deep (dec n))))", env)
+	result = Eval("(deep 30)", env)
+	lines := strings.Split(result.StackTrace, "\n")
+	if len(lines) != maxTraceFrames+1 || lines[0] != "  in (deep 0) at 1:44" || lines[maxTraceFrames] != "  ... and 11 more calls" {
+		t.Errorf("Expected the stack trace to be cut off, but was %q", result.StackTrace)
+	}
+
+	// Positions count lines and columns across the whole input, including
+	// the forms after the first one.
+	result = Eval("(defun first () (error \"x\"))\n(defun second ()\n  (first))", env)
+	result = Eval(result.RemainingTokens, env)
+	result = Eval("\n  (second)", env)
+	expected = "  in (first) at 3:3\n  in (second) at 2:3"
+	if result.StackTrace != expected {
+		t.Errorf("Expected the stack trace to be %q, but was %q", expected, result.StackTrace)
+	}
+}
+
+func TestDeprecation(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	var out bytes.Buffer
+	env.SetOutput(&out)
+
+	Eval("(defun old-sum (a b) (+ a b))", env)
+	checkExprResultTest("(deprecate old-sum +)", "nil", t, env)
+	checkExprResultTest("(old-sum 1 2)", "3", t, env)
+	checkExprResultTest("(old-sum 3 4)", "7", t, env)
+	checkExprResultTest("((lambda (f) (f 5 6)) old-sum)", "11", t, env)
+	expected := "Warning: old-sum is deprecated, use + instead.\n"
+	if out.String() != expected {
+		t.Errorf("Expected the warning to be printed once as %q, but got %q", expected, out.String())
+	}
+
+	// Builtin operators can be deprecated too, and are warned about when they
+	// are passed around.
+	out.Reset()
+	checkExprResultTest("(deprecate dec inc)", "nil", t, env)
+	checkExprResultTest("(pmap dec (list 1 2 3))", "(0 1 2)", t, env)
+	expected = "Warning: dec is deprecated, use inc instead.\n"
+	if out.String() != expected {
+		t.Errorf("Expected the warning to be printed once as %q, but got %q", expected, out.String())
+	}
+
+	out.Reset()
+	env.SetDeprecationWarnings(false)
+	Eval("(defun older-sum (a b) (+ a b))", env)
+	checkExprResultTest("(deprecate older-sum old-sum)", "nil", t, env)
+	checkExprResultTest("(older-sum 1 2)", "3", t, env)
+	if out.Len() != 0 {
+		t.Errorf("Expected no warnings when they are disabled, but got %q", out.String())
+	}
+
+	// Deprecations apply to the whole interpreter, also when they are made
+	// within a method.
+	out.Reset()
+	env.SetDeprecationWarnings(true)
+	Eval("(defun dep () (deprecate inc dec))", env)
+	checkExprResultTest("(dep)", "nil", t, env)
+	checkExprResultTest("(inc 1)", "2", t, env)
+	expected = "Warning: inc is deprecated, use dec instead.\n"
+	if out.String() != expected {
+		t.Errorf("Expected the warning to be printed once as %q, but got %q", expected, out.String())
+	}
+
+	// Deprecating an alias leaves the operator it refers to as it is.
+	out.Reset()
+	Eval("(alias plus +)", env)
+	checkExprResultTest("(deprecate plus +)", "nil", t, env)
+	checkExprResultTest("(+ 1 2)", "3", t, env)
+	if out.Len() != 0 {
+		t.Errorf("Expected no warnings for the operator an alias refers to, but got %q", out.String())
+	}
+	checkExprResultTest("(plus 1 2)", "3", t, env)
+	expected = "Warning: plus is deprecated, use + instead.\n"
+	if out.String() != expected {
+		t.Errorf("Expected the warning to be printed once as %q, but got %q", expected, out.String())
+	}
+
+	malformedExprTest("(deprecate missing +)", t, env)
+	malformedExprTest("(deprecate old-sum missing)", t, env)
+	malformedExprTest("(deprecate 1 +)", t, env)
+}
+
+func TestREPLSettings(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	if env.Prompt() != "lambda> " {
+		t.Errorf("Expected the default prompt, got %q", env.Prompt())
+	}
+	checkExprResultTest("(set-repl-prompt \"λ> \")", "\"λ> \"", t, env)
+	if env.Prompt() != "λ> " {
+		t.Errorf("Expected the prompt to be changed, got %q", env.Prompt())
+	}
+	// The settings are shared with the environments of methods.
+	checkExprResultTest("((lambda () (set-repl-prompt \"> \")))", "\"> \"", t, env)
+	if env.Prompt() != "> " {
+		t.Errorf("Expected the prompt to be changed within a method, got %q", env.Prompt())
+	}
+
+	checkResult := func(expr, expected string) {
+		formatted, err := env.FormatResult(Eval(expr, env))
+		if err != nil || formatted != expected {
+			t.Errorf("Expected %s to be printed as %q, got %q (error: %v)", expr, expected, formatted, err)
+		}
+	}
+	checkResult("(+ 1 2)", "3")
+	checkExprResultTest("(set-repl-formatter (lambda (v) (interp \"${(type-of v)} ${v}\")))", "nil", t, env)
+	checkResult("(+ 1 2)", ":int 3")
+	checkResult("(list 1 2)", ":list (1 2)")
+	checkResult("100000000000000000000", ":big-int 100000000000000000000")
+	// Eval itself is not affected.
+	checkExprResultTest("(+ 1 2)", "3", t, env)
+
+	Eval("(defun shout (v) (* v 10))", env)
+	checkExprResultTest("(set-repl-formatter shout)", "nil", t, env)
+	checkResult("(+ 1 2)", "30")
+	if _, err := env.FormatResult(Eval("\"a\"", env)); err == nil {
+		t.Errorf("Expected an error when the formatter fails")
+	}
+	checkExprResultTest("(set-repl-formatter nil)", "nil", t, env)
+	checkResult("\"a\"", "\"a\"")
+
+	checkExprResultTest("(type-of 1.5)", ":float", t, env)
+	checkExprResultTest("(type-of (make-set))", ":set", t, env)
+	malformedExprTest("(set-repl-prompt 1)", t, env)
+	malformedExprTest("(set-repl-formatter 1)", t, env)
+}
+
+func TestSerialize(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	roundTrips := []string{
+		"1", "-42", "9223372036854775807", "123456789012345678901234567890", "-123456789012345678901234567890",
+		"2.5", "nan", "-inf", "0.1M", "true", "false", "nil", "\"double\"", "'single'", "\"\"", "#\\λ",
+		":kw", "(symbol \"sym\")", "(list 1 (list 2.5 \"a\") (list))", "(cons 1 (list 2 3))",
+		"(list->vector (list 1 2))", "(make-set 1 \"1\" 2)",
+		"(group-by even? (range 6))", "(try-> 1 (error \"boom\"))", "(serialize (list 1 2))",
+	}
+	for _, expr := range roundTrips {
+		checkExprResultTest(fmt.Sprintf("(equal? (deserialize (serialize %s)) %s)", expr, expr), "true", t, env)
+		want := Eval(expr, env).ValStr
+		checkExprResultTest(fmt.Sprintf("(deserialize (serialize %s))", expr), want, t, env)
+	}
+
+	// The exact types are kept, which printing a value does not do.
+	checkExprResultTest("(type-of (deserialize (serialize 100000000000000000000)))", ":big-int", t, env)
+	checkExprResultTest("(type-of (deserialize (serialize 0.1M)))", ":big-float", t, env)
+	checkExprResultTest("(equal? (deserialize (serialize (/ 1M 3M))) (/ 1M 3M))", "true", t, env)
+	checkExprResultTest("(defenum color red green)", "(#color.red #color.green)", t, env)
+	checkExprResultTest("(defstruct point x y)", "nil", t, env)
+	checkExprResultTest("(deserialize (serialize (make-point red (list 1 2))))", "#point{x: #color.red, y: (1 2)}", t, env)
+	checkExprResultTest("(point-y (deserialize (serialize (make-point 1 2))))", "2", t, env)
+
+	// The encoding is compact.
+	checkExprResultTest("(serialize (list 1 2 3))", "#<blob: 9 bytes>", t, env)
+	checkExprResultTest("(equal? (serialize (list 1 2)) (serialize (list 1 2)))", "true", t, env)
+	checkExprResultTest("(equal? (serialize (list 1 2)) (serialize (list 1 3)))", "false", t, env)
+
+	dir, err := ioutil.TempDir("", "lambda")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	Eval(fmt.Sprintf("(defvar path '%s/cache.bin')", dir), env)
+	checkExprResultTest("(serialize (list 1 100000000000000000000 \"a\") path)", "nil", t, env)
+	checkExprResultTest("(deserialize path)", "(1 100000000000000000000 \"a\")", t, env)
+
+	malformedExprTest("(serialize (lambda (x) x))", t, env)
+	malformedExprTest("(serialize (atom 1))", t, env)
+	result := Eval("(serialize (atom 1))", env)
+	if want := "For serialize, cannot serialize #<atom: 1>, which is an atom"; result.ErrStr != want {
+		t.Errorf("Expected the error to be %q, but was %q", want, result.ErrStr)
+	}
+	malformedExprTest("(deserialize 1)", t, env)
+	malformedExprTest(fmt.Sprintf("(deserialize '%s/missing.bin')", dir), t, env)
+
+	env.SetSandboxed(true)
+	checkExprResultTest("(deserialize (serialize 1))", "1", t, env)
+	malformedExprTest("(serialize 1 path)", t, env)
+	malformedExprTest("(deserialize path)", t, env)
+
+	// Corrupt data is rejected, rather than read past.
+	for _, data := range [][]byte{{}, {2, intTag, 1}, {1}, {1, listTag, 5, intTag}, {1, intTag, 2, 0}, {1, 200}} {
+		if _, err := deserializeValue(data); err == nil {
+			t.Errorf("Expected %v to be rejected as corrupt", data)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(diff (list 1 (list 2 3)) (list 1 (list 2 3)))", "()", t, env)
+	checkExprResultTest("(diff 1 2)", "((() 1 2))", t, env)
+	checkExprResultTest("(diff (list 1 (list 2 3)) (list 1 (list 2 4)))", "(((1 1) 3 4))", t, env)
+	checkExprResultTest("(diff (list 1 2 3) (list 0 2 4))", "(((0) 1 0) ((2) 3 4))", t, env)
+	checkExprResultTest("(diff (list 1 2) (list 1 2 3))", "(((2) :missing 3))", t, env)
+	checkExprResultTest("(diff (cons 1 (list 2)) (list 1 2))", "()", t, env)
+	checkExprResultTest("(diff (list->vector (list 1 2)) (list->vector (list 1 5)))", "(((1) 2 5))", t, env)
+	// Values of different types are different as a whole.
+	checkExprResultTest("(diff (list 1) (list->vector (list 1)))", "((() (1) [1]))", t, env)
+	checkExprResultTest("(diff (list 1 \"a\") (list 1 2))", "(((1) \"a\" 2))", t, env)
+
+	checkExprResultTest("(diff (frequencies (list :a :b :b)) (frequencies (list :a :a :c)))",
+		"(((:a) 1 2) ((:b) 2 :missing) ((:c) :missing 1))", t, env)
+	checkExprResultTest("(defstruct point x y)", "nil", t, env)
+	checkExprResultTest("(diff (make-point 1 (list 2 3)) (make-point 1 (list 2 4)))", "(((:y 1) 3 4))", t, env)
+	checkExprResultTest("(diff (make-set 1 2) (make-set 1 3))", "((() #{1 2} #{1 3}))", t, env)
+	malformedExprTest("(diff 1)", t, env)
+}
+
+func TestFloatInternals(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(float-bits 1.0)", "(0 1023 0)", t, env)
+	checkExprResultTest("(float-bits -2.0)", "(1 1024 0)", t, env)
+	checkExprResultTest("(float-bits 1.5)", "(0 1023 2251799813685248)", t, env)
+	checkExprResultTest("(float-bits 0.0)", "(0 0 0)", t, env)
+	checkExprResultTest("(float-bits 3)", "(0 1024 2251799813685248)", t, env)
+	checkExprResultTest("(float-bits inf)", "(0 2047 0)", t, env)
+	checkExprResultTest("(float-bits 5e-324)", "(0 0 1)", t, env)
+
+	checkExprResultTest("(ulp 1.0)", "2.220446049250313e-16", t, env)
+	checkExprResultTest("(ulp -1.0)", "2.220446049250313e-16", t, env)
+	checkExprResultTest("(ulp 0.0)", "5e-324", t, env)
+	checkExprResultTest("(ulp 1e16)", "2", t, env)
+	checkExprResultTest("(ulp 1.7976931348623157e308)", "1.99584030953472e+292", t, env)
+	checkExprResultTest("(ulp inf)", "inf", t, env)
+	checkExprResultTest("(ulp nan)", "nan", t, env)
+	// The ulp is why adding it to a float changes it, and adding less does not.
+	checkExprResultTest("(= (+ 1e16 1.0) 1e16)", "true", t, env)
+
+	malformedExprTest("(float-bits 1.5M)", t, env)
+	malformedExprTest("(float-bits \"1\")", t, env)
+	malformedExprTest("(ulp 100000000000000000000)", t, env)
+}
+
+func TestClamp(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(clamp 5 0 10)", "5", t, env)
+	checkExprResultTest("(clamp -5 0 10)", "0", t, env)
+	checkExprResultTest("(clamp 15 0 10)", "10", t, env)
+	checkExprResultTest("(clamp 10 10 10)", "10", t, env)
+	// The operands are converted to the widest type among them.
+	checkExprResultTest("(clamp 15 0 2.5)", "2.5", t, env)
+	checkExprResultTest("(type-of (clamp 1 0 2.5))", ":float", t, env)
+	checkExprResultTest("(clamp 1.4 0 1)", "1", t, env)
+	checkExprResultTest("(clamp 100000000000000000000 0 10)", "10", t, env)
+	checkExprResultTest("(clamp -100000000000000000000 0 100000000000000000001)", "0", t, env)
+	checkExprResultTest("(clamp 0.5M 1 2)", "1", t, env)
+	checkExprResultTest("(clamp inf 0 1.5)", "1.5", t, env)
+	checkExprResultTest("(clamp nan 0 1.5)", "nan", t, env)
+
+	malformedExprTest("(clamp 5 10 0)", t, env)
+	malformedExprTest("(clamp 5 nan 10)", t, env)
+	malformedExprTest("(clamp \"a\" 0 10)", t, env)
+	malformedExprTest("(clamp 5 0)", t, env)
+}
+
+func TestInterpolation(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(lerp 0 10 0.25)", "2.5", t, env)
+	checkExprResultTest("(lerp 0 10 0)", "0", t, env)
+	checkExprResultTest("(lerp 0.1 0.7 1)", "0.7", t, env)
+	checkExprResultTest("(lerp 10 20 0.5)", "15", t, env)
+	checkExprResultTest("(lerp 10 -10 0.75)", "-5", t, env)
+	checkExprResultTest("(lerp 0 10 1.5)", "15", t, env)
+	checkExprResultTest("(type-of (lerp 0 10 0))", ":float", t, env)
+
+	checkExprResultTest("(map-range 5 0 10 0 100)", "50", t, env)
+	checkExprResultTest("(map-range 0 0 10 0 100)", "0", t, env)
+	checkExprResultTest("(map-range 10 0 10 0 100)", "100", t, env)
+	checkExprResultTest("(map-range 2 0 8 100 0)", "75", t, env)
+	checkExprResultTest("(map-range -1 -1 1 0 1)", "0", t, env)
+	checkExprResultTest("(map-range 20 0 10 0 100)", "200", t, env)
+	checkExprResultTest("(map-range 5 10 0 0 1)", "0.5", t, env)
+
+	malformedExprTest("(map-range 5 3 3 0 100)", t, env)
+	malformedExprTest("(lerp 0 \"10\" 0.5)", t, env)
+	malformedExprTest("(lerp 0 10)", t, env)
+	malformedExprTest("(map-range 5 0 10 0)", t, env)
+}
+
+func TestSumAndProduct(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(sum (list 1 2 3))", "6", t, env)
+	checkExprResultTest("(product (list 2 3 4))", "24", t, env)
+	checkExprResultTest("(sum (list))", "0", t, env)
+	checkExprResultTest("(product (list))", "1", t, env)
+	checkExprResultTest("(sum (list 7))", "7", t, env)
+	checkExprResultTest("(sum (list 1 2.5))", "3.5", t, env)
+	checkExprResultTest("(product (list 0.5M 4))", "2", t, env)
+	checkExprResultTest("(sum (cons 1 (list 2)))", "3", t, env)
+	checkExprResultTest("(sum (range 1 101))", "5050", t, env)
+	// Overflowing results are promoted like with + and *.
+	checkExprResultTest("(sum (list 9223372036854775807 1))", "9223372036854775808", t, env)
+	checkExprResultTest("(product (list 9223372036854775807 2 3))", "55340232221128654842", t, env)
+	checkExprResultTest("(set-numeric-policy :strict)", "(:strict :truncate)", t, env)
+	malformedExprTest("(sum (list 9223372036854775807 1))", t, env)
+
+	malformedExprTest("(sum (list \"a\" \"b\"))", t, env)
+	malformedExprTest("(sum 1)", t, env)
+	malformedExprTest("(sum (list 1) (list 2))", t, env)
+}
+
+func TestStatistics(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	checkExprResultTest("(mean (list 1 2 3 4))", "2.5", t, env)
+	checkExprResultTest("(mean (list 5))", "5", t, env)
+	checkExprResultTest("(type-of (mean (list 2 4)))", ":float", t, env)
+	checkExprResultTest("(mean (list 100000000000000000000 300000000000000000000))", "2e+20", t, env)
+
+	checkExprResultTest("(median (list 3 1 2))", "2", t, env)
+	checkExprResultTest("(median (list 4 1 3 2))", "2.5", t, env)
+	checkExprResultTest("(median (list 7))", "7", t, env)
+	checkExprResultTest("(median (list 1.5 0.5M -3))", "0.5", t, env)
+	// The list itself is not sorted.
+	checkExprResultTest("(defvar xs (list 3 1 2))", "(3 1 2)", t, env)
+	checkExprResultTest("(median xs)", "2", t, env)
+	checkExprResultTest("xs", "(3 1 2)", t, env)
+
+	checkExprResultTest("(stddev (list 2 4 4 4 5 5 7 9))", "2", t, env)
+	checkExprResultTest("(stddev (list 1 1 1))", "0", t, env)
+	checkExprResultTest("(stddev (list 1 2 3 4) :sample)", "1.2909944487358056", t, env)
+
+	for _, op := range []string{"mean", "median", "stddev"} {
+		malformedExprTest(fmt.Sprintf("(%s (list))", op), t, env)
+		malformedExprTest(fmt.Sprintf("(%s (list 1 \"2\"))", op), t, env)
+		malformedExprTest(fmt.Sprintf("(%s 1)", op), t, env)
+	}
+	malformedExprTest("(stddev (list 1) :sample)", t, env)
+	malformedExprTest("(stddev (list 1 2) :population)", t, env)
+}
+
+func TestCycles(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+
+	Eval("(defvar a (atom 1))", env)
+	checkExprResultTest("(has-cycle? a)", "false", t, env)
+	checkExprResultTest("(swap! a (lambda (v) (list v a)))", "(1 #<atom: (1 #circular)>)", t, env)
+	checkExprResultTest("a", "#<atom: (1 #circular)>", t, env)
+	checkExprResultTest("(list a a)", "(#<atom: (1 #circular)> #<atom: (1 #circular)>)", t, env)
+	checkExprResultTest("(has-cycle? a)", "true", t, env)
+	checkExprResultTest("(has-cycle? (list 1 a))", "true", t, env)
+	// Comparing and hashing values containing themselves terminates too.
+	checkExprResultTest("(equal? a a)", "true", t, env)
+	checkExprResultTest("(make-set a a)", "#{#<atom: (1 #circular)>}", t, env)
+	checkExprResultTest("(diff (list a) (list 1))", "(((0) #<atom: (1 #circular)> 1))", t, env)
+
+	// Atoms referring to each other.
+	Eval("(defvar b (atom 0))", env)
+	Eval("(defvar c (atom b))", env)
+	checkExprResultTest("(has-cycle? c)", "false", t, env)
+	checkExprResultTest("(swap! b (lambda (v) c))", "#<atom: #<atom: #circular>>", t, env)
+	checkExprResultTest("(has-cycle? c)", "true", t, env)
+
+	// Promises which result in themselves.
+	Eval("(defvar p (delay (list 1 p)))", env)
+	checkExprResultTest("(has-cycle? p)", "false", t, env)
+	checkExprResultTest("(force p)", "(1 #<promise: (1 #circular)>)", t, env)
+	checkExprResultTest("p", "#<promise: (1 #circular)>", t, env)
+	checkExprResultTest("(has-cycle? p)", "true", t, env)
+
+	// Shared values are not cycles.
+	Eval("(defvar shared (atom 1))", env)
+	checkExprResultTest("(has-cycle? (list shared shared (list->vector (list shared))))", "false", t, env)
+	checkExprResultTest("(list shared shared)", "(#<atom: 1> #<atom: 1>)", t, env)
+	checkExprResultTest("(has-cycle? (list 1 (list 2 3)))", "false", t, env)
+	checkExprResultTest("(has-cycle? 1)", "false", t, env)
+}
@@ -0,0 +1,748 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	list      string = "list"
+	rangeOp   string = "range"
+	forList   string = "for/list"
+	equal     string = "equal?"
+	assoc     string = "assoc"
+	assq      string = "assq"
+	member    string = "member"
+	isMember  string = "member?"
+	take      string = "take"
+	drop      string = "drop"
+	flatten   string = "flatten"
+	zip       string = "zip"
+	unzip     string = "unzip"
+	partition string = "partition"
+	distinct  string = "distinct"
+	copyOp    string = "copy"
+	cons      string = "cons"
+	car       string = "car"
+	cdr       string = "cdr"
+	indexOf   string = "index-of"
+	// Precedes a guard expression among the clauses of for/list.
+	whenGuard string = ":when"
+)
+
+// Returns a copy of the value, which does not share any structure with it.
+// Lists, vectors, records, maps and sets are copied along with everything nested
+// within them.
+// Other values are immutable, and are returned as they are. This includes
+// promises and streams, whose elements are computed at most once and shared by
+// every reference to them.
+func deepCopy(v Value) Value {
+	switch val := v.(type) {
+	case consValue:
+		return deepCopy(consToList(val))
+	case listValue:
+		values := make([]Value, len(val.values))
+		for i, elem := range val.values {
+			values[i] = deepCopy(elem)
+		}
+		return newListValue(values)
+	case vectorValue:
+		values := make([]Value, len(val.values))
+		for i, elem := range val.values {
+			values[i] = deepCopy(elem)
+		}
+		return newVectorValue(values)
+	case recordValue:
+		values := make([]Value, len(val.values))
+		for i, elem := range val.values {
+			values[i] = deepCopy(elem)
+		}
+		return recordValue{val.name, val.fields, values}
+	case mapValue:
+		result := newMapValue()
+		for _, k := range val.order {
+			entry := val.entries[k]
+			result.put(deepCopy(entry.key), deepCopy(entry.value))
+		}
+		return result
+	case setValue:
+		result := newSetValue()
+		for _, elem := range val.values() {
+			result.add(deepCopy(elem))
+		}
+		return result
+	}
+	return v
+}
+
+// Evaluates the body of a for/list once for every combination of values the
+// clauses iterate over, appending the results to collected. Each clause is
+// either `(pattern list)`, which binds the pattern to every element of the
+// list in turn, or `:when guard`, which skips the remaining clauses and the
+// body when the guard is falsey. Later clauses are nested within the earlier
+// ones, and can refer to their bindings.
+func forListHelper(env *LangEnv, clauses []*ASTNode, body []*ASTNode, collected *[]Value) error {
+	if len(clauses) == 0 {
+		result := evalASTs(env, body)
+		if result.Err != nil {
+			return result.Err
+		}
+		*collected = append(*collected, result.Val)
+		return nil
+	}
+
+	clause := clauses[0]
+	if clause.isValue && clause.value == whenGuard {
+		if len(clauses) < 2 {
+			return errors.New(fmt.Sprintf("Missing guard expression after %s in %s", whenGuard, forList))
+		}
+		guard := evalASTHelper(env, clauses[1])
+		if guard.Err != nil {
+			return guard.Err
+		}
+		if !isTruthy(guard.Val) {
+			return nil
+		}
+		return forListHelper(env, clauses[2:], body, collected)
+	}
+
+	if clause.isValue || len(clause.children) != 2 {
+		return errors.New(fmt.Sprintf("Clauses for %s should be of the format `(pattern list)` or `%s guard`.",
+			forList, whenGuard))
+	}
+	result := evalASTHelper(env, clause.children[1])
+	if result.Err != nil {
+		return result.Err
+	}
+	listVal, ok := consToList(result.Val).(listValue)
+	if !ok {
+		return errors.New(fmt.Sprintf("For %s, expected %s to be a list", forList, result.Val.Str()))
+	}
+	for _, v := range listVal.values {
+		iterEnv := env.newChildEnv()
+		if err := bindPattern(iterEnv, clause.children[0], v); err != nil {
+			return err
+		}
+		if err := forListHelper(iterEnv, clauses[1:], body, collected); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the handler for an operator which looks up a key in a list of
+// `(key value)` pairs, comparing the keys using matches. The first matching pair
+// is returned, or nil if there is none. The key is not converted to a list, so
+// that cons cells can be matched by identity.
+func assocHandler(symbol string, matches func(Value, Value) bool) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		key := operands[0].Val
+		alist, ok := consToList(operands[1].Val).(listValue)
+		if !ok {
+			retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+				symbol, operands[1].Val.Str()))
+			return retVal
+		}
+		for _, v := range alist.values {
+			pair, ok := consToList(v).(listValue)
+			if !ok || len(pair.values) != 2 {
+				retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a (key value) pair",
+					symbol, v.Str()))
+				return retVal
+			}
+			if matches(key, pair.values[0]) {
+				retVal.Val = v
+				return retVal
+			}
+		}
+		retVal.Val = newNilValue()
+		return retVal
+	}
+}
+
+// Returns the index of the first element of the list which is equal? to the
+// target, or -1 if there is none. Nested lists are compared as a whole, so
+// their elements are not searched.
+func memberIndex(symbol string, operands []Atom) (int, error) {
+	listVal, ok := operands[1].Val.(listValue)
+	if !ok {
+		return -1, errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+			symbol, operands[1].Val.Str()))
+	}
+	for i, v := range listVal.values {
+		if isEqual(operands[0].Val, v) {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
+
+// Returns the index of the first occurrence of the target in the string or the
+// list, at or after start, or -1 if there is none. Substrings are looked for in
+// strings, and elements which are equal? to the target in lists. Indices into
+// strings count characters, not bytes.
+func indexIn(symbol string, container, target Value, start int) (int, error) {
+	switch c := container.(type) {
+	case stringValue:
+		substr, err := stringOperand(symbol, Atom{Val: target})
+		if err != nil {
+			return -1, err
+		}
+		str := c.contents()
+		offset := 0
+		for i := 0; i < start; i++ {
+			if offset >= len(str) {
+				return -1, nil
+			}
+			_, size := utf8.DecodeRuneInString(str[offset:])
+			offset += size
+		}
+		i := strings.Index(str[offset:], substr)
+		if i < 0 {
+			return -1, nil
+		}
+		return start + utf8.RuneCountInString(str[offset:offset+i]), nil
+	case listValue:
+		for i := start; i < len(c.values); i++ {
+			if isEqual(target, c.values[i]) {
+				return i, nil
+			}
+		}
+		return -1, nil
+	}
+	return -1, errors.New(fmt.Sprintf("For %s, expected %s to be a string or a list",
+		symbol, container.Str()))
+}
+
+// Returns the count and the list passed to take or drop. The count can be
+// larger than the length of the list, in which case it is capped to it.
+func sliceOperands(symbol string, operands []Atom) (int, []Value, error) {
+	n, ok := operands[0].Val.(intValue)
+	if !ok || n.value < 0 {
+		return 0, nil, errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative integer",
+			symbol, operands[0].Val.Str()))
+	}
+	listVal, ok := operands[1].Val.(listValue)
+	if !ok {
+		return 0, nil, errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+			symbol, operands[1].Val.Str()))
+	}
+	if n.value > int64(len(listVal.values)) {
+		return len(listVal.values), listVal.values, nil
+	}
+	return int(n.value), listVal.values, nil
+}
+
+// Appends the elements of values to flat, replacing the nested lists by their
+// elements, up to depth levels deep. A negative depth is unlimited.
+func flattenValues(values []Value, depth int64, flat []Value) []Value {
+	for _, v := range values {
+		if nested, ok := consToList(v).(listValue); ok && depth != 0 {
+			flat = flattenValues(nested.values, depth-1, flat)
+		} else {
+			flat = append(flat, v)
+		}
+	}
+	return flat
+}
+
+// Returns the i-th elements of all the lists, as a list.
+func nthOfEach(lists []listValue, i int) Value {
+	tuple := make([]Value, len(lists))
+	for j, l := range lists {
+		tuple[j] = l.values[i]
+	}
+	return newListValue(tuple)
+}
+
+func addListOperators(opMap map[string]*Operator) {
+	addOperator(opMap,
+		&Operator{
+			symbol:         list,
+			minArgCount:    0,
+			maxArgCount:    100,
+			keepsConsCells: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = newListValue(operandValues(operands))
+				return retVal
+			},
+		},
+	)
+
+	// Returns the list of integers from start (0 if omitted) up to, but not
+	// including, end, in increments of step (1 if omitted).
+	addOperator(opMap,
+		&Operator{
+			symbol:      rangeOp,
+			minArgCount: 1,
+			maxArgCount: 3,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				bounds := make([]int64, len(operands))
+				for i, o := range operands {
+					intVal, ok := o.Val.(intValue)
+					if !ok {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be an integer",
+							rangeOp, o.Val.Str()))
+						return retVal
+					}
+					bounds[i] = intVal.value
+				}
+
+				start, end, step := int64(0), bounds[0], int64(1)
+				if len(bounds) > 1 {
+					start, end = bounds[0], bounds[1]
+				}
+				if len(bounds) > 2 {
+					step = bounds[2]
+				}
+				if step == 0 {
+					retVal.Err = errors.New(fmt.Sprintf("The step for %s cannot be 0", rangeOp))
+					return retVal
+				}
+
+				values := make([]Value, 0)
+				for i := start; (step > 0 && i < end) || (step < 0 && i > end); i += step {
+					var val intValue
+					val.value = i
+					values = append(values, val)
+				}
+				retVal.Val = newListValue(values)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      forList,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				clausesNode := astVal.astNodes[0]
+				if clausesNode.isValue {
+					retVal.Err = errors.New(fmt.Sprintf("Missing list of clauses for %s", forList))
+					return retVal
+				}
+				collected := make([]Value, 0)
+				retVal.Err = forListHelper(env, clausesNode.children, astVal.astNodes[1:], &collected)
+				if retVal.Err != nil {
+					return retVal
+				}
+				retVal.Val = newListValue(collected)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:         equal,
+			minArgCount:    2,
+			maxArgCount:    2,
+			keepsConsCells: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = newBoolValue(isEqual(operands[0].Val, operands[1].Val))
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:         assoc,
+			minArgCount:    2,
+			maxArgCount:    2,
+			keepsConsCells: true,
+			handler:        assocHandler(assoc, isEqual),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:         assq,
+			minArgCount:    2,
+			maxArgCount:    2,
+			keepsConsCells: true,
+			handler:        assocHandler(assq, isIdentical),
+		},
+	)
+
+	// Returns the rest of the list, starting from the first element which is
+	// equal? to the target, or nil if there is none.
+	addOperator(opMap,
+		&Operator{
+			symbol:      member,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				i, err := memberIndex(member, operands)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				if i < 0 {
+					retVal.Val = newNilValue()
+					return retVal
+				}
+				listVal, _ := operands[1].Val.(listValue)
+				retVal.Val = newListValue(listVal.values[i:])
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      isMember,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				i, err := memberIndex(isMember, operands)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newBoolValue(i >= 0)
+				return retVal
+			},
+		},
+	)
+
+	// Returns the first n elements of the list.
+	addOperator(opMap,
+		&Operator{
+			symbol:      take,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				n, values, err := sliceOperands(take, operands)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				taken := make([]Value, n)
+				copy(taken, values[:n])
+				retVal.Val = newListValue(taken)
+				return retVal
+			},
+		},
+	)
+
+	// Returns all but the first n elements of the list.
+	addOperator(opMap,
+		&Operator{
+			symbol:      drop,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				n, values, err := sliceOperands(drop, operands)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				rest := make([]Value, len(values)-n)
+				copy(rest, values[n:])
+				retVal.Val = newListValue(rest)
+				return retVal
+			},
+		},
+	)
+
+	// Replaces the nested lists in a list by their elements, recursively. An
+	// optional depth limits how many levels of nesting are flattened.
+	addOperator(opMap,
+		&Operator{
+			symbol:      flatten,
+			minArgCount: 1,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[0].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						flatten, operands[0].Val.Str()))
+					return retVal
+				}
+				depth := int64(-1)
+				if len(operands) > 1 {
+					depthVal, ok := operands[1].Val.(intValue)
+					if !ok || depthVal.value < 0 {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative integer",
+							flatten, operands[1].Val.Str()))
+						return retVal
+					}
+					depth = depthVal.value
+				}
+				retVal.Val = newListValue(flattenValues(listVal.values, depth, make([]Value, 0)))
+				return retVal
+			},
+		},
+	)
+
+	// Returns the list of tuples made up of the elements at the same position in
+	// each of the lists. It stops at the end of the shortest list.
+	addOperator(opMap,
+		&Operator{
+			symbol:      zip,
+			minArgCount: 1,
+			maxArgCount: 100,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				lists := make([]listValue, len(operands))
+				shortest := -1
+				for i, o := range operands {
+					listVal, ok := o.Val.(listValue)
+					if !ok {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list", zip, o.Val.Str()))
+						return retVal
+					}
+					lists[i] = listVal
+					if shortest < 0 || len(listVal.values) < shortest {
+						shortest = len(listVal.values)
+					}
+				}
+				tuples := make([]Value, shortest)
+				for i := range tuples {
+					tuples[i] = nthOfEach(lists, i)
+				}
+				retVal.Val = newListValue(tuples)
+				return retVal
+			},
+		},
+	)
+
+	// The reverse of zip. Returns the list of the first elements of the tuples,
+	// the list of the second elements and so on, as a list. All the tuples have
+	// to be of the same length.
+	addOperator(opMap,
+		&Operator{
+			symbol:      unzip,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[0].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						unzip, operands[0].Val.Str()))
+					return retVal
+				}
+				tuples := make([]listValue, len(listVal.values))
+				for i, v := range listVal.values {
+					tuple, ok := consToList(v).(listValue)
+					if !ok || (i > 0 && len(tuple.values) != len(tuples[0].values)) {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list of tuples of the same length",
+							unzip, listVal.Str()))
+						return retVal
+					}
+					tuples[i] = tuple
+				}
+				lists := make([]Value, 0)
+				if len(tuples) > 0 {
+					for i := range tuples[0].values {
+						lists = append(lists, nthOfEach(tuples, i))
+					}
+				}
+				retVal.Val = newListValue(lists)
+				return retVal
+			},
+		},
+	)
+
+	// Splits the list into the elements for which the predicate is truthy, and
+	// the ones for which it is not, returned as multiple values. The elements
+	// stay in the same order.
+	addOperator(opMap,
+		&Operator{
+			symbol:      partition,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[1].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						partition, operands[1].Val.Str()))
+					return retVal
+				}
+				matching, rest := make([]Value, 0), make([]Value, 0)
+				for _, v := range listVal.values {
+					result := callOperator(env, operands[0].Val, []Value{v})
+					if result.Err != nil {
+						return result
+					}
+					if isTruthy(result.Val) {
+						matching = append(matching, v)
+					} else {
+						rest = append(rest, v)
+					}
+				}
+				retVal.Val = newMultipleValues([]Value{newListValue(matching), newListValue(rest)})
+				return retVal
+			},
+		},
+	)
+
+	// Returns the list without the elements which are equal? to an earlier
+	// element.
+	addOperator(opMap,
+		&Operator{
+			symbol:      distinct,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[0].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						distinct, operands[0].Val.Str()))
+					return retVal
+				}
+				// Values have the same hash key when they are equal?, so a set of the
+				// keys avoids comparing every pair of elements.
+				seen := make(map[string]bool)
+				unique := make([]Value, 0)
+				for _, v := range listVal.values {
+					k := hashKey(v)
+					if !seen[k] {
+						seen[k] = true
+						unique = append(unique, v)
+					}
+				}
+				retVal.Val = newListValue(unique)
+				return retVal
+			},
+		},
+	)
+
+	// Returns a deep copy of the value. None of the values can be changed in
+	// place yet, but a copy is never identical to the original, like for assq.
+	addOperator(opMap,
+		&Operator{
+			symbol:      copyOp,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = deepCopy(operands[0].Val)
+				return retVal
+			},
+		},
+	)
+
+	// Prepends the value to the list in O(1), sharing the list with the result.
+	addOperator(opMap,
+		&Operator{
+			symbol:         cons,
+			minArgCount:    2,
+			maxArgCount:    2,
+			keepsConsCells: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				switch operands[1].Val.(type) {
+				case listValue, consValue:
+				default:
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						cons, operands[1].Val.Str()))
+					return retVal
+				}
+				retVal.Val = newConsValue(operands[0].Val, operands[1].Val)
+				return retVal
+			},
+		},
+	)
+
+	// Handlers for car and cdr, which return the first element of a non-empty
+	// list, and the list of the remaining ones respectively. Neither copies the
+	// list.
+	firstOrRest := func(symbol string, first bool) func(*LangEnv, []Atom) Atom {
+		return func(env *LangEnv, operands []Atom) Atom {
+			var retVal Atom
+			switch v := operands[0].Val.(type) {
+			case consValue:
+				retVal.Val = v.cell.tail
+				if first {
+					retVal.Val = v.cell.head
+				}
+				return retVal
+			case listValue:
+				if len(v.values) > 0 {
+					retVal.Val = newListValue(v.values[1:])
+					if first {
+						retVal.Val = v.values[0]
+					}
+					return retVal
+				}
+			}
+			retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a non-empty list",
+				symbol, operands[0].Val.Str()))
+			return retVal
+		}
+	}
+
+	addOperator(opMap,
+		&Operator{
+			symbol:         car,
+			minArgCount:    1,
+			maxArgCount:    1,
+			keepsConsCells: true,
+			handler:        firstOrRest(car, true),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:         cdr,
+			minArgCount:    1,
+			maxArgCount:    1,
+			keepsConsCells: true,
+			handler:        firstOrRest(cdr, false),
+		},
+	)
+
+	// Returns the position of the target in the string or the list, like
+	// (index-of "banana" "an"), which is 1. The search can be started at a
+	// later position, to find the following occurrences.
+	addOperator(opMap,
+		&Operator{
+			symbol:      indexOf,
+			minArgCount: 2,
+			maxArgCount: 3,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				start := 0
+				if len(operands) == 3 {
+					n, ok := operands[2].Val.(intValue)
+					if !ok || n.value < 0 {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative integer",
+							indexOf, operands[2].Val.Str()))
+						return retVal
+					}
+					start = int(n.value)
+				}
+				i, err := indexIn(indexOf, operands[0].Val, operands[1].Val, start)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				var val intValue
+				val.value = int64(i)
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+}
@@ -0,0 +1,296 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	threadFirst string = "->"
+	threadLast  string = "->>"
+	macroexpand string = "macroexpand"
+	astSize     string = "ast-size"
+	astDepth    string = "ast-depth"
+	astWalk     string = "ast-walk"
+	symbolOp    string = "symbol"
+	isSymbol    string = "symbol?"
+)
+
+func newListNode(children []*ASTNode) *ASTNode {
+	node := new(ASTNode)
+	node.isValue = false
+	node.children = children
+	return node
+}
+
+func newValueNode(value string) *ASTNode {
+	node := new(ASTNode)
+	node.isValue = true
+	node.value = value
+	return node
+}
+
+// Returns an expander which threads the first argument through the rest of
+// the expressions. Each expression gets the result of the previous one as its
+// first argument, or as its last one if threadAsLast is set. A bare symbol f
+// is treated like (f).
+func threadExpander(threadAsLast bool) func(*ASTNode) (*ASTNode, error) {
+	return func(node *ASTNode) (*ASTNode, error) {
+		acc := node.children[1]
+		for _, step := range node.children[2:] {
+			if step.isValue {
+				acc = newListNode([]*ASTNode{step, acc})
+				continue
+			}
+			if len(step.children) == 0 {
+				return nil, errStr("an expression to thread through", "()")
+			}
+			children := make([]*ASTNode, 0, len(step.children)+1)
+			if threadAsLast {
+				children = append(children, step.children...)
+				children = append(children, acc)
+			} else {
+				children = append(children, step.children[0], acc)
+				children = append(children, step.children[1:]...)
+			}
+			acc = newListNode(children)
+		}
+		return acc, nil
+	}
+}
+
+// Returns the number of nodes in the AST, counting both lists and values.
+func countASTNodes(node *ASTNode) int64 {
+	count := int64(1)
+	for _, child := range node.children {
+		count += countASTNodes(child)
+	}
+	return count
+}
+
+// Returns how deeply lists are nested in the AST. A value has a depth of 0, and
+// a list is one deeper than its deepest element.
+func astNestingDepth(node *ASTNode) int64 {
+	if node.isValue {
+		return 0
+	}
+	var depth int64
+	for _, child := range node.children {
+		if d := astNestingDepth(child); d > depth {
+			depth = d
+		}
+	}
+	return depth + 1
+}
+
+// Returns the value of the token, without evaluating it. Names are returned as
+// symbols, rather than being looked up.
+func literalValue(env *LangEnv, token string) Value {
+	v, err := getValue(env, token)
+	if err != nil || v.getValueType() == varType {
+		return symbolValue{token}
+	}
+	return v
+}
+
+// Returns the value which the AST represents, without evaluating it. Lists
+// are returned as lists, and names as symbols.
+func astToValue(env *LangEnv, node *ASTNode) Value {
+	if node.isValue {
+		return literalValue(env, node.value)
+	}
+	values := make([]Value, len(node.children))
+	for i, child := range node.children {
+		values[i] = astToValue(env, child)
+	}
+	return newListValue(values)
+}
+
+// Rebuilds the AST as a value, from the bottom up, replacing every node with the
+// result of calling fn on it. Literals are passed as their values, names as
+// symbols, and lists as lists of the nodes they have been replaced with.
+func walkAST(env *LangEnv, node *ASTNode, fn Value) Atom {
+	var nodeVal Value
+	if node.isValue {
+		nodeVal = literalValue(env, node.value)
+	} else {
+		values := make([]Value, len(node.children))
+		for i, child := range node.children {
+			result := walkAST(env, child, fn)
+			if result.Err != nil {
+				return result
+			}
+			values[i] = result.Val
+		}
+		nodeVal = newListValue(values)
+	}
+	result := callOperator(env, fn, []Value{nodeVal})
+	if result.Err == nil && result.Val != nil && result.Val.getValueType() == varType {
+		result.Val, result.Err = getVarValue(env, result.Val)
+	}
+	return result
+}
+
+// Expands the expression until it is no longer a macro call.
+func expandMacros(env *LangEnv, node *ASTNode) (*ASTNode, error) {
+	for !node.isValue && len(node.children) > 0 && node.children[0].isValue {
+		op := env.getOperator(node.children[0].value)
+		if op == nil || op.expander == nil {
+			break
+		}
+		var err error
+		node, err = op.expander(node)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return node, nil
+}
+
+func addMacroOperators(opMap map[string]*Operator) {
+	addOperator(opMap,
+		&Operator{
+			symbol:      threadFirst,
+			minArgCount: 1,
+			maxArgCount: 100,
+			expander:    threadExpander(false),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      threadLast,
+			minArgCount: 1,
+			maxArgCount: 100,
+			expander:    threadExpander(true),
+		},
+	)
+
+	// Evaluates an expression written in infix notation, with the usual
+	// precedence. Lists within it are parenthesized infix expressions, unless
+	// they are method calls like (f x).
+	addOperator(opMap,
+		&Operator{
+			symbol:      infix,
+			minArgCount: 1,
+			maxArgCount: 100,
+			expander:    infixExpander,
+		},
+	)
+
+	// Returns the expansion of a macro call as a string, without evaluating it.
+	addOperator(opMap,
+		&Operator{
+			symbol:      macroexpand,
+			minArgCount: 1,
+			maxArgCount: 1,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				expanded, err := expandMacros(env, astVal.astNodes[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newStringValue(StringifyAST(expanded))
+				return retVal
+			},
+		},
+	)
+
+	// Handlers for ast-size and ast-depth, which measure the expression without
+	// evaluating it.
+	measureAST := func(measure func(*ASTNode) int64) func(*LangEnv, []Atom) Atom {
+		return func(env *LangEnv, operands []Atom) Atom {
+			var retVal Atom
+			astVal, _ := operands[0].Val.(astValue)
+			var val intValue
+			val.value = measure(astVal.astNodes[0])
+			retVal.Val = val
+			return retVal
+		}
+	}
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      astSize,
+			minArgCount: 1,
+			maxArgCount: 1,
+			passRawAST:  true,
+			handler:     measureAST(countASTNodes),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      astDepth,
+			minArgCount: 1,
+			maxArgCount: 1,
+			passRawAST:  true,
+			handler:     measureAST(astNestingDepth),
+		},
+	)
+
+	// Rewrites an expression without evaluating it, like
+	// (ast-walk (+ x 1) (lambda (n) (if (equal? n (symbol "x")) 2 n))), which
+	// results in (+ 2 1).
+	addOperator(opMap,
+		&Operator{
+			symbol:      astWalk,
+			minArgCount: 2,
+			maxArgCount: 2,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				fn := evalASTHelper(env, astVal.astNodes[1])
+				if fn.Err != nil {
+					return fn
+				}
+				if resolveOperator(env, fn.Val) == nil {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator",
+						astWalk, fn.Val.Str()))
+					return retVal
+				}
+				return walkAST(env, astVal.astNodes[0], fn.Val)
+			},
+		},
+	)
+
+	// Returns the symbol with the given name, to compare with the symbols in
+	// an expression.
+	addOperator(opMap,
+		&Operator{
+			symbol:      symbolOp,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				str, ok := operands[0].Val.(stringValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected a string, got %s",
+						symbolOp, operands[0].Val.Str()))
+					return retVal
+				}
+				retVal.Val = symbolValue{str.contents()}
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      isSymbol,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				var val boolValue
+				val.value = operands[0].Val.getValueType() == symbolType
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+}
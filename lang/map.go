@@ -0,0 +1,118 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	get         string = "get"
+	groupBy     string = "group-by"
+	frequencies string = "frequencies"
+)
+
+func addMapOperators(opMap map[string]*Operator) {
+	// Returns the value for the key in the map, or the default if the map does
+	// not have the key. The default is nil, if not passed.
+	addOperator(opMap,
+		&Operator{
+			symbol:      get,
+			minArgCount: 2,
+			maxArgCount: 3,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				mapVal, ok := operands[0].Val.(mapValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a map",
+						get, operands[0].Val.Str()))
+					return retVal
+				}
+				val, found := mapVal.get(operands[1].Val)
+				switch {
+				case found:
+					retVal.Val = val
+				case len(operands) > 2:
+					retVal.Val = operands[2].Val
+				default:
+					retVal.Val = newNilValue()
+				}
+				return retVal
+			},
+		},
+	)
+
+	// Returns a map from the result of the key function for each element of the
+	// list, to the list of elements with that key. Both the keys and the
+	// elements are in the order in which they first appear in the list.
+	addOperator(opMap,
+		&Operator{
+			symbol:      groupBy,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[1].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						groupBy, operands[1].Val.Str()))
+					return retVal
+				}
+
+				keys := make([]Value, 0)
+				groups := make(map[string][]Value)
+				for _, v := range listVal.values {
+					result := callOperator(env, operands[0].Val, []Value{v})
+					if result.Err == nil {
+						result.Err = checkSingleValue(groupBy, result.Val)
+					}
+					if result.Err != nil {
+						result.Val = nil
+						return result
+					}
+					k := hashKey(result.Val)
+					if _, ok := groups[k]; !ok {
+						keys = append(keys, result.Val)
+					}
+					groups[k] = append(groups[k], v)
+				}
+
+				grouped := newMapValue()
+				for _, k := range keys {
+					grouped.put(k, newListValue(groups[hashKey(k)]))
+				}
+				retVal.Val = grouped
+				return retVal
+			},
+		},
+	)
+
+	// Returns a map from every distinct element of the list to the number of
+	// times it occurs in it.
+	addOperator(opMap,
+		&Operator{
+			symbol:      frequencies,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[0].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						frequencies, operands[0].Val.Str()))
+					return retVal
+				}
+				counts := newMapValue()
+				for _, v := range listVal.values {
+					var count intValue
+					if prev, found := counts.get(v); found {
+						count, _ = prev.(intValue)
+					}
+					count.value++
+					counts.put(v, count)
+				}
+				retVal.Val = counts
+				return retVal
+			},
+		},
+	)
+}
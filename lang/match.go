@@ -0,0 +1,150 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	match string = "match"
+	// Matches any value, without binding it.
+	wildcard string = "_"
+)
+
+// Returns whether val matches the pattern, binding the variables in the
+// pattern in env if it does. A pattern is either:
+//  1. _, which matches anything.
+//  2. A variable name, which matches anything and is bound to it.
+//  3. A literal, like 1 or "a", which matches the values equal? to it. The
+//     name of a variant of an enum, like red after (defenum color red), is a
+//     literal for the variant, as long as it is bound to it.
+//  4. (name p1 p2 ...), which matches a record of the struct name, whose fields
+//     match the patterns in the order they were declared.
+//  5. (list p1 p2 ...), or simply (p1 p2 ...), which matches a list of the same
+//     length whose elements match the patterns. Like in let, the last pattern
+//     can be preceded by a ., to match the rest of the list.
+func matchPattern(env *LangEnv, pattern *ASTNode, val Value) (bool, error) {
+	if pattern.isValue {
+		if pattern.value == wildcard {
+			return true, nil
+		}
+		literal, err := getValue(env, pattern.value)
+		if err != nil {
+			return false, errors.New(fmt.Sprintf("Invalid pattern %s in %s", pattern.value, match))
+		}
+		if literal.getValueType() != varType {
+			return isEqual(literal, val), nil
+		}
+		if variant, ok := env.getValue(pattern.value).(enumValue); ok && variant.name == pattern.value {
+			return isEqual(variant, val), nil
+		}
+		if err := checkNotSpecialForm(pattern.value); err != nil {
+			return false, err
+		}
+		bindParam(env, env, pattern.value, val)
+		return true, nil
+	}
+
+	patterns := pattern.children
+	if rec, ok := val.(recordValue); ok {
+		if len(patterns) == 0 || !patterns[0].isValue || patterns[0].value != rec.name ||
+			len(patterns)-1 != len(rec.values) {
+			return false, nil
+		}
+		return matchPatterns(env, patterns[1:], rec.values)
+	}
+
+	listVal, ok := consToList(val).(listValue)
+	if !ok {
+		return false, nil
+	}
+	if len(patterns) > 0 && patterns[0].isValue && patterns[0].value == list {
+		patterns = patterns[1:]
+	}
+	var restPattern *ASTNode
+	if n := len(patterns); n >= 2 && patterns[n-2].isValue && patterns[n-2].value == restMarker {
+		patterns, restPattern = patterns[:n-2], patterns[n-1]
+	}
+	if len(listVal.values) < len(patterns) || (restPattern == nil && len(listVal.values) != len(patterns)) {
+		return false, nil
+	}
+	matched, err := matchPatterns(env, patterns, listVal.values[:len(patterns)])
+	if !matched || err != nil || restPattern == nil {
+		return matched, err
+	}
+	rest := make([]Value, len(listVal.values)-len(patterns))
+	copy(rest, listVal.values[len(patterns):])
+	return matchPattern(env, restPattern, newListValue(rest))
+}
+
+// Splits a pattern of the form `(pattern :when guard)` into the pattern and the
+// guard. The guard is nil if there is none.
+func splitGuard(pattern *ASTNode) (*ASTNode, *ASTNode) {
+	if !pattern.isValue && len(pattern.children) == 3 &&
+		pattern.children[1].isValue && pattern.children[1].value == whenGuard {
+		return pattern.children[0], pattern.children[2]
+	}
+	return pattern, nil
+}
+
+// Matches the values against the patterns, pairwise.
+func matchPatterns(env *LangEnv, patterns []*ASTNode, values []Value) (bool, error) {
+	for i, p := range patterns {
+		matched, err := matchPattern(env, p, values[i])
+		if !matched || err != nil {
+			return matched, err
+		}
+	}
+	return true, nil
+}
+
+func addMatchOperators(opMap map[string]*Operator) {
+	// Evaluates the body of the first clause whose pattern matches the value,
+	// like (match x ((list a b) (+ a b)) (_ 0)). Each clause is of the form
+	// `(pattern body...)`, and its body is evaluated with the variables bound
+	// by the pattern in scope. The pattern can be followed by a guard, like
+	// ((n :when (> n 0)) "positive"), in which case the clause only matches if
+	// the guard, which can refer to the bindings, is truthy.
+	addOperator(opMap,
+		&Operator{
+			symbol:      match,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				result := evalASTHelper(env, astVal.astNodes[0])
+				if result.Err != nil {
+					return result
+				}
+				for _, clause := range astVal.astNodes[1:] {
+					if clause.isValue || len(clause.children) < 2 {
+						retVal.Err = errors.New(fmt.Sprintf(
+							"Clauses for %s should be of the format `(pattern body...)`.", match))
+						return retVal
+					}
+					clauseEnv := env.newChildEnv()
+					pattern, guard := splitGuard(clause.children[0])
+					matched, err := matchPattern(clauseEnv, pattern, result.Val)
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					if matched && guard != nil {
+						guardVal := evalASTHelper(clauseEnv, guard)
+						if guardVal.Err != nil {
+							return guardVal
+						}
+						matched = isTruthy(guardVal.Val)
+					}
+					if matched {
+						return evalASTs(clauseEnv, clause.children[1:])
+					}
+				}
+				retVal.Err = errors.New(fmt.Sprintf("No clause of %s matches %s", match, result.Val.Str()))
+				return retVal
+			},
+		},
+	)
+}
@@ -0,0 +1,193 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Each call takes up some of the Go stack, which is limited to 1GB, and more
+// the more deeply its body is nested. This leaves room for bodies several times
+// as nested as those of typical methods.
+const defaultMaxRecursionDepth = 10000
+
+// The start of the message of the error raised by a call which is nested too
+// deeply, so that it can be told apart from other errors.
+const stackOverflow string = "stack-overflow"
+
+// Binds a single argument to the parameter p in the method's environment.
+func bindParam(env, newEnv *LangEnv, p string, val Value) {
+	// Check here whether the argument is a variable / operator.
+	if val.getValueType() != varType {
+		newEnv.setValue(p, val)
+	} else if op := env.getOperator(val.Str()); op != nil {
+		newEnv.setOperator(p, op)
+	} else {
+		newEnv.setValue(p, val)
+	}
+}
+
+// Binds the arguments of a method call to the parameters of the method.
+// Arguments can either be passed positionally, or as keyword arguments of the
+// form `:param value`, where :param is written as a keyword in the call. Other
+// keywords, like the values of variables, or a keyword which is not followed by
+// a value or does not name a parameter, are positional arguments. Positional
+// arguments have to precede the keyword arguments, and every parameter can be
+// bound only once. Parameters which were not passed get their default value,
+// which is evaluated in the method's environment. Only the parameters with
+// defaults can be left out.
+func (m *method) bindArgs(env, newEnv *LangEnv, operands []Atom) error {
+	bound := make(map[string]bool)
+	posCount := 0
+	for posCount < len(operands) && !m.startsKeywordArgs(operands, posCount) {
+		posCount++
+	}
+
+	requiredCount := len(m.params) - len(m.defaults)
+	if posCount > len(m.params) {
+		return errors.New(
+			fmt.Sprintf("Received %d arguments for operator %s, maximum expected arguments: %d",
+				posCount, m.methodName, len(m.params)))
+	}
+	if posCount == len(operands) && posCount < requiredCount {
+		if requiredCount == len(m.params) {
+			return errors.New(
+				fmt.Sprintf("Received %d arguments for operator %s, expected: %d",
+					posCount, m.methodName, len(m.params)))
+		}
+		return errors.New(
+			fmt.Sprintf("Received %d arguments for operator %s, minimum expected arguments: %d",
+				posCount, m.methodName, requiredCount))
+	}
+	for i := 0; i < posCount; i++ {
+		if err := m.bindParam(env, newEnv, m.params[i], operands[i].Val); err != nil {
+			return err
+		}
+		bound[m.params[i]] = true
+	}
+
+	for i := posCount; i < len(operands); i += 2 {
+		kw, ok := operands[i].Val.(keywordValue)
+		if !ok || !operands[i].keywordArg {
+			return errors.New(fmt.Sprintf("Positional argument %s found after keyword arguments for method %s.",
+				operands[i].Val.Str(), m.methodName))
+		}
+		if i+1 == len(operands) {
+			return errors.New(fmt.Sprintf("Missing value for keyword argument %s for method %s.",
+				kw.Str(), m.methodName))
+		}
+		if !m.hasParam(kw.name) {
+			return errors.New(fmt.Sprintf("Unknown keyword argument %s for method %s.",
+				kw.Str(), m.methodName))
+		}
+		if bound[kw.name] {
+			return errors.New(fmt.Sprintf("Parameter %s of method %s was passed more than once.",
+				kw.name, m.methodName))
+		}
+		bindParam(env, newEnv, kw.name, operands[i+1].Val)
+		bound[kw.name] = true
+	}
+
+	// Defaults are evaluated in order, so they can refer to earlier parameters.
+	for _, p := range m.params {
+		if bound[p] {
+			continue
+		}
+		defaultNode, ok := m.defaults[p]
+		if !ok {
+			return errors.New(fmt.Sprintf("Missing value for parameter %s of method %s.", p, m.methodName))
+		}
+		defaultVal := evalASTHelper(newEnv, defaultNode)
+		if defaultVal.Err != nil {
+			return defaultVal.Err
+		}
+		bindParam(newEnv, newEnv, p, defaultVal.Val)
+	}
+	return nil
+}
+
+// Binds the argument to the parameter p, destructuring it if p is a pattern.
+func (m *method) bindParam(env, newEnv *LangEnv, p string, val Value) error {
+	if pattern, ok := m.patterns[p]; ok {
+		return bindPattern(newEnv, pattern, val)
+	}
+	bindParam(env, newEnv, p, val)
+	return nil
+}
+
+// Returns whether the operand at i is the first keyword argument.
+func (m *method) startsKeywordArgs(operands []Atom, i int) bool {
+	kw, ok := operands[i].Val.(keywordValue)
+	return ok && operands[i].keywordArg && i+1 < len(operands) && m.hasParam(kw.name)
+}
+
+func (m *method) hasParam(name string) bool {
+	for _, p := range m.params {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Invokes the method with the given arguments, in a call written at pos.
+func (m *method) call(env *LangEnv, operands []Atom, pos position) Atom {
+	var retVal Atom
+	// We will favor formal arguments over previously defined variables.
+	scope := env
+	if m.env != nil {
+		scope = m.env
+	}
+	newEnv := scope.newChildEnv()
+	newEnv.takeCallerState(env)
+	retVal.Err = m.bindArgs(env, newEnv, operands)
+	if retVal.Err != nil {
+		return retVal
+	}
+
+	newEnv.recursionDepth = env.recursionDepth + 1
+	if newEnv.recursionDepth > env.maxRecursionDepth {
+		retVal.Err = errors.New(fmt.Sprintf("%s: reached the recursion limit of %d calls in %s",
+			stackOverflow, env.maxRecursionDepth, m.methodName))
+		return retVal
+	}
+
+	newEnv.frame = &stackFrame{m.methodName, operands, env.frame, pos}
+
+	if !env.tracer.tracedMethods[m.methodName] {
+		return m.eval(env, scope, newEnv)
+	}
+	env.tracer.enter(env, newEnv.frame.describe())
+	retVal = m.eval(env, scope, newEnv)
+	env.tracer.exit(env, retVal)
+	return retVal
+}
+
+// Evaluates the body of the method in newEnv. Every time the body results in
+// a recur, it is evaluated again with the new arguments, in a fresh child of
+// scope. Errors raised by the body get the stack trace of the call.
+func (m *method) eval(env, scope, newEnv *LangEnv) Atom {
+	for {
+		retVal := evalASTHelper(newEnv, m.ast)
+		if retVal.Err != nil {
+			retVal.Err = withStackTrace(retVal.Err, newEnv.frame)
+			return retVal
+		}
+		recurVal, ok := retVal.Val.(recurValue)
+		if !ok {
+			return retVal
+		}
+		depth, frame := newEnv.recursionDepth, newEnv.frame
+		newEnv = scope.newChildEnv()
+		newEnv.takeCallerState(env)
+		newEnv.recursionDepth = depth
+		operands := make([]Atom, len(recurVal.args))
+		for i, arg := range recurVal.args {
+			operands[i].Val = arg
+		}
+		newEnv.frame = &stackFrame{frame.name, operands, frame.parent, frame.pos}
+		if retVal.Err = m.bindArgs(env, newEnv, operands); retVal.Err != nil {
+			retVal.Val = nil
+			return retVal
+		}
+	}
+}
@@ -0,0 +1,866 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	factorial    string = "factorial"
+	choose       string = "choose"
+	permutations string = "permutations"
+	isPrime      string = "prime?"
+	nextPrime    string = "next-prime"
+	factorize    string = "factorize"
+	wrapAdd32    string = "wrap-add32"
+	wrapMul32    string = "wrap-mul32"
+	wrapAnd32    string = "wrap-and32"
+	isUint32     string = "uint32?"
+	groupDigits  string = "group-digits"
+	setPolicy    string = "set-numeric-policy"
+	floatBits    string = "float-bits"
+	ulp          string = "ulp"
+	clamp        string = "clamp"
+	lerp         string = "lerp"
+	mapRange     string = "map-range"
+	sum          string = "sum"
+	product      string = "product"
+	mean         string = "mean"
+	median       string = "median"
+	stddev       string = "stddev"
+	// Makes stddev compute the standard deviation of a sample.
+	sampleFlag string = "sample"
+)
+
+// Controls what integer arithmetic results in when it does not fit an integer.
+// The zero value is the default policy, under which overflowing results are
+// promoted to big ints, and integer division truncates.
+type numericPolicy struct {
+	// Whether overflowing results are errors, rather than big ints.
+	strictOverflow bool
+	// Whether dividing integers results in a float, rather than truncating.
+	floatDivision bool
+}
+
+// The keywords naming the policies, which are passed to set-numeric-policy.
+const (
+	promotePolicy  string = "promote"
+	strictPolicy   string = "strict"
+	truncatePolicy string = "truncate"
+	floatPolicy    string = "float"
+)
+
+// Returns the keywords describing the policy, like (:promote :truncate).
+func (p numericPolicy) keywords() Value {
+	overflow, division := promotePolicy, truncatePolicy
+	if p.strictOverflow {
+		overflow = strictPolicy
+	}
+	if p.floatDivision {
+		division = floatPolicy
+	}
+	return newListValue([]Value{keywordValue{overflow}, keywordValue{division}})
+}
+
+// Returns the numeric policy, which can be changed by other tasks.
+func (e *LangEnv) policy() numericPolicy {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return *e.numericPolicy
+}
+
+// Returns an error if the numeric policy of env does not allow the result of
+// symbol to be promoted to a big int, because it has overflowed.
+func checkPromotion(env *LangEnv, symbol string) error {
+	if env.policy().strictOverflow {
+		return errors.New(fmt.Sprintf("For %s, the result overflows an integer, and the numeric policy is :%s",
+			symbol, strictPolicy))
+	}
+	return nil
+}
+
+// The number of rounds of Miller-Rabin used to test for primality.
+// big.Int's ProbablyPrime is exact for numbers below 2^64 regardless.
+const primalityRounds = 20
+
+// Returns the operand as a big.Int, if it is an integer.
+func bigIntOperand(symbol string, operand Atom) (*big.Int, error) {
+	switch v := operand.Val.(type) {
+	case intValue:
+		return big.NewInt(v.value), nil
+	case bigIntValue:
+		return v.value, nil
+	}
+	return nil, errors.New(fmt.Sprintf("For %s, expected %s to be an integer", symbol, operand.Val.Str()))
+}
+
+// Returns the digits of the number in decimal notation, without an exponent.
+// Floats are written with the fewest digits which represent them exactly.
+func decimalDigits(symbol string, v Value) (string, error) {
+	switch val := v.(type) {
+	case intValue, bigIntValue:
+		return val.Str(), nil
+	case floatValue:
+		if math.IsNaN(val.value) || math.IsInf(val.value, 0) {
+			return "", errors.New(fmt.Sprintf("For %s, cannot group the digits of %s", symbol, val.Str()))
+		}
+		return strconv.FormatFloat(val.value, 'f', -1, 64), nil
+	case bigFloatValue:
+		if val.value.IsInf() {
+			return "", errors.New(fmt.Sprintf("For %s, cannot group the digits of %s", symbol, val.Str()))
+		}
+		return val.value.Text('f', -1), nil
+	}
+	return "", errors.New(fmt.Sprintf("For %s, expected %s to be a number", symbol, v.Str()))
+}
+
+// Inserts the separator between every group of three digits of the integer
+// part of the number, and replaces its decimal point with decimalMark.
+// Returns the operand as a float64, if it is a float or an int.
+func floatOperand(symbol string, operand Atom) (float64, error) {
+	switch operand.Val.getValueType() {
+	case intType, floatType:
+		v, err := operand.Val.to(floatType)
+		if err == nil {
+			return v.(floatValue).value, nil
+		}
+	}
+	return 0, errors.New(fmt.Sprintf("For %s, expected %s to be a float", symbol, operand.Val.Str()))
+}
+
+// Returns the operands as float64s, if they are all floats or ints.
+func floatOperands(symbol string, operands []Atom) ([]float64, error) {
+	floats := make([]float64, len(operands))
+	for i, o := range operands {
+		var err error
+		if floats[i], err = floatOperand(symbol, o); err != nil {
+			return nil, err
+		}
+	}
+	return floats, nil
+}
+
+// Returns the numbers in the list as float64s, for computing statistics. The
+// list cannot be empty.
+func statisticsOperand(symbol string, operand Atom) ([]float64, error) {
+	listVal, ok := operand.Val.(listValue)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("For %s, expected %s to be a list", symbol, operand.Val.Str()))
+	}
+	if len(listVal.values) == 0 {
+		return nil, errors.New(fmt.Sprintf("For %s, expected a list of numbers, got an empty list", symbol))
+	}
+	floats := make([]float64, len(listVal.values))
+	for i, elem := range listVal.values {
+		switch v := elem.(type) {
+		case intValue:
+			floats[i] = float64(v.value)
+		case floatValue:
+			floats[i] = v.value
+		case bigIntValue:
+			floats[i], _ = new(big.Float).SetInt(v.value).Float64()
+		case bigFloatValue:
+			floats[i], _ = v.value.Float64()
+		default:
+			return nil, errors.New(fmt.Sprintf("For %s, expected %s to be a number", symbol, elem.Str()))
+		}
+	}
+	return floats, nil
+}
+
+func meanOf(floats []float64) float64 {
+	var total float64
+	for _, f := range floats {
+		total += f
+	}
+	return total / float64(len(floats))
+}
+
+// Interpolates linearly between a and b, which are the results for t being 0
+// and 1. It is computed so that both of them are exact.
+func interpolateLinearly(a, b, t float64) float64 {
+	return (1-t)*a + t*b
+}
+
+// Returns -1, 0 or 1 as a is less than, equal to, or greater than b, which are
+// numbers of the same type. NaN is neither, and compares as equal.
+func compareNumbers(a, b Value) int {
+	switch x := a.(type) {
+	case intValue:
+		y := b.(intValue)
+		if x.value < y.value {
+			return -1
+		} else if x.value > y.value {
+			return 1
+		}
+	case bigIntValue:
+		return x.value.Cmp(b.(bigIntValue).value)
+	case floatValue:
+		y := b.(floatValue)
+		if x.value < y.value {
+			return -1
+		} else if x.value > y.value {
+			return 1
+		}
+	case bigFloatValue:
+		return x.value.Cmp(b.(bigFloatValue).value)
+	}
+	return 0
+}
+
+// Returns the distance from |x| to the next float further from zero, which is
+// how much a float of that magnitude can be off by due to rounding. The largest
+// float has no float after it, so the distance to the one before it is used.
+func unitInLastPlace(x float64) float64 {
+	x = math.Abs(x)
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return x
+	}
+	if x == math.MaxFloat64 {
+		return x - math.Nextafter(x, 0)
+	}
+	return math.Nextafter(x, math.Inf(1)) - x
+}
+
+func groupDecimalDigits(digits, separator, decimalMark string) string {
+	sign := ""
+	if strings.HasPrefix(digits, "-") {
+		sign, digits = "-", digits[1:]
+	}
+	intPart, fracPart := digits, ""
+	if i := strings.IndexByte(digits, '.'); i >= 0 {
+		intPart, fracPart = digits[:i], decimalMark+digits[i+1:]
+	}
+
+	var grouped strings.Builder
+	for i, d := range intPart {
+		if i > 0 && (len(intPart)-i)%3 == 0 {
+			grouped.WriteString(separator)
+		}
+		grouped.WriteRune(d)
+	}
+	return sign + grouped.String() + fracPart
+}
+
+// Returns a factor of the composite number n, other than 1 and n, using
+// Pollard's rho algorithm. Each attempt starts from a different point, as an
+// attempt can fail to find a factor.
+func findFactor(n *big.Int) *big.Int {
+	one := big.NewInt(1)
+	for c := int64(1); ; c++ {
+		x, y, d := big.NewInt(2), big.NewInt(2), big.NewInt(1)
+		step := func(v *big.Int) {
+			v.Mul(v, v)
+			v.Add(v, big.NewInt(c))
+			v.Mod(v, n)
+		}
+		diff := new(big.Int)
+		for d.Cmp(one) == 0 {
+			step(x)
+			step(y)
+			step(y)
+			diff.Sub(x, y)
+			d.GCD(nil, nil, diff.Abs(diff), n)
+		}
+		if d.Cmp(n) != 0 {
+			return d
+		}
+	}
+}
+
+// Appends the prime factors of n, which is greater than 1, to factors.
+func primeFactors(n *big.Int, factors []*big.Int) []*big.Int {
+	if n.ProbablyPrime(primalityRounds) {
+		return append(factors, n)
+	}
+	d := findFactor(n)
+	factors = primeFactors(d, factors)
+	return primeFactors(new(big.Int).Quo(n, d), factors)
+}
+
+// Returns the operands as non-negative integers.
+func naturalOperands(symbol string, operands []Atom) ([]int64, error) {
+	nums := make([]int64, len(operands))
+	for i, o := range operands {
+		v, ok := o.Val.(intValue)
+		if !ok || v.value < 0 {
+			return nil, errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative integer",
+				symbol, o.Val.Str()))
+		}
+		nums[i] = v.value
+	}
+	return nums, nil
+}
+
+// Returns the handler for an operator on non-negative integers, whose result is
+// computed as a big.Int.
+func naturalHandler(symbol string, compute func(nums []int64) *big.Int) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		nums, err := naturalOperands(symbol, operands)
+		if err != nil {
+			retVal.Err = err
+			return retVal
+		}
+		retVal.Val = newIntegerValue(compute(nums))
+		return retVal
+	}
+}
+
+// Returns the handler for an operator on integers modulo 2^32, which combines
+// the operands from left to right. The operands are truncated to their lowest
+// 32 bits, and the result is between 0 and 2^32 - 1.
+func wrap32Handler(symbol string, combine func(a, b uint32) uint32) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		var result uint32
+		for i, o := range operands {
+			v, ok := o.Val.(intValue)
+			if !ok {
+				retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be an integer", symbol, o.Val.Str()))
+				return retVal
+			}
+			if i == 0 {
+				result = uint32(v.value)
+			} else {
+				result = combine(result, uint32(v.value))
+			}
+		}
+		var val intValue
+		val.value = int64(result)
+		retVal.Val = val
+		return retVal
+	}
+}
+
+func addNumberOperators(opMap map[string]*Operator) {
+	// Sets how integer arithmetic behaves in the whole interpreter, also when it
+	// is called from within a method, like (set-numeric-policy :strict :float).
+	// Overflowing results are promoted to big ints under :promote, and are
+	// errors under :strict. Integer division truncates under :truncate, and
+	// results in a float under :float. Whatever is not passed is left as it was,
+	// and the resulting policy is returned.
+	addOperator(opMap,
+		&Operator{
+			symbol:      setPolicy,
+			minArgCount: 1,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				env.mu.Lock()
+				defer env.mu.Unlock()
+				policy := *env.numericPolicy
+				for _, o := range operands {
+					kw, _ := o.Val.(keywordValue)
+					switch {
+					case o.Val.getValueType() != keywordType:
+					case kw.name == promotePolicy || kw.name == strictPolicy:
+						policy.strictOverflow = kw.name == strictPolicy
+						continue
+					case kw.name == truncatePolicy || kw.name == floatPolicy:
+						policy.floatDivision = kw.name == floatPolicy
+						continue
+					}
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be one of :%s, :%s, :%s or :%s",
+						setPolicy, o.Val.Str(), promotePolicy, strictPolicy, truncatePolicy, floatPolicy))
+					return retVal
+				}
+				*env.numericPolicy = policy
+				retVal.Val = policy.keywords()
+				return retVal
+			},
+		},
+	)
+
+	// Multiplies the numbers from 1 to n. big.Int's MulRange splits the range in
+	// halves, which is faster than multiplying them one by one.
+	addOperator(opMap,
+		&Operator{
+			symbol:      factorial,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: naturalHandler(factorial, func(nums []int64) *big.Int {
+				return new(big.Int).MulRange(1, nums[0])
+			}),
+		},
+	)
+
+	// Returns the number of ways to choose k out of n elements, regardless of
+	// their order.
+	addOperator(opMap,
+		&Operator{
+			symbol:      choose,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: naturalHandler(choose, func(nums []int64) *big.Int {
+				if nums[1] > nums[0] {
+					return new(big.Int)
+				}
+				return new(big.Int).Binomial(nums[0], nums[1])
+			}),
+		},
+	)
+
+	// Returns the number of ordered arrangements of k out of n elements.
+	addOperator(opMap,
+		&Operator{
+			symbol:      permutations,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: naturalHandler(permutations, func(nums []int64) *big.Int {
+				if nums[1] > nums[0] {
+					return new(big.Int)
+				}
+				return new(big.Int).MulRange(nums[0]-nums[1]+1, nums[0])
+			}),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      isPrime,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				n, err := bigIntOperand(isPrime, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newBoolValue(n.Sign() > 0 && n.ProbablyPrime(primalityRounds))
+				return retVal
+			},
+		},
+	)
+
+	// Returns the smallest prime greater than n.
+	addOperator(opMap,
+		&Operator{
+			symbol:      nextPrime,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				n, err := bigIntOperand(nextPrime, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				candidate := new(big.Int).Add(n, big.NewInt(1))
+				if candidate.Cmp(big.NewInt(2)) < 0 {
+					candidate.SetInt64(2)
+				}
+				for !candidate.ProbablyPrime(primalityRounds) {
+					candidate.Add(candidate, big.NewInt(1))
+				}
+				retVal.Val = newIntegerValue(candidate)
+				return retVal
+			},
+		},
+	)
+
+	// Returns the prime factors of a positive integer in ascending order, with
+	// every factor repeated as often as it divides the integer.
+	addOperator(opMap,
+		&Operator{
+			symbol:      factorize,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				n, err := bigIntOperand(factorize, operands[0])
+				if err == nil && n.Sign() <= 0 {
+					err = errors.New(fmt.Sprintf("For %s, expected %s to be a positive integer",
+						factorize, operands[0].Val.Str()))
+				}
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+
+				factors := make([]*big.Int, 0)
+				rest := new(big.Int).Set(n)
+				// Small factors are found faster by trial division.
+				for p := int64(2); p < 1000 && rest.Cmp(big.NewInt(1)) > 0; p++ {
+					divisor := big.NewInt(p)
+					for new(big.Int).Mod(rest, divisor).Sign() == 0 {
+						factors = append(factors, divisor)
+						rest.Quo(rest, divisor)
+					}
+				}
+				if rest.Cmp(big.NewInt(1)) > 0 {
+					factors = primeFactors(rest, factors)
+				}
+				sort.Slice(factors, func(i, j int) bool {
+					return factors[i].Cmp(factors[j]) < 0
+				})
+
+				values := make([]Value, len(factors))
+				for i, f := range factors {
+					values[i] = newIntegerValue(f)
+				}
+				retVal.Val = newListValue(values)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      wrapAdd32,
+			minArgCount: 2,
+			maxArgCount: 100,
+			handler:     wrap32Handler(wrapAdd32, func(a, b uint32) uint32 { return a + b }),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      wrapMul32,
+			minArgCount: 2,
+			maxArgCount: 100,
+			handler:     wrap32Handler(wrapMul32, func(a, b uint32) uint32 { return a * b }),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      wrapAnd32,
+			minArgCount: 2,
+			maxArgCount: 100,
+			handler:     wrap32Handler(wrapAnd32, func(a, b uint32) uint32 { return a & b }),
+		},
+	)
+
+	// Returns whether the value is an integer between 0 and 2^32 - 1, like the
+	// results of the wrapping operators.
+	addOperator(opMap,
+		&Operator{
+			symbol:      isUint32,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				v, ok := operands[0].Val.(intValue)
+				retVal.Val = newBoolValue(ok && v.value >= 0 && v.value <= math.MaxUint32)
+				return retVal
+			},
+		},
+	)
+
+	// Returns the number as a string with its digits grouped in thousands, like
+	// (group-digits 1234567.5), which results in "1,234,567.5". The separator
+	// and the decimal mark can be passed, like (group-digits 1234.5 "." ",").
+	addOperator(opMap,
+		&Operator{
+			symbol:      groupDigits,
+			minArgCount: 1,
+			maxArgCount: 3,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				digits, err := decimalDigits(groupDigits, operands[0].Val)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				marks := []string{",", "."}
+				for i, o := range operands[1:] {
+					if marks[i], err = stringOperand(groupDigits, o); err != nil {
+						retVal.Err = err
+						return retVal
+					}
+				}
+				retVal.Val = newStringValue(groupDecimalDigits(digits, marks[0], marks[1]))
+				return retVal
+			},
+		},
+	)
+
+	// Returns the fields of the IEEE 754 representation of the float, as the
+	// list (sign exponent mantissa). The sign is 0 or 1, and the exponent and
+	// the mantissa are the raw bits, so 1.0 is (0 1023 0). The exponent is
+	// biased by 1023, and the mantissa does not include the implicit leading 1.
+	addOperator(opMap,
+		&Operator{
+			symbol:      floatBits,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				f, err := floatOperand(floatBits, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				bits := math.Float64bits(f)
+				fields := []uint64{bits >> 63, (bits >> 52) & (1<<11 - 1), bits & (1<<52 - 1)}
+				values := make([]Value, len(fields))
+				for i, field := range fields {
+					var val intValue
+					val.value = int64(field)
+					values[i] = val
+				}
+				retVal.Val = newListValue(values)
+				return retVal
+			},
+		},
+	)
+
+	// Returns the unit in the last place of the float, like (ulp 1.0), which is
+	// 2.220446049250313e-16.
+	addOperator(opMap,
+		&Operator{
+			symbol:      ulp,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				f, err := floatOperand(ulp, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				var val floatValue
+				val.value = unitInLastPlace(f)
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+
+	// Constrains the value to the range between the lower and the upper bound,
+	// like (clamp 15 0 10), which results in 10. All three are converted to the
+	// widest of their types, which is also the type of the result.
+	addOperator(opMap,
+		&Operator{
+			symbol:      clamp,
+			minArgCount: 3,
+			maxArgCount: 3,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				_, retVal.Err = typeCoerce(clamp, &operands,
+					map[valueType]int{intType: 1, bigIntType: 2, floatType: 3, bigFloatType: 4})
+				if retVal.Err != nil {
+					return retVal
+				}
+				v, lower, upper := operands[0].Val, operands[1].Val, operands[2].Val
+				for _, bound := range []Value{lower, upper} {
+					if f, ok := bound.(floatValue); ok && math.IsNaN(f.value) {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected the bounds to be numbers, got %s",
+							clamp, bound.Str()))
+						return retVal
+					}
+				}
+				if compareNumbers(lower, upper) > 0 {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, the lower bound %s is greater than the upper bound %s",
+						clamp, lower.Str(), upper.Str()))
+					return retVal
+				}
+				retVal.Val = v
+				if compareNumbers(v, lower) < 0 {
+					retVal.Val = lower
+				} else if compareNumbers(v, upper) > 0 {
+					retVal.Val = upper
+				}
+				return retVal
+			},
+		},
+	)
+
+	// Interpolates linearly between two numbers, like (lerp 0 10 0.25), which
+	// results in 2.5. A t between 0 and 1 results in a float between them, and
+	// one outside of that extrapolates.
+	addOperator(opMap,
+		&Operator{
+			symbol:      lerp,
+			minArgCount: 3,
+			maxArgCount: 3,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				floats, err := floatOperands(lerp, operands)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				var val floatValue
+				val.value = interpolateLinearly(floats[0], floats[1], floats[2])
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+
+	// Maps the value from the first range to the second one, like
+	// (map-range 5 0 10 0 100), which results in 50. The value is found at the
+	// same relative position in the second range as in the first one.
+	addOperator(opMap,
+		&Operator{
+			symbol:      mapRange,
+			minArgCount: 5,
+			maxArgCount: 5,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				floats, err := floatOperands(mapRange, operands)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				v, fromLow, fromHigh, toLow, toHigh := floats[0], floats[1], floats[2], floats[3], floats[4]
+				if fromLow == fromHigh {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, the range from %s to %s is empty",
+						mapRange, operands[1].Val.Str(), operands[2].Val.Str()))
+					return retVal
+				}
+				var val floatValue
+				val.value = interpolateLinearly(toLow, toHigh, (v-fromLow)/(fromHigh-fromLow))
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+
+	// Handlers for sum and product, which fold a list of numbers using + and *,
+	// so that they are promoted in the same way. An empty list results in the
+	// identity of the operator.
+	aggregate := func(symbol, opSymbol string, identity int64) func(*LangEnv, []Atom) Atom {
+		return func(env *LangEnv, operands []Atom) Atom {
+			var retVal Atom
+			listVal, ok := operands[0].Val.(listValue)
+			if !ok {
+				retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list", symbol, operands[0].Val.Str()))
+				return retVal
+			}
+			var val intValue
+			val.value = identity
+			numbers := []Atom{Atom{Val: val}}
+			for _, elem := range listVal.values {
+				switch elem.getValueType() {
+				case intType, bigIntType, floatType, bigFloatType:
+				default:
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a number", symbol, elem.Str()))
+					return retVal
+				}
+				numbers = append(numbers, Atom{Val: elem})
+			}
+			env.mu.RLock()
+			op := opMap[opSymbol]
+			env.mu.RUnlock()
+			return op.handler(env, numbers)
+		}
+	}
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      sum,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler:     aggregate(sum, add, 0),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      product,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler:     aggregate(product, mul, 1),
+		},
+	)
+
+	// Returns the arithmetic mean of a list of numbers, as a float.
+	addOperator(opMap,
+		&Operator{
+			symbol:      mean,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				floats, err := statisticsOperand(mean, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				var val floatValue
+				val.value = meanOf(floats)
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+
+	// Returns the middle number of a list, as a float. For lists of an even
+	// length, it is the mean of the two middle numbers.
+	addOperator(opMap,
+		&Operator{
+			symbol:      median,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				floats, err := statisticsOperand(median, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				// statisticsOperand returns a copy, which can be sorted in place.
+				sort.Float64s(floats)
+				var val floatValue
+				mid := len(floats) / 2
+				if len(floats)%2 == 0 {
+					val.value = (floats[mid-1] + floats[mid]) / 2
+				} else {
+					val.value = floats[mid]
+				}
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+
+	// Returns the standard deviation of a list of numbers, as a float. It is
+	// the one of the whole population, unless :sample is passed, like
+	// (stddev xs :sample), which divides by one less than the length.
+	addOperator(opMap,
+		&Operator{
+			symbol:      stddev,
+			minArgCount: 1,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				floats, err := statisticsOperand(stddev, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				n := float64(len(floats))
+				if len(operands) > 1 {
+					if kw, ok := operands[1].Val.(keywordValue); !ok || kw.name != sampleFlag {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be :%s",
+							stddev, operands[1].Val.Str(), sampleFlag))
+						return retVal
+					}
+					if len(floats) < 2 {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, a sample needs at least two numbers", stddev))
+						return retVal
+					}
+					n--
+				}
+				m := meanOf(floats)
+				var squares float64
+				for _, f := range floats {
+					squares += (f - m) * (f - m)
+				}
+				var val floatValue
+				val.value = math.Sqrt(squares / n)
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+}
@@ -16,32 +16,161 @@ type Operator struct {
 	doNotResolveVars bool
 	passRawAST       bool
 	handler          (func(*LangEnv, []Atom) Atom)
+	// Macros rewrite the expression they are called in, and the rewritten
+	// expression is evaluated instead. They do not have a handler.
+	expander (func(*ASTNode) (*ASTNode, error))
+	// The method which the operator calls, if it was defined using defun.
+	method *method
+	// Whether the operands can be cons cells. Otherwise they are converted to
+	// lists, unless the operator calls a method.
+	keepsConsCells bool
 }
 
 const (
 	// Operators
-	add   string = "+"
-	sub   string = "-"
-	mul   string = "*"
-	div   string = "/"
-	def   string = "defvar"
-	eq    string = "="
-	gt    string = ">"
-	geq   string = ">="
-	lt    string = "<"
-	leq   string = "<="
-	and   string = "and"
-	or    string = "or"
-	defun string = "defun"
-	cond  string = "cond"
+	add     string = "+"
+	sub     string = "-"
+	mul     string = "*"
+	div     string = "/"
+	def     string = "defvar"
+	eq      string = "="
+	gt      string = ">"
+	geq     string = ">="
+	lt      string = "<"
+	leq     string = "<="
+	and     string = "and"
+	or      string = "or"
+	defun   string = "defun"
+	cond    string = "cond"
+	when    string = "when"
+	unless  string = "unless"
+	setPrec string = "set-precision"
+	roundTo string = "round-to"
+	safeDiv string = "safe-div"
+	divmod  string = "divmod"
+	isEven  string = "even?"
+	isOdd   string = "odd?"
+	begin   string = "begin"
+	printOp string = "print"
+	ifOp    string = "if"
+	inc     string = "inc"
+	dec     string = "dec"
+	expt    string = "expt"
 )
 
+// The most bits an integer computed by expt can have. Larger powers take too
+// long to compute, or too much memory to hold.
+const maxPowerBits = 1 << 24
+
 func addOperator(opMap map[string]*Operator, op *Operator) {
 	opMap[op.symbol] = op
 }
 
+// Parses the list of parameters of a method. Every parameter is either a
+// variable name, a `(name default)` pair for optional parameters, which have to
+// come after the required ones, or a pattern which destructures the argument,
+// like in let. Since a two-element list starting with a name is a pair, only
+// the other lists are patterns, like ((a b) c), (a . rest) or (a b c). They are
+// named after their source, and are returned by it in the patterns.
+func parseParams(env *LangEnv, methodName string, paramsNode *ASTNode) ([]string, map[string]*ASTNode, map[string]*ASTNode, error) {
+	params := make([]string, 0)
+	defaults := make(map[string]*ASTNode)
+	patterns := make(map[string]*ASTNode)
+	for i, node := range paramsNode.children {
+		var defaultNode *ASTNode
+		if !node.isValue {
+			if len(node.children) == 0 {
+				return nil, nil, nil, errors.New(fmt.Sprintf("Malformed parameter %d in method %s.", i, methodName))
+			}
+			if len(node.children) != 2 || !node.children[0].isValue {
+				if err := checkPattern(env, node); err != nil {
+					return nil, nil, nil, errors.New(fmt.Sprintf("Malformed parameter %s in method %s: %s",
+						StringifyAST(node), methodName, err))
+				}
+				paramName := StringifyAST(node)
+				if len(defaults) > 0 {
+					return nil, nil, nil, requiredAfterOptional(paramName, methodName)
+				}
+				patterns[paramName] = node
+				params = append(params, paramName)
+				continue
+			}
+			node, defaultNode = node.children[0], node.children[1]
+		}
+		paramName := node.value
+		if err := checkNotSpecialForm(paramName); err != nil {
+			return nil, nil, nil, err
+		}
+		val, err := getValue(env, paramName)
+		if err != nil || val.getValueType() != varType {
+			return nil, nil, nil, errors.New(fmt.Sprintf("Malformed parameter %s in method %s.", paramName, methodName))
+		}
+		if defaultNode != nil {
+			defaults[paramName] = defaultNode
+		} else if len(defaults) > 0 {
+			return nil, nil, nil, requiredAfterOptional(paramName, methodName)
+		}
+		params = append(params, paramName)
+	}
+	return params, defaults, patterns, nil
+}
+
+// Returns the error for a required parameter following optional ones, which
+// is often a pattern which was read as an optional parameter.
+func requiredAfterOptional(paramName, methodName string) error {
+	return errors.New(fmt.Sprintf("Required parameter %s follows optional parameters in method %s. "+
+		"A list of a name and another element, like (a b), is an optional parameter rather than a pattern.",
+		paramName, methodName))
+}
+
+// Returns whether multiplying a and b overflows an int64.
+func mulOverflows(a, b int64) bool {
+	if a == 0 || b == 0 {
+		return false
+	}
+	if (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		return true
+	}
+	return (a*b)/b != a
+}
+
+// Applies op to the big int operands from left to right, like op(op(a, b), c).
+// The operands can be shared by other values, so they are never modified, and
+// the result is a new big.Int. It is computed in place, which allocates less
+// than starting from the identity of op.
+func foldBigInts(operands []Atom, op func(z, x, y *big.Int) *big.Int) *big.Int {
+	result := new(big.Int)
+	if len(operands) == 1 {
+		return result.Set(operands[0].Val.(bigIntValue).value)
+	}
+	op(result, operands[0].Val.(bigIntValue).value, operands[1].Val.(bigIntValue).value)
+	for _, o := range operands[2:] {
+		op(result, result, o.Val.(bigIntValue).value)
+	}
+	return result
+}
+
+// The special forms, which control how their operands are evaluated, and so
+// cannot be redefined, or used as the names of variables and parameters.
+var specialForms = map[string]bool{
+	ifOp: true, cond: true, when: true, unless: true, and: true, or: true,
+	begin: true, def: true, defun: true, lambda: true, alias: true,
+	let: true, letStar: true, ifLet: true, whenLet: true, letValues: true,
+	match: true, forList: true, loop: true, recur: true, delay: true,
+	spawn: true, streamCons: true, tryThread: true, threadFirst: true,
+	threadLast: true, infix: true, macroexpand: true, trace: true,
+	assert: true, deftest: true,
+}
+
+func checkNotSpecialForm(name string) error {
+	if specialForms[name] {
+		return errors.New(fmt.Sprintf("Cannot redefine %s, as it is a special form.", name))
+	}
+	return nil
+}
+
 func addBuiltinOperators(opMap map[string]*Operator) {
-	numValPrecedenceMap := map[valueType]int{intType: 1, bigIntType: 2, floatType: 3}
+	numValPrecedenceMap := map[valueType]int{intType: 1, bigIntType: 2, floatType: 3, bigFloatType: 4}
 	strValPrecedenceMap := map[valueType]int{stringType: 1}
 	boolValPrecedenceMap := map[valueType]int{boolType: 1}
 
@@ -70,6 +199,9 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 							if (v.value > 0 && (finalVal.value > math.MaxInt64-v.value)) ||
 								(v.value <= 0 && finalVal.value < math.MinInt64-v.value) {
 								// There will be an overflow, so better cast to bigIntType here.
+								if retVal.Err = checkPromotion(env, add); retVal.Err != nil {
+									return retVal
+								}
 								err := tryTypeCastTo(&operands, bigIntType)
 								if err != nil {
 									fmt.Printf("Problem while avoiding overflow in operand %s: %s.\n", add, err)
@@ -89,15 +221,7 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 
 				case bigIntType:
 					var finalVal bigIntValue
-					finalVal.value = new(big.Int)
-					for _, o := range operands {
-						v, ok := o.Val.(bigIntValue)
-						if ok {
-							finalVal.value = finalVal.value.Add(finalVal.value, v.value)
-						} else {
-							fmt.Errorf("Error while converting %s to bigIntValue\n", o.Val.Str())
-						}
-					}
+					finalVal.value = foldBigInts(operands, (*big.Int).Add)
 					retVal.Val = finalVal
 					break
 
@@ -115,17 +239,26 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					retVal.Val = finalVal
 					break
 
+				case bigFloatType:
+					var finalVal bigFloatValue
+					finalVal.value = new(big.Float).SetPrec(maxBigFloatPrec(operands))
+					for _, o := range operands {
+						v, _ := o.Val.(bigFloatValue)
+						finalVal.value.Add(finalVal.value, v.value)
+					}
+					retVal.Val = finalVal
+					break
+
 				case stringType:
 					var buffer bytes.Buffer
-					var finalVal stringValue
 					for _, o := range operands {
 						v, ok := o.Val.(stringValue)
 						if ok {
-							buffer.WriteString(strings.Split(v.value, "\"")[1])
+							buffer.WriteString(v.contents())
 						}
 					}
 
-					retVal.Val = finalVal.newValue(fmt.Sprintf("\"%s\"", buffer.String()))
+					retVal.Val = newStringValue(buffer.String())
 					break
 				}
 				return retVal
@@ -167,6 +300,9 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 
 					// Check for overflow/underflow here.
 					if (val2.value > 0 && val1.value < math.MinInt64+val2.value) || (val2.value <= 0 && val1.value > math.MaxInt64+val2.value) {
+						if retVal.Err = checkPromotion(env, sub); retVal.Err != nil {
+							return retVal
+						}
 						err := tryTypeCastTo(&operands, bigIntType)
 						if err != nil {
 							fmt.Printf("Problem while avoiding overflow in operand %s: %s.\n", add, err)
@@ -220,6 +356,15 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					finalVal.value = val1.value - val2.value
 					retVal.Val = finalVal
 					break
+
+				case bigFloatType:
+					var finalVal bigFloatValue
+					val1, _ := operands[0].Val.(bigFloatValue)
+					val2, _ := operands[1].Val.(bigFloatValue)
+					finalVal.value = new(big.Float).SetPrec(maxBigFloatPrec(operands))
+					finalVal.value.Sub(val1.value, val2.value)
+					retVal.Val = finalVal
+					break
 				}
 				return retVal
 			},
@@ -245,24 +390,14 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					var finalVal intValue
 					finalVal.value = 1
 
-					bigIntOp1 := new(big.Int)
-					bigIntOp2 := new(big.Int)
 					for _, o := range operands {
 						v, ok := o.Val.(intValue)
 						if ok {
 							// Check for overflow/underflow here.
-							// The check for multiply turns out to be quite complex.
-							// Refer to this document for the suggested implementation:
-							// https://www.securecoding.cert.org/confluence/display/java/NUM00-J.+Detect+or+prevent+integer+overflow
-							// In order to preserve readability, I would just check if
-							// big.Int's Mul method returns the same value.
-							// This would obviously make multiplications a little slow.
-							bigIntOp1.SetInt64(finalVal.value)
-							bigIntOp2.SetInt64(v.value)
-							bigIntOp1.Mul(bigIntOp1, bigIntOp2)
-
-							finalVal.value = finalVal.value * v.value
-							if bigIntOp1.String() != finalVal.Str() {
+							if mulOverflows(finalVal.value, v.value) {
+								if retVal.Err = checkPromotion(env, mul); retVal.Err != nil {
+									return retVal
+								}
 								err := tryTypeCastTo(&operands, bigIntType)
 								if err != nil {
 									fmt.Printf("Problem while avoiding overflow in operand %s: %s.\n", add, err)
@@ -271,6 +406,7 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 									goto performOp
 								}
 							}
+							finalVal.value = finalVal.value * v.value
 						} else {
 							fmt.Errorf("Error while converting %s to intValue\n", o.Val.Str())
 						}
@@ -280,16 +416,7 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 
 				case bigIntType:
 					var finalVal bigIntValue
-					finalVal.value = new(big.Int)
-					finalVal.value.SetInt64(1)
-					for _, o := range operands {
-						v, ok := o.Val.(bigIntValue)
-						if ok {
-							finalVal.value = finalVal.value.Mul(finalVal.value, v.value)
-						} else {
-							fmt.Errorf("Error while converting %s to bigIntValue\n", o.Val.Str())
-						}
-					}
+					finalVal.value = foldBigInts(operands, (*big.Int).Mul)
 					retVal.Val = finalVal
 					break
 
@@ -306,6 +433,17 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					}
 					retVal.Val = finalVal
 					break
+
+				case bigFloatType:
+					var finalVal bigFloatValue
+					finalVal.value = new(big.Float).SetPrec(maxBigFloatPrec(operands))
+					finalVal.value.SetInt64(1)
+					for _, o := range operands {
+						v, _ := o.Val.(bigFloatValue)
+						finalVal.value.Mul(finalVal.value, v.value)
+					}
+					retVal.Val = finalVal
+					break
 				}
 				return retVal
 			},
@@ -342,9 +480,16 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					if !ok {
 						fmt.Errorf("Error while converting %s to intValue\n", operands[1].Val.Str())
 					}
-					if val2.value != 0 {
+					if val2.value != 0 && env.policy().floatDivision {
+						var quotient floatValue
+						quotient.value = float64(val1.value) / float64(val2.value)
+						retVal.Val = quotient
+					} else if val2.value != 0 {
 						// Check for overflow/underflow here.
 						if val1.value == math.MinInt64 && val2.value == -1 {
+							if retVal.Err = checkPromotion(env, div); retVal.Err != nil {
+								return retVal
+							}
 							err := tryTypeCastTo(&operands, bigIntType)
 							if err != nil {
 								fmt.Printf("Problem while avoiding overflow in operand %s: %s.\n", add, err)
@@ -365,7 +510,6 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					var finalVal bigIntValue
 					var val1, val2 bigIntValue
 					finalVal.value = new(big.Int)
-					finalVal.value.SetInt64(1)
 
 					var ok bool
 					val1, ok = operands[0].Val.(bigIntValue)
@@ -377,7 +521,11 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					if !ok {
 						fmt.Errorf("Error while converting %s to bigIntValue\n", operands[1].Val.Str())
 					}
-					if val2.value.Cmp(new(big.Int).SetInt64(0)) != 0 {
+					if val2.value.Sign() != 0 && env.policy().floatDivision {
+						var quotient floatValue
+						quotient.value, _ = new(big.Rat).SetFrac(val1.value, val2.value).Float64()
+						retVal.Val = quotient
+					} else if val2.value.Sign() != 0 {
 						finalVal.value.Div(val1.value, val2.value)
 						retVal.Val = finalVal
 					} else {
@@ -400,8 +548,19 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					if !ok {
 						fmt.Printf("Error while converting %s to floatValue\n", operands[1].Val.Str())
 					}
-					if val2.value != 0 {
-						finalVal.value = val1.value / val2.value
+					// Dividing a float by zero follows IEEE 754, and results in inf, -inf
+					// or nan.
+					finalVal.value = val1.value / val2.value
+					retVal.Val = finalVal
+					break
+
+				case bigFloatType:
+					var finalVal bigFloatValue
+					val1, _ := operands[0].Val.(bigFloatValue)
+					val2, _ := operands[1].Val.(bigFloatValue)
+					if val2.value.Sign() != 0 {
+						finalVal.value = new(big.Float).SetPrec(maxBigFloatPrec(operands))
+						finalVal.value.Quo(val1.value, val2.value)
 						retVal.Val = finalVal
 					} else {
 						retVal.Err = errors.New(fmt.Sprintf("divide by zero"))
@@ -419,6 +578,7 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 			minArgCount:      2,
 			maxArgCount:      2,
 			doNotResolveVars: true,
+			keepsConsCells:   true,
 			handler: func(env *LangEnv, operands []Atom) Atom {
 				var retVal Atom
 				vtype1 := operands[0].Val.getValueType()
@@ -435,12 +595,15 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 				}
 
 				sym := operands[0].Val.Str()
+				if retVal.Err = checkNotSpecialForm(sym); retVal.Err != nil {
+					return retVal
+				}
 				if env.getOperator(sym) != nil {
 					retVal.Err = errors.New(fmt.Sprintf("Cannot use %s as a variable, as it is defined as an operator.", sym))
 					return retVal
 				}
 
-				env.varMap[sym] = operands[1].Val
+				env.setValue(sym, operands[1].Val)
 				retVal.Val = operands[1].Val
 				return retVal
 			},
@@ -496,6 +659,13 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					retVal.Val = newBoolValue(val1.value > val2.value)
 					break
 
+				case bigFloatType:
+					var val1, val2 bigFloatValue
+					val1, _ = operands[0].Val.(bigFloatValue)
+					val2, _ = operands[1].Val.(bigFloatValue)
+					retVal.Val = newBoolValue(val1.value.Cmp(val2.value) > 0)
+					break
+
 				case stringType:
 					var val1, val2 stringValue
 					val1, _ = operands[0].Val.(stringValue)
@@ -536,6 +706,13 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					retVal.Val = newBoolValue(val1.value >= val2.value)
 					break
 
+				case bigFloatType:
+					var val1, val2 bigFloatValue
+					val1, _ = operands[0].Val.(bigFloatValue)
+					val2, _ = operands[1].Val.(bigFloatValue)
+					retVal.Val = newBoolValue(val1.value.Cmp(val2.value) >= 0)
+					break
+
 				case stringType:
 					var val1, val2 stringValue
 					val1, _ = operands[0].Val.(stringValue)
@@ -576,6 +753,13 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					retVal.Val = newBoolValue(val1.value < val2.value)
 					break
 
+				case bigFloatType:
+					var val1, val2 bigFloatValue
+					val1, _ = operands[0].Val.(bigFloatValue)
+					val2, _ = operands[1].Val.(bigFloatValue)
+					retVal.Val = newBoolValue(val1.value.Cmp(val2.value) < 0)
+					break
+
 				case stringType:
 					var val1, val2 stringValue
 					val1, _ = operands[0].Val.(stringValue)
@@ -616,6 +800,13 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					retVal.Val = newBoolValue(val1.value <= val2.value)
 					break
 
+				case bigFloatType:
+					var val1, val2 bigFloatValue
+					val1, _ = operands[0].Val.(bigFloatValue)
+					val2, _ = operands[1].Val.(bigFloatValue)
+					retVal.Val = newBoolValue(val1.value.Cmp(val2.value) <= 0)
+					break
+
 				case stringType:
 					var val1, val2 stringValue
 					val1, _ = operands[0].Val.(stringValue)
@@ -632,7 +823,7 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 		&Operator{
 			symbol:      defun,
 			minArgCount: 3,
-			maxArgCount: 3,
+			maxArgCount: 4,
 			passRawAST:  true,
 			handler: func(env *LangEnv, operands []Atom) Atom {
 				var retVal Atom
@@ -653,13 +844,13 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 				}
 
 				methodName := methodNameVal.Str()
-				if _, ok := env.varMap[methodNameVal.Str()]; ok {
+				if env.getValue(methodName) != nil {
 					retVal.Err = errors.New(fmt.Sprintf("Method %s already defined as a variable", methodName))
 					return retVal
 				}
 
-				if _, ok := env.opMap[methodNameVal.Str()]; ok {
-					retVal.Err = errors.New(fmt.Sprintf("Method %s already defined as an operator", methodName))
+				// Methods and operators other than the special forms can be redefined.
+				if retVal.Err = checkNotSpecialForm(methodName); retVal.Err != nil {
 					return retVal
 				}
 
@@ -668,69 +859,40 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 					return retVal
 				}
 
-				// Check astNode[1] is a list of varTypes.
-				params := make([]string, 0)
-				for i, node := range astVal.astNodes[1].children {
-					if !node.isValue {
-						retVal.Err = errors.New(fmt.Sprintf("Malformed parameter %d in method %s.", i, methodName))
-						return retVal
-					}
-					paramName := node.value
-					val, err := getValue(env, paramName)
-					if err != nil || val.getValueType() != varType {
-						retVal.Err = errors.New(fmt.Sprintf("Malformed parameter %s in method %s.", paramName, methodName))
-						return retVal
-					}
-					params = append(params, paramName)
+				params, defaults, patterns, err := parseParams(env, methodName, astVal.astNodes[1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
 				}
 
+				doc, body := splitDocString(astVal.astNodes[2:])
+				if len(body) != 1 {
+					retVal.Err = errors.New(fmt.Sprintf("Expected a doc string before the body of method %s, got %s",
+						methodName, StringifyAST(astVal.astNodes[2])))
+					return retVal
+				}
+
+				if retVal.Err = checkRecur(body[0], true); retVal.Err != nil {
+					return retVal
+				}
+
+				m := &method{methodName: methodName, params: params, defaults: defaults, patterns: patterns, ast: body[0], doc: doc,
+					source: newListNode(append([]*ASTNode{newValueNode(defun)}, astVal.astNodes...))}
+				// Methods are defined in the operators of the root environment, which
+				// are guarded like those of any other environment.
+				env.mu.Lock()
+				defer env.mu.Unlock()
 				addOperator(opMap,
 					&Operator{
-						symbol:      methodName,
-						minArgCount: len(params),
-						maxArgCount: len(params),
+						symbol: methodName,
+						// The exact number of arguments depends on whether they are passed
+						// positionally or as keyword arguments, and is checked by the method.
+						minArgCount: 0,
+						maxArgCount: 2 * len(params),
 						handler: func(env *LangEnv, operands []Atom) Atom {
-							var retVal Atom
-							maxRecursionLimit := 100000
-							newEnv := NewEnv()
-
-							// Copy all the operators of the parent env.
-							newEnv.opMap = make(map[string]*Operator, 0)
-							for k, v := range env.opMap {
-								newEnv.opMap[k] = v
-							}
-
-							// Copy all the variable values of the parent env.
-							// We will favor formal arguments over previously defined variables.
-							newEnv.varMap = make(map[string]Value, 0)
-							for k, v := range env.varMap {
-								newEnv.varMap[k] = v
-							}
-
-							// fmt.Printf("Executing the method %s with values: \n", methodName)
-							for i, p := range params {
-								// Check here whether operands[i] is a variable / operator.
-								if op, ok := opMap[operands[i].Val.Str()]; ok {
-									newEnv.opMap[p] = op
-									// fmt.Printf("%s = %s (op)\n", p, operands[i].Val.Str())
-								} else {
-									newEnv.varMap[p] = operands[i].Val
-									// fmt.Printf("%s = %s (op)\n", p, operands[i].Val.Str())
-								}
-							}
-
-							// fmt.Printf(", d: %d\n", env.recursionDepth)
-							newEnv.recursionDepth = env.recursionDepth + 1
-							if newEnv.recursionDepth > maxRecursionLimit {
-								retVal.Err = errors.New(fmt.Sprintf("Reached the recursion limit of %d. Terminating.", maxRecursionLimit))
-								return retVal
-							}
-
-							// fmt.Printf("AST structure: %s\n", getASTStr(astVal.astNodes[2]))
-							retVal = evalASTHelper(newEnv, astVal.astNodes[2])
-							// fmt.Printf("evalASTHelper returned with %s\n", retVal.Val.Str())
-							return retVal
+							return m.call(env, operands, position{})
 						},
+						method: m,
 					},
 				)
 				var val varValue
@@ -830,4 +992,361 @@ func addBuiltinOperators(opMap map[string]*Operator) {
 			},
 		},
 	)
+
+	// Handlers for when and unless, which only differ in whether the body is
+	// evaluated when the condition is truthy, or when it is falsey.
+	conditionalBody := func(evalIfTruthy bool) func(*LangEnv, []Atom) Atom {
+		return func(env *LangEnv, operands []Atom) Atom {
+			astNodeVal, _ := operands[0].Val.(astValue)
+			condValue := evalASTHelper(env, astNodeVal.astNodes[0])
+			if condValue.Err != nil {
+				return condValue
+			}
+			if isTruthy(condValue.Val) != evalIfTruthy {
+				var retVal Atom
+				retVal.Val = newNilValue()
+				return retVal
+			}
+			return evalASTs(env, astNodeVal.astNodes[1:])
+		}
+	}
+
+	// Evaluates the first branch if the condition is truthy, and the second one,
+	// if any, otherwise.
+	addOperator(opMap,
+		&Operator{
+			symbol:      ifOp,
+			minArgCount: 2,
+			maxArgCount: 3,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				astNodeVal, _ := operands[0].Val.(astValue)
+				condValue := evalASTHelper(env, astNodeVal.astNodes[0])
+				if condValue.Err != nil {
+					return condValue
+				}
+				if isTruthy(condValue.Val) {
+					return evalASTHelper(env, astNodeVal.astNodes[1])
+				}
+				return evalASTs(env, astNodeVal.astNodes[2:])
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      when,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler:     conditionalBody(true),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      unless,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler:     conditionalBody(false),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      setPrec,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				prec, ok := operands[0].Val.(intValue)
+				if !ok || prec.value < 1 || prec.value > big.MaxPrec {
+					retVal.Err = errors.New(fmt.Sprintf("%s expects a precision between 1 and %d bits, got %s",
+						setPrec, big.MaxPrec, operands[0].Val.Str()))
+					return retVal
+				}
+				env.setBigFloatPrec(uint(prec.value))
+				retVal.Val = prec
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      roundTo,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				var r *big.Rat
+				r, retVal.Err = toRat(operands[0].Val)
+				if retVal.Err != nil {
+					return retVal
+				}
+				places, ok := operands[1].Val.(intValue)
+				if !ok || places.value < 0 {
+					retVal.Err = errors.New(fmt.Sprintf("%s expects a non-negative number of decimal places, got %s",
+						roundTo, operands[1].Val.Str()))
+					return retVal
+				}
+
+				prec := defaultBigFloatPrec
+				if v, ok := operands[0].Val.(bigFloatValue); ok && v.value.Prec() > prec {
+					prec = v.value.Prec()
+				}
+				var finalVal bigFloatValue
+				finalVal.value = new(big.Float).SetPrec(prec).SetRat(roundHalfEven(r, places.value))
+				retVal.Val = finalVal
+				return retVal
+			},
+		},
+	)
+
+	// Unlike div, dividing by zero results in nil, and not an error or inf.
+	addOperator(opMap,
+		&Operator{
+			symbol:      safeDiv,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				if isZero(operands[1].Val) {
+					var retVal Atom
+					retVal.Val = newNilValue()
+					return retVal
+				}
+				env.mu.RLock()
+				divOp := opMap[div]
+				env.mu.RUnlock()
+				return divOp.handler(env, operands)
+			},
+		},
+	)
+
+	// Returns the quotient and the remainder of an integer division as
+	// multiple values. The division is Euclidean, so the remainder is never
+	// negative: (divmod -7 2) is -4 and 1, and (divmod 7 -2) is -3 and 1.
+	addOperator(opMap,
+		&Operator{
+			symbol:      divmod,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				var finalType valueType
+				finalType, retVal.Err = typeCoerce(divmod, &operands, map[valueType]int{intType: 1, bigIntType: 2})
+				if retVal.Err != nil {
+					return retVal
+				}
+
+				ints := make([]*big.Int, 2)
+				for i, o := range operands {
+					switch v := o.Val.(type) {
+					case intValue:
+						ints[i] = big.NewInt(v.value)
+					case bigIntValue:
+						ints[i] = v.value
+					}
+				}
+				if ints[1].Sign() == 0 {
+					retVal.Err = errors.New(fmt.Sprintf("divide by zero"))
+					return retVal
+				}
+				q, m := new(big.Int).DivMod(ints[0], ints[1], new(big.Int))
+				// Only the quotient of the smallest int64 by -1 overflows.
+				if finalType == intType && !q.IsInt64() {
+					if retVal.Err = checkPromotion(env, divmod); retVal.Err != nil {
+						return retVal
+					}
+				}
+				retVal.Val = newMultipleValues([]Value{newIntegerValue(q), newIntegerValue(m)})
+				return retVal
+			},
+		},
+	)
+
+	// Handlers for even? and odd?, which only accept integers.
+	parity := func(symbol string, even bool) func(*LangEnv, []Atom) Atom {
+		return func(env *LangEnv, operands []Atom) Atom {
+			var retVal Atom
+			var isEvenVal bool
+			switch v := operands[0].Val.(type) {
+			case intValue:
+				isEvenVal = v.value%2 == 0
+			case bigIntValue:
+				isEvenVal = v.value.Bit(0) == 0
+			default:
+				retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be an integer",
+					symbol, operands[0].Val.Str()))
+				return retVal
+			}
+			retVal.Val = newBoolValue(isEvenVal == even)
+			return retVal
+		}
+	}
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      isEven,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler:     parity(isEven, true),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      isOdd,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler:     parity(isOdd, false),
+		},
+	)
+
+	// Evaluates the expressions in order, and returns the result of the last one.
+	addOperator(opMap,
+		&Operator{
+			symbol:      begin,
+			minArgCount: 1,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				astVal, _ := operands[0].Val.(astValue)
+				return evalASTs(env, astVal.astNodes)
+			},
+		},
+	)
+
+	// Prints the values separated by spaces, followed by a newline. Strings are
+	// printed without their quotes.
+	addOperator(opMap,
+		&Operator{
+			symbol:      printOp,
+			minArgCount: 0,
+			maxArgCount: 100,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				strs := make([]string, len(operands))
+				for i, o := range operands {
+					if strVal, ok := o.Val.(stringValue); ok {
+						strs[i] = strVal.contents()
+					} else {
+						strs[i] = o.Val.Str()
+					}
+				}
+				fmt.Fprintln(env.out, strings.Join(strs, " "))
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	// Handlers for inc and dec, which add and subtract one respectively.
+	step := func(symbol string) func(*LangEnv, []Atom) Atom {
+		return func(env *LangEnv, operands []Atom) Atom {
+			var one intValue
+			one.value = 1
+			env.mu.RLock()
+			op := opMap[symbol]
+			env.mu.RUnlock()
+			return op.handler(env, []Atom{operands[0], Atom{Val: one}})
+		}
+	}
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      inc,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler:     step(add),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      dec,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler:     step(sub),
+		},
+	)
+
+	// Raises the base to the power of the exponent. Integers raised to a
+	// non-negative integer power remain integers, and everything else results in
+	// a float. Integer powers with more than maxPowerBits bits are rejected,
+	// rather than computed.
+	addOperator(opMap,
+		&Operator{
+			symbol:      expt,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				// Big integers are not coerced to floats, so they are converted here.
+				for i, o := range operands {
+					if v, ok := o.Val.(bigIntValue); ok && operands[1-i].Val.getValueType() == floatType {
+						var val floatValue
+						val.value, _ = new(big.Float).SetInt(v.value).Float64()
+						operands[i].Val = val
+					}
+				}
+				var finalType valueType
+				finalType, retVal.Err = typeCoerce(expt, &operands, map[valueType]int{intType: 1, bigIntType: 2, floatType: 3})
+				if retVal.Err != nil {
+					return retVal
+				}
+
+				floats := make([]float64, 2)
+				switch finalType {
+				case floatType:
+					for i, o := range operands {
+						floats[i] = o.Val.(floatValue).value
+					}
+				default:
+					ints := make([]*big.Int, 2)
+					for i, o := range operands {
+						switch v := o.Val.(type) {
+						case intValue:
+							ints[i] = big.NewInt(v.value)
+						case bigIntValue:
+							ints[i] = v.value
+						}
+					}
+					if !ints[1].IsInt64() {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, the exponent %s is too large",
+							expt, operands[1].Val.Str()))
+						return retVal
+					}
+					if ints[1].Sign() >= 0 {
+						// The power has at least this many bits.
+						bits := new(big.Int).Mul(big.NewInt(int64(new(big.Int).Abs(ints[0]).BitLen()-1)), ints[1])
+						if bits.Cmp(big.NewInt(maxPowerBits)) > 0 {
+							retVal.Err = errors.New(fmt.Sprintf("For %s, the power of %s to %s is too large",
+								expt, operands[0].Val.Str(), operands[1].Val.Str()))
+							return retVal
+						}
+						power := new(big.Int).Exp(ints[0], ints[1], nil)
+						if finalType == intType && !power.IsInt64() {
+							if retVal.Err = checkPromotion(env, expt); retVal.Err != nil {
+								return retVal
+							}
+						}
+						retVal.Val = newIntegerValue(power)
+						return retVal
+					}
+					if !ints[0].IsInt64() {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, cannot raise %s to a negative power",
+							expt, operands[0].Val.Str()))
+						return retVal
+					}
+					floats[0], floats[1] = float64(ints[0].Int64()), float64(ints[1].Int64())
+				}
+				var val floatValue
+				val.value = math.Pow(floats[0], floats[1])
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
 }
@@ -0,0 +1,113 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	makeParameter string = "make-parameter"
+	parameterize  string = "parameterize"
+)
+
+func addParameterOperators(opMap map[string]*Operator) {
+	// The current values of the parameters, by the function which returns them.
+	// As they are shared by all the environments, they are guarded by the same
+	// lock as the environments.
+	parameters := make(map[*Operator]Value)
+
+	// Returns a parameter, which is a function without arguments returning its
+	// current value. Unlike a variable, it is dynamically scoped: a value given
+	// to it using parameterize is visible to every method called within it.
+	addOperator(opMap,
+		&Operator{
+			symbol:      makeParameter,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				param := &Operator{symbol: "parameter", minArgCount: 0, maxArgCount: 0}
+				param.handler = func(env *LangEnv, operands []Atom) Atom {
+					var retVal Atom
+					env.mu.RLock()
+					retVal.Val = parameters[param]
+					env.mu.RUnlock()
+					return retVal
+				}
+				env.mu.Lock()
+				parameters[param] = operands[0].Val
+				env.mu.Unlock()
+				retVal.Val = funcValue{param}
+				return retVal
+			},
+		},
+	)
+
+	// Evaluates the body with the parameters set to new values, like
+	// (parameterize ((precision 2)) body...). The previous values are restored
+	// afterwards, even if the body results in an error.
+	addOperator(opMap,
+		&Operator{
+			symbol:      parameterize,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				bindingsNode := astVal.astNodes[0]
+				if bindingsNode.isValue {
+					retVal.Err = errors.New(fmt.Sprintf("Missing list of bindings for %s", parameterize))
+					return retVal
+				}
+
+				// All the values are evaluated before any of the parameters is set.
+				params := make([]*Operator, 0, len(bindingsNode.children))
+				values := make([]Value, 0, len(bindingsNode.children))
+				for _, binding := range bindingsNode.children {
+					if binding.isValue || len(binding.children) != 2 {
+						retVal.Err = errors.New(fmt.Sprintf(
+							"Bindings for %s should be of the format `(parameter value)`.", parameterize))
+						return retVal
+					}
+					f, result := evalFunc(env, binding.children[0])
+					if result.Err != nil {
+						return result
+					}
+					param, _ := f.(funcValue)
+					env.mu.RLock()
+					_, ok := parameters[param.op]
+					env.mu.RUnlock()
+					if f == nil || !ok {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a parameter",
+							parameterize, StringifyAST(binding.children[0])))
+						return retVal
+					}
+					result = evalASTHelper(env, binding.children[1])
+					if result.Err != nil {
+						return result
+					}
+					params = append(params, param.op)
+					values = append(values, result.Val)
+				}
+
+				previous := make([]Value, 0, len(params))
+				defer func() {
+					env.mu.Lock()
+					defer env.mu.Unlock()
+					// Restored in reverse, in case a parameter was set more than once.
+					for i := len(previous) - 1; i >= 0; i-- {
+						parameters[params[i]] = previous[i]
+					}
+				}()
+				env.mu.Lock()
+				for i, param := range params {
+					previous = append(previous, parameters[param])
+					parameters[param] = values[i]
+				}
+				env.mu.Unlock()
+				return evalASTs(env, astVal.astNodes[1:])
+			},
+		},
+	)
+}
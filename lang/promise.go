@@ -0,0 +1,114 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	delay string = "delay"
+	force string = "force"
+)
+
+// The promises being forced, innermost first, while evaluating an expression.
+type promiseChain struct {
+	p    *promise
+	next *promiseChain
+}
+
+func (c *promiseChain) contains(p *promise) bool {
+	for ; c != nil; c = c.next {
+		if c.p == p {
+			return true
+		}
+	}
+	return false
+}
+
+// Evaluates the promise, unless it was already forced, and returns its value.
+// If the evaluation raises an error, the promise is left unforced. The promise
+// is evaluated only once, even if tasks force it at the same time: the ones
+// which do not evaluate it wait for its value. Forcing the promise from within
+// its own evaluation raises an error.
+func (v promiseValue) force(env *LangEnv) Atom {
+	var retVal Atom
+	for {
+		v.p.mu.Lock()
+		if v.p.forced {
+			retVal.Val = v.p.value
+			v.p.mu.Unlock()
+			return retVal
+		}
+		if v.p.evaluating == nil {
+			break
+		}
+		evaluating := v.p.evaluating
+		v.p.mu.Unlock()
+		if env.forcing.contains(v.p) {
+			retVal.Err = errors.New(fmt.Sprintf("For %s, the promise was forced while it was being evaluated", force))
+			return retVal
+		}
+		// The promise is evaluated again if the evaluation failed.
+		<-evaluating
+	}
+	evaluating, compute := make(chan struct{}), v.p.compute
+	v.p.evaluating = evaluating
+	v.p.mu.Unlock()
+
+	forcer := *env
+	forcer.forcing = &promiseChain{v.p, env.forcing}
+	retVal = compute(&forcer)
+	v.p.mu.Lock()
+	if retVal.Err == nil {
+		v.p.forced, v.p.value, v.p.compute = true, retVal.Val, nil
+	}
+	v.p.evaluating = nil
+	v.p.mu.Unlock()
+	close(evaluating)
+	return retVal
+}
+
+// Returns a promise to evaluate the expression in the environment.
+func newPromise(env *LangEnv, node *ASTNode) promiseValue {
+	return promiseValue{&promise{compute: func(forcer *LangEnv) Atom {
+		// A copy of the environment, which shares its bindings, so that the
+		// expression can still define variables in it.
+		evalEnv := *env
+		evalEnv.takeCallerState(forcer)
+		return evalASTHelper(&evalEnv, node)
+	}}}
+}
+
+func addPromiseOperators(opMap map[string]*Operator) {
+	// Returns a promise to evaluate the expression in the current environment.
+	addOperator(opMap,
+		&Operator{
+			symbol:      delay,
+			minArgCount: 1,
+			maxArgCount: 1,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				retVal.Val = newPromise(env, astVal.astNodes[0])
+				return retVal
+			},
+		},
+	)
+
+	// Returns the value of the promise, evaluating it the first time it is
+	// forced. Other values are returned as they are.
+	addOperator(opMap,
+		&Operator{
+			symbol:      force,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				if promiseVal, ok := operands[0].Val.(promiseValue); ok {
+					return promiseVal.force(env)
+				}
+				return operands[0]
+			},
+		},
+	)
+}
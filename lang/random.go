@@ -0,0 +1,126 @@
+package lang
+
+import (
+	cryptorand "crypto/rand"
+	"errors"
+	"fmt"
+	"math/rand"
+)
+
+const (
+	random  string = "random"
+	setSeed string = "set-seed"
+	shuffle string = "shuffle"
+	uuidOp  string = "uuid"
+)
+
+// Returns a random version 4 UUID. It uses crypto/rand rather than the
+// environment's generator, so seeding does not make the UUIDs predictable.
+func newUUID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", err
+	}
+	// Set the version to 4, and the variant to the one from RFC 4122.
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+func addRandomOperators(opMap map[string]*Operator) {
+	// Returns a random float in [0, 1), or a random integer in [0, n) if n is
+	// passed.
+	addOperator(opMap,
+		&Operator{
+			symbol:      random,
+			minArgCount: 0,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				if len(operands) == 0 {
+					var val floatValue
+					val.value = env.rand.Float64()
+					retVal.Val = val
+					return retVal
+				}
+				n, ok := operands[0].Val.(intValue)
+				if !ok || n.value <= 0 {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a positive integer",
+						random, operands[0].Val.Str()))
+					return retVal
+				}
+				var val intValue
+				val.value = env.rand.Int63n(n.value)
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+
+	// Seeds the generator, so that the random values after it are the same every
+	// time the script is run.
+	addOperator(opMap,
+		&Operator{
+			symbol:      setSeed,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				seed, ok := operands[0].Val.(intValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be an integer",
+						setSeed, operands[0].Val.Str()))
+					return retVal
+				}
+				// The generator is replaced in place, since the child environments
+				// refer to it.
+				*env.rand = *rand.New(rand.NewSource(seed.value))
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	// Returns a copy of the list, in a random order.
+	addOperator(opMap,
+		&Operator{
+			symbol:      shuffle,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[0].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						shuffle, operands[0].Val.Str()))
+					return retVal
+				}
+				shuffled := make([]Value, len(listVal.values))
+				copy(shuffled, listVal.values)
+				env.rand.Shuffle(len(shuffled), func(i, j int) {
+					shuffled[i], shuffled[j] = shuffled[j], shuffled[i]
+				})
+				retVal.Val = newListValue(shuffled)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      uuidOp,
+			minArgCount: 0,
+			maxArgCount: 0,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				id, err := newUUID()
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newStringValue(id)
+				return retVal
+			},
+		},
+	)
+}
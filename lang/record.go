@@ -0,0 +1,146 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	defstruct string = "defstruct"
+)
+
+// Returns the handler for the accessor of the field at index i, like point-x.
+func recordAccessor(name, symbol string, i int) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		rec, err := recordOperand(name, symbol, operands[0])
+		if err != nil {
+			retVal.Err = err
+			return retVal
+		}
+		retVal.Val = rec.values[i]
+		return retVal
+	}
+}
+
+// Returns the handler for the updater of the field at index i, like
+// point-with-x, which returns a copy of the record with the field changed.
+func recordUpdater(name, symbol string, i int) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		rec, err := recordOperand(name, symbol, operands[0])
+		if err != nil {
+			retVal.Err = err
+			return retVal
+		}
+		values := make([]Value, len(rec.values))
+		copy(values, rec.values)
+		values[i] = operands[1].Val
+		retVal.Val = recordValue{rec.name, rec.fields, values}
+		return retVal
+	}
+}
+
+func recordOperand(name, symbol string, operand Atom) (recordValue, error) {
+	rec, ok := operand.Val.(recordValue)
+	if !ok || rec.name != name {
+		return rec, errors.New(fmt.Sprintf("For %s, expected %s to be a %s", symbol, operand.Val.Str(), name))
+	}
+	return rec, nil
+}
+
+func addRecordOperators(opMap map[string]*Operator) {
+	// Defines a struct with the given fields, like (defstruct point x y), which
+	// defines the constructor make-point, the predicate point?, and for each
+	// field an accessor like point-x, and an updater like point-with-x.
+	addOperator(opMap,
+		&Operator{
+			symbol:           defstruct,
+			minArgCount:      1,
+			maxArgCount:      100,
+			doNotResolveVars: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				name, err := nameOperand(defstruct, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				fields := make([]string, 0, len(operands)-1)
+				for _, o := range operands[1:] {
+					field, err := nameOperand(defstruct, o)
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					for _, f := range fields {
+						if f == field {
+							retVal.Err = errors.New(fmt.Sprintf("Field %s of struct %s is defined more than once",
+								field, name))
+							return retVal
+						}
+					}
+					fields = append(fields, field)
+				}
+
+				ops := []*Operator{
+					{
+						symbol:      "make-" + name,
+						minArgCount: len(fields),
+						maxArgCount: len(fields),
+						handler: func(env *LangEnv, operands []Atom) Atom {
+							var retVal Atom
+							values := make([]Value, len(operands))
+							for i, o := range operands {
+								values[i] = o.Val
+							}
+							retVal.Val = recordValue{name, fields, values}
+							return retVal
+						},
+					},
+					{
+						symbol:      name + "?",
+						minArgCount: 1,
+						maxArgCount: 1,
+						handler: func(env *LangEnv, operands []Atom) Atom {
+							var retVal Atom
+							rec, ok := operands[0].Val.(recordValue)
+							retVal.Val = newBoolValue(ok && rec.name == name)
+							return retVal
+						},
+					},
+				}
+				for i, field := range fields {
+					accessor := name + "-" + field
+					updater := name + "-with-" + field
+					ops = append(ops,
+						&Operator{symbol: accessor, minArgCount: 1, maxArgCount: 1,
+							handler: recordAccessor(name, accessor, i)},
+						&Operator{symbol: updater, minArgCount: 2, maxArgCount: 2,
+							handler: recordUpdater(name, updater, i)},
+					)
+				}
+
+				for _, op := range ops {
+					if !new(varValue).ofType(op.symbol) {
+						retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a name", defstruct, op.symbol))
+						return retVal
+					}
+					if retVal.Err = checkNotSpecialForm(op.symbol); retVal.Err != nil {
+						return retVal
+					}
+					if env.getValue(op.symbol) != nil {
+						retVal.Err = errors.New(fmt.Sprintf("Cannot define %s for struct %s, as it is defined as a variable.",
+							op.symbol, name))
+						return retVal
+					}
+				}
+				for _, op := range ops {
+					env.setOperator(op.symbol, op)
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+}
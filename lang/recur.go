@@ -0,0 +1,174 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	recur string = "recur"
+	loop  string = "loop"
+)
+
+// Checks that recur is only used in tail position in the body of a method,
+// i.e. that its result would be the result of the method. This way, the
+// method can start over instead of calling itself.
+func checkRecur(node *ASTNode, tail bool) error {
+	if node.isValue || len(node.children) == 0 || !node.children[0].isValue {
+		if !node.isValue {
+			return checkRecurAll(node.children, false)
+		}
+		return nil
+	}
+
+	args := node.children[1:]
+	switch node.children[0].value {
+	case recur:
+		if !tail {
+			return errors.New(fmt.Sprintf("%s can only be used in tail position, found %s",
+				recur, StringifyAST(node)))
+		}
+		return checkRecurAll(args, false)
+	case lambda, defun, anonFnBracket:
+		// Methods defined inside are checked on their own.
+		return nil
+	case loop:
+		// So is the body of a loop, since a recur in it starts the loop over.
+		if len(args) == 0 || args[0].isValue {
+			return nil
+		}
+		for _, binding := range args[0].children {
+			if err := checkRecurAll(binding.children, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	case ifOp:
+		if len(args) == 0 {
+			return nil
+		}
+		if err := checkRecur(args[0], false); err != nil {
+			return err
+		}
+		return checkRecurAll(args[1:], tail)
+	case cond:
+		for _, clause := range args {
+			if clause.isValue || len(clause.children) != 2 {
+				continue
+			}
+			if err := checkRecur(clause.children[0], false); err != nil {
+				return err
+			}
+			if err := checkRecur(clause.children[1], tail); err != nil {
+				return err
+			}
+		}
+		return nil
+	case when, unless, let, letStar, letValues:
+		// The condition, or the bindings, followed by the body.
+		if len(args) == 0 {
+			return nil
+		}
+		if err := checkRecur(args[0], false); err != nil {
+			return err
+		}
+		return checkRecurBody(args[1:], tail)
+	case begin:
+		return checkRecurBody(args, tail)
+	}
+	return checkRecurAll(args, false)
+}
+
+func checkRecurAll(nodes []*ASTNode, tail bool) error {
+	for _, n := range nodes {
+		if err := checkRecur(n, tail); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Checks a sequence of expressions, of which only the last one can be in tail
+// position.
+func checkRecurBody(nodes []*ASTNode, tail bool) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	if err := checkRecurAll(nodes[:len(nodes)-1], false); err != nil {
+		return err
+	}
+	return checkRecur(nodes[len(nodes)-1], tail)
+}
+
+func addRecurOperators(opMap map[string]*Operator) {
+	// Starts the method it is used in over, with the new arguments. It is only
+	// valid in tail position, which is checked when the method is defined.
+	addOperator(opMap,
+		&Operator{
+			symbol:         recur,
+			minArgCount:    0,
+			maxArgCount:    100,
+			keepsConsCells: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = recurValue{operandValues(operands)}
+				return retVal
+			},
+		},
+	)
+
+	// Binds the variables to their initial values, and evaluates the body. A
+	// recur in tail position of the body evaluates it again, with the variables
+	// bound to the new values.
+	addOperator(opMap,
+		&Operator{
+			symbol:      loop,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				bindingsNode := astVal.astNodes[0]
+				if bindingsNode.isValue {
+					retVal.Err = errors.New(fmt.Sprintf("Missing list of bindings for %s", loop))
+					return retVal
+				}
+
+				params := make([]string, 0, len(bindingsNode.children))
+				initial := make([]Atom, 0, len(bindingsNode.children))
+				for _, binding := range bindingsNode.children {
+					if binding.isValue || len(binding.children) != 2 || !binding.children[0].isValue {
+						retVal.Err = errors.New(fmt.Sprintf(
+							"Bindings for %s should be of the format `(name value)`.", loop))
+						return retVal
+					}
+					nameVal, err := getValue(env, binding.children[0].value)
+					if err != nil || nameVal.getValueType() != varType {
+						retVal.Err = errors.New(fmt.Sprintf("Expected a variable name to bind to, got %s",
+							binding.children[0].value))
+						return retVal
+					}
+					result := evalASTHelper(env, binding.children[1])
+					if result.Err != nil {
+						return result
+					}
+					params = append(params, nameVal.Str())
+					initial = append(initial, result)
+				}
+
+				body := astVal.astNodes[1]
+				if len(astVal.astNodes) > 2 {
+					body = newListNode(append([]*ASTNode{newValueNode(begin)}, astVal.astNodes[1:]...))
+				}
+				if retVal.Err = checkRecur(body, true); retVal.Err != nil {
+					return retVal
+				}
+				// The loop is evaluated like a method without optional parameters,
+				// which is called right away.
+				m := &method{methodName: loop, params: params, defaults: map[string]*ASTNode{}, ast: body, env: env}
+				return m.call(env, initial, astVal.parentASTNode.pos)
+			},
+		},
+	)
+}
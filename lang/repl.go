@@ -0,0 +1,145 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+const (
+	setREPLPrompt    string = "set-repl-prompt"
+	setREPLFormatter string = "set-repl-formatter"
+	typeOf           string = "type-of"
+	defaultPrompt    string = "lambda> "
+)
+
+// How the REPL prompts for input and prints results. It is shared by an
+// environment and the ones created from it, so that it can be changed from
+// within methods too.
+type replSettings struct {
+	prompt string
+	// The function which results are passed to before they are printed, or nil
+	// to print them as they are.
+	formatter Value
+}
+
+func newREPLSettings() *replSettings {
+	r := new(replSettings)
+	r.prompt = defaultPrompt
+	return r
+}
+
+// Returns the prompt which the REPL shows before reading an expression.
+func (e *LangEnv) Prompt() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.repl.prompt
+}
+
+// Returns the value of the result as the REPL prints it, which is the string
+// returned by the formatter, if one is set using set-repl-formatter.
+func (e *LangEnv) FormatResult(r *EvalResult) (string, error) {
+	e.mu.RLock()
+	formatter := e.repl.formatter
+	e.mu.RUnlock()
+	if formatter == nil || r.val == nil {
+		return r.ValStr, nil
+	}
+	result := callOperator(e, formatter, []Value{r.val})
+	if result.Err == nil && result.Val.getValueType() == varType {
+		result.Val, result.Err = getVarValue(e, result.Val)
+	}
+	if result.Err != nil {
+		return "", errors.New(fmt.Sprintf("For %s, could not format %s: %s", setREPLFormatter, r.ValStr, result.Err))
+	}
+	if str, ok := result.Val.(stringValue); ok {
+		return str.contents(), nil
+	}
+	return result.Val.Str(), nil
+}
+
+// Returns the name of the type, like big-int for bigIntType.
+func typeName(t valueType) string {
+	name := strings.TrimSuffix(fmt.Sprint(t), "Type")
+	var b strings.Builder
+	for _, r := range name {
+		if unicode.IsUpper(r) {
+			b.WriteRune('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// Returns the name preceded by its indefinite article, like "an atom".
+func withArticle(name string) string {
+	if len(name) > 0 && strings.ContainsRune("aeiou", rune(name[0])) {
+		return "an " + name
+	}
+	return "a " + name
+}
+
+func addREPLOperators(opMap map[string]*Operator) {
+	addOperator(opMap,
+		&Operator{
+			symbol:      setREPLPrompt,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				prompt, err := stringOperand(setREPLPrompt, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				env.mu.Lock()
+				env.repl.prompt = prompt
+				env.mu.Unlock()
+				retVal.Val = operands[0].Val
+				return retVal
+			},
+		},
+	)
+
+	// Sets the function which the REPL passes results to, and prints what it
+	// returns instead, like (set-repl-formatter (lambda (v) (interp
+	// "${(type-of v)} ${v}"))). Passing nil prints the results as they are.
+	addOperator(opMap,
+		&Operator{
+			symbol:      setREPLFormatter,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				formatter := operands[0].Val
+				if formatter.getValueType() == nilType {
+					formatter = nil
+				} else if resolveOperator(env, formatter) == nil {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a method or an operator",
+						setREPLFormatter, formatter.Str()))
+					return retVal
+				}
+				env.mu.Lock()
+				env.repl.formatter = formatter
+				env.mu.Unlock()
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	// Returns the type of the value as a keyword, like :int or :list.
+	addOperator(opMap,
+		&Operator{
+			symbol:      typeOf,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = keywordValue{typeName(operands[0].Val.getValueType())}
+				return retVal
+			},
+		},
+	)
+}
@@ -0,0 +1,449 @@
+package lang
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"math/big"
+)
+
+const (
+	serialize   string = "serialize"
+	deserialize string = "deserialize"
+)
+
+// Written at the start of every serialized value, so that the format can be
+// changed later without misreading older blobs.
+const serializationVersion byte = 1
+
+// Precede the encoding of every value, telling its type apart.
+const (
+	nilTag byte = iota
+	falseTag
+	trueTag
+	intTag
+	bigIntTag
+	floatTag
+	bigFloatTag
+	stringTag
+	charTag
+	keywordTag
+	symbolTag
+	enumTag
+	listTag
+	vectorTag
+	mapTag
+	setTag
+	recordTag
+	errorTag
+	blobTag
+)
+
+func appendString(buf []byte, s string) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+// Appends the encoding of the values, preceded by how many there are.
+func appendValues(buf []byte, values []Value) ([]byte, error) {
+	buf = binary.AppendUvarint(buf, uint64(len(values)))
+	var err error
+	for _, v := range values {
+		if buf, err = appendValue(buf, v); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Appends the encoding of the value, which is a tag followed by the contents of
+// the value. Numbers are encoded exactly, and strings keep their quotes.
+// Methods, and values with state like promises and atoms, cannot be encoded.
+func appendValue(buf []byte, v Value) ([]byte, error) {
+	switch val := consToList(v).(type) {
+	case nilValue:
+		return append(buf, nilTag), nil
+	case boolValue:
+		if val.value {
+			return append(buf, trueTag), nil
+		}
+		return append(buf, falseTag), nil
+	case intValue:
+		return binary.AppendVarint(append(buf, intTag), val.value), nil
+	case bigIntValue:
+		data, err := val.value.GobEncode()
+		if err != nil {
+			return nil, err
+		}
+		return appendString(append(buf, bigIntTag), string(data)), nil
+	case floatValue:
+		return binary.BigEndian.AppendUint64(append(buf, floatTag), math.Float64bits(val.value)), nil
+	case bigFloatValue:
+		// Includes the precision and the rounding mode.
+		data, err := val.value.GobEncode()
+		if err != nil {
+			return nil, err
+		}
+		return appendString(append(buf, bigFloatTag), string(data)), nil
+	case stringValue:
+		return appendString(append(buf, stringTag), val.value), nil
+	case charValue:
+		return binary.AppendVarint(append(buf, charTag), int64(val.value)), nil
+	case keywordValue:
+		return appendString(append(buf, keywordTag), val.name), nil
+	case symbolValue:
+		return appendString(append(buf, symbolTag), val.name), nil
+	case enumValue:
+		return appendString(appendString(append(buf, enumTag), val.enum), val.name), nil
+	case listValue:
+		return appendValues(append(buf, listTag), val.values)
+	case vectorValue:
+		return appendValues(append(buf, vectorTag), val.values)
+	case mapValue:
+		buf = binary.AppendUvarint(append(buf, mapTag), uint64(len(val.order)))
+		var err error
+		for _, k := range val.order {
+			entry := val.entries[k]
+			if buf, err = appendValue(buf, entry.key); err != nil {
+				return nil, err
+			}
+			if buf, err = appendValue(buf, entry.value); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	case setValue:
+		return appendValues(append(buf, setTag), val.values())
+	case recordValue:
+		buf = appendString(append(buf, recordTag), val.name)
+		buf = binary.AppendUvarint(buf, uint64(len(val.fields)))
+		for _, field := range val.fields {
+			buf = appendString(buf, field)
+		}
+		return appendValues(buf, val.values)
+	case errorValue:
+		return appendString(append(buf, errorTag), val.err.Error()), nil
+	case blobValue:
+		return appendString(append(buf, blobTag), string(val.data)), nil
+	}
+	return nil, errors.New(fmt.Sprintf("For %s, cannot serialize %s, which is %s",
+		serialize, v.Str(), withArticle(typeName(v.getValueType()))))
+}
+
+// Reads values back from their encoding, failing on data which was not written
+// by appendValue.
+type decoder struct {
+	data []byte
+	pos  int
+}
+
+func errCorrupt() error {
+	return errors.New(fmt.Sprintf("For %s, the data is corrupt", deserialize))
+}
+
+func (d *decoder) readByte() (byte, error) {
+	if d.pos >= len(d.data) {
+		return 0, errCorrupt()
+	}
+	d.pos++
+	return d.data[d.pos-1], nil
+}
+
+func (d *decoder) readUvarint() (uint64, error) {
+	n, size := binary.Uvarint(d.data[d.pos:])
+	if size <= 0 {
+		return 0, errCorrupt()
+	}
+	d.pos += size
+	return n, nil
+}
+
+func (d *decoder) readVarint() (int64, error) {
+	n, size := binary.Varint(d.data[d.pos:])
+	if size <= 0 {
+		return 0, errCorrupt()
+	}
+	d.pos += size
+	return n, nil
+}
+
+// Reads how many values follow. Each of them takes up at least a byte, which
+// stops corrupt data from allocating more than it could hold.
+func (d *decoder) readCount() (int, error) {
+	n, err := d.readUvarint()
+	if err != nil {
+		return 0, err
+	}
+	if n > uint64(len(d.data)-d.pos) {
+		return 0, errCorrupt()
+	}
+	return int(n), nil
+}
+
+func (d *decoder) readString() (string, error) {
+	n, err := d.readCount()
+	if err != nil {
+		return "", err
+	}
+	s := string(d.data[d.pos : d.pos+n])
+	d.pos += n
+	return s, nil
+}
+
+func (d *decoder) readValues() ([]Value, error) {
+	n, err := d.readCount()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]Value, n)
+	for i := range values {
+		if values[i], err = d.readValue(); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func (d *decoder) readValue() (Value, error) {
+	tag, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case nilTag:
+		return newNilValue(), nil
+	case falseTag, trueTag:
+		return newBoolValue(tag == trueTag), nil
+	case intTag:
+		n, err := d.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		var val intValue
+		val.value = n
+		return val, nil
+	case bigIntTag:
+		data, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var val bigIntValue
+		val.value = new(big.Int)
+		if val.value.GobDecode([]byte(data)) != nil {
+			return nil, errCorrupt()
+		}
+		return val, nil
+	case floatTag:
+		if len(d.data)-d.pos < 8 {
+			return nil, errCorrupt()
+		}
+		var val floatValue
+		val.value = math.Float64frombits(binary.BigEndian.Uint64(d.data[d.pos:]))
+		d.pos += 8
+		return val, nil
+	case bigFloatTag:
+		data, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		var val bigFloatValue
+		val.value = new(big.Float)
+		if val.value.GobDecode([]byte(data)) != nil {
+			return nil, errCorrupt()
+		}
+		return val, nil
+	case stringTag:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		// The string is stored with its quotes.
+		if len(s) < 2 || s[0] != s[len(s)-1] || (s[0] != '"' && s[0] != '\'') {
+			return nil, errCorrupt()
+		}
+		var val stringValue
+		val.value = s
+		return val, nil
+	case charTag:
+		r, err := d.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		return newCharValue(rune(r)), nil
+	case keywordTag, symbolTag, errorTag, blobTag:
+		s, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case keywordTag:
+			return keywordValue{s}, nil
+		case symbolTag:
+			return symbolValue{s}, nil
+		case errorTag:
+			return newErrorValue(errors.New(s)), nil
+		}
+		return blobValue{[]byte(s)}, nil
+	case enumTag:
+		enum, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		return enumValue{enum, name}, nil
+	case listTag, vectorTag, setTag:
+		values, err := d.readValues()
+		if err != nil {
+			return nil, err
+		}
+		switch tag {
+		case listTag:
+			return newListValue(values), nil
+		case vectorTag:
+			return newVectorValue(values), nil
+		}
+		result := newSetValue()
+		for _, elem := range values {
+			result.add(elem)
+		}
+		return result, nil
+	case mapTag:
+		n, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+		result := newMapValue()
+		for i := 0; i < n; i++ {
+			key, err := d.readValue()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readValue()
+			if err != nil {
+				return nil, err
+			}
+			result.put(key, value)
+		}
+		return result, nil
+	case recordTag:
+		name, err := d.readString()
+		if err != nil {
+			return nil, err
+		}
+		n, err := d.readCount()
+		if err != nil {
+			return nil, err
+		}
+		fields := make([]string, n)
+		for i := range fields {
+			if fields[i], err = d.readString(); err != nil {
+				return nil, err
+			}
+		}
+		values, err := d.readValues()
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != len(fields) {
+			return nil, errCorrupt()
+		}
+		return recordValue{name, fields, values}, nil
+	}
+	return nil, errCorrupt()
+}
+
+// Returns the compact binary encoding of the value, which deserializeValue
+// turns back into an equal value, of the same types.
+func serializeValue(v Value) ([]byte, error) {
+	return appendValue([]byte{serializationVersion}, v)
+}
+
+func deserializeValue(data []byte) (Value, error) {
+	if len(data) == 0 || data[0] != serializationVersion {
+		return nil, errors.New(fmt.Sprintf("For %s, expected data serialized by version %d of %s",
+			deserialize, serializationVersion, serialize))
+	}
+	d := &decoder{data, 1}
+	v, err := d.readValue()
+	if err != nil {
+		return nil, err
+	}
+	if d.pos != len(data) {
+		return nil, errCorrupt()
+	}
+	return v, nil
+}
+
+func addSerializeOperators(opMap map[string]*Operator) {
+	// Serializes the value into a blob, like (serialize (list 1 2)), or writes it
+	// to the file at the path, like (serialize (list 1 2) "cache.bin"). Unlike
+	// printing a value, this keeps the exact types of numbers.
+	addOperator(opMap,
+		&Operator{
+			symbol:      serialize,
+			minArgCount: 1,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				data, err := serializeValue(operands[0].Val)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				if len(operands) == 1 {
+					retVal.Val = blobValue{data}
+					return retVal
+				}
+				if retVal.Err = checkNotSandboxed(env, serialize); retVal.Err != nil {
+					return retVal
+				}
+				path, err := stringOperand(serialize, operands[1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				if retVal.Err = ioutil.WriteFile(path, data, 0644); retVal.Err != nil {
+					return retVal
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	// Reconstructs a value from a blob returned by serialize, or from the file at
+	// the path it was written to.
+	addOperator(opMap,
+		&Operator{
+			symbol:      deserialize,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				var data []byte
+				switch v := operands[0].Val.(type) {
+				case blobValue:
+					data = v.data
+				case stringValue:
+					if retVal.Err = checkNotSandboxed(env, deserialize); retVal.Err != nil {
+						return retVal
+					}
+					if data, retVal.Err = ioutil.ReadFile(v.contents()); retVal.Err != nil {
+						return retVal
+					}
+				default:
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a blob or the path of a file",
+						deserialize, operands[0].Val.Str()))
+					return retVal
+				}
+				retVal.Val, retVal.Err = deserializeValue(data)
+				return retVal
+			},
+		},
+	)
+}
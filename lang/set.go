@@ -0,0 +1,166 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	makeSet         string = "make-set"
+	setAdd          string = "set-add"
+	isSetMember     string = "set-member?"
+	setUnion        string = "set-union"
+	setIntersection string = "set-intersection"
+	setDifference   string = "set-difference"
+)
+
+// Returns the sets passed to the operator, or an error if any of the operands
+// is not a set.
+func setOperands(symbol string, operands []Atom) ([]setValue, error) {
+	sets := make([]setValue, len(operands))
+	for i, o := range operands {
+		setVal, ok := o.Val.(setValue)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("For %s, expected %s to be a set", symbol, o.Val.Str()))
+		}
+		sets[i] = setVal
+	}
+	return sets, nil
+}
+
+// Returns the handler for an operator combining sets. The result has the
+// elements of the first set which keep returns true for, followed by those of
+// the other sets if addOthers is set. The sets passed are not modified.
+func setCombiner(symbol string, addOthers bool, keep func(Value, []setValue) bool) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		sets, err := setOperands(symbol, operands)
+		if err != nil {
+			retVal.Err = err
+			return retVal
+		}
+		result := newSetValue()
+		for _, elem := range sets[0].values() {
+			if keep(elem, sets[1:]) {
+				result.add(elem)
+			}
+		}
+		if addOthers {
+			for _, s := range sets[1:] {
+				for _, elem := range s.values() {
+					result.add(elem)
+				}
+			}
+		}
+		retVal.Val = result
+		return retVal
+	}
+}
+
+func addSetOperators(opMap map[string]*Operator) {
+	addOperator(opMap,
+		&Operator{
+			symbol:      makeSet,
+			minArgCount: 0,
+			maxArgCount: 100,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				result := newSetValue()
+				for _, o := range operands {
+					result.add(o.Val)
+				}
+				retVal.Val = result
+				return retVal
+			},
+		},
+	)
+
+	// Returns a new set with the elements added to the set.
+	addOperator(opMap,
+		&Operator{
+			symbol:      setAdd,
+			minArgCount: 2,
+			maxArgCount: 100,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				sets, err := setOperands(setAdd, operands[:1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				result := newSetValue()
+				for _, elem := range sets[0].values() {
+					result.add(elem)
+				}
+				for _, o := range operands[1:] {
+					result.add(o.Val)
+				}
+				retVal.Val = result
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      isSetMember,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				sets, err := setOperands(isSetMember, operands[:1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = newBoolValue(sets[0].has(operands[1].Val))
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      setUnion,
+			minArgCount: 1,
+			maxArgCount: 100,
+			handler: setCombiner(setUnion, true, func(elem Value, others []setValue) bool {
+				return true
+			}),
+		},
+	)
+
+	// Returns the elements of the first set which are in all the other sets.
+	addOperator(opMap,
+		&Operator{
+			symbol:      setIntersection,
+			minArgCount: 1,
+			maxArgCount: 100,
+			handler: setCombiner(setIntersection, false, func(elem Value, others []setValue) bool {
+				for _, s := range others {
+					if !s.has(elem) {
+						return false
+					}
+				}
+				return true
+			}),
+		},
+	)
+
+	// Returns the elements of the first set which are in none of the other sets.
+	addOperator(opMap,
+		&Operator{
+			symbol:      setDifference,
+			minArgCount: 1,
+			maxArgCount: 100,
+			handler: setCombiner(setDifference, false, func(elem Value, others []setValue) bool {
+				for _, s := range others {
+					if s.has(elem) {
+						return false
+					}
+				}
+				return true
+			}),
+		},
+	)
+}
@@ -0,0 +1,125 @@
+package lang
+
+import (
+	"math/big"
+	"reflect"
+	"unsafe"
+)
+
+const (
+	sizeOf string = "sizeof"
+)
+
+// The size of a string header, of an interface holding a value, which is what
+// every element of a list or a vector takes up besides the element itself, and
+// of the words making up big numbers.
+const (
+	stringHeaderSize = int64(unsafe.Sizeof(""))
+	valueSlotSize    = int64(unsafe.Sizeof(Value(nil)))
+	wordSize         = int64(unsafe.Sizeof(big.Word(0)))
+)
+
+func bigIntSize(i *big.Int) int64 {
+	if i == nil {
+		return 0
+	}
+	return int64(unsafe.Sizeof(*i)) + int64(cap(i.Bits()))*wordSize
+}
+
+// Returns the sizes of the values, along with the slots holding them.
+func slotsSize(values []Value) int64 {
+	size := int64(cap(values)) * valueSlotSize
+	for _, v := range values {
+		size += approxSize(v)
+	}
+	return size
+}
+
+// Returns an estimate of the number of bytes taken up by the value, counting
+// everything nested within it. It is the size of the Go struct holding the
+// value, plus the contents of its strings, the words of big numbers, and the
+// sizes of the elements of lists, vectors, records, maps and sets.
+// It is only a heuristic: memory shared with other values is counted every
+// time it is reached, the overhead of Go maps beyond their keys and values is
+// not counted, and methods, promises, streams, futures, channels and atoms only
+// count their own struct, and not the state they point to.
+func approxSize(v Value) int64 {
+	if v == nil {
+		return 0
+	}
+	size := int64(reflect.TypeOf(v).Size())
+	switch val := v.(type) {
+	case stringValue:
+		size += int64(len(val.value))
+	case varValue:
+		size += int64(len(val.value) + len(val.varName))
+	case keywordValue:
+		size += int64(len(val.name))
+	case symbolValue:
+		size += int64(len(val.name))
+	case enumValue:
+		size += int64(len(val.enum) + len(val.name))
+	case blobValue:
+		size += int64(cap(val.data))
+	case errorValue:
+		size += int64(len(val.err.Error()))
+	case bigIntValue:
+		size += bigIntSize(val.value)
+	case bigFloatValue:
+		if val.value != nil {
+			// The mantissa takes up as many words as its precision needs.
+			words := (int64(val.value.Prec()) + wordSize*8 - 1) / (wordSize * 8)
+			size += int64(unsafe.Sizeof(*val.value)) + words*wordSize
+		}
+	case listValue:
+		size += slotsSize(val.values)
+	case vectorValue:
+		size += slotsSize(val.values)
+	case multipleValues:
+		size += slotsSize(val.values)
+	case recordValue:
+		// The field names are shared by all the records of a struct.
+		size += slotsSize(val.values)
+	case consValue:
+		for cell := val.cell; ; {
+			size += int64(unsafe.Sizeof(*cell)) + approxSize(cell.head)
+			next, ok := cell.tail.(consValue)
+			if !ok {
+				size += approxSize(cell.tail)
+				break
+			}
+			cell = next.cell
+		}
+	case mapValue:
+		for _, k := range val.order {
+			entry := val.entries[k]
+			// The hash key is stored both in the order and in the map.
+			size += 2*stringHeaderSize + int64(len(k)) + int64(unsafe.Sizeof(entry)) +
+				approxSize(entry.key) + approxSize(entry.value)
+		}
+	case setValue:
+		for _, k := range val.order {
+			size += 2*stringHeaderSize + int64(len(k)) + valueSlotSize + approxSize(val.elements[k])
+		}
+	}
+	return size
+}
+
+func addSizeOperators(opMap map[string]*Operator) {
+	// Estimates the size of a value in bytes, like (sizeof (list 1 2 3)), to
+	// compare the memory used by different representations of the same data.
+	addOperator(opMap,
+		&Operator{
+			symbol:      sizeOf,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				var val intValue
+				val.value = approxSize(operands[0].Val)
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+}
@@ -0,0 +1,163 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	streamCons    string = "stream-cons"
+	streamCar     string = "stream-car"
+	streamCdr     string = "stream-cdr"
+	streamTake    string = "stream-take"
+	streamIterate string = "stream-iterate"
+)
+
+func streamOperand(symbol string, operand Value) (streamValue, error) {
+	streamVal, ok := operand.(streamValue)
+	if !ok {
+		return streamVal, errors.New(fmt.Sprintf("For %s, expected %s to be a stream", symbol, operand.Str()))
+	}
+	return streamVal, nil
+}
+
+// Forces the tail of the stream, which has to be either a stream or nil.
+func (v streamValue) rest(env *LangEnv) Atom {
+	retVal := v.tail.force(env)
+	if retVal.Err != nil {
+		return retVal
+	}
+	switch retVal.Val.getValueType() {
+	case streamType, nilType:
+		return retVal
+	}
+	retVal.Err = errors.New(fmt.Sprintf("Expected the tail of a stream to be a stream or nil, got %s",
+		retVal.Val.Str()))
+	return retVal
+}
+
+// Returns the infinite stream of x, (f x), (f (f x)) and so on.
+func iterateStream(env *LangEnv, fn, x Value) streamValue {
+	return streamValue{x, promiseValue{&promise{compute: func(forcer *LangEnv) Atom {
+		callEnv := *env
+		callEnv.takeCallerState(forcer)
+		next := callOperator(&callEnv, fn, []Value{x})
+		if next.Err == nil {
+			next.Err = checkSingleValue(streamIterate, next.Val)
+		}
+		if next.Err != nil {
+			next.Val = nil
+			return next
+		}
+		next.Val = iterateStream(env, fn, next.Val)
+		return next
+	}}}}
+}
+
+func addStreamOperators(opMap map[string]*Operator) {
+	// Returns a stream with the given head, and the tail expression delayed.
+	addOperator(opMap,
+		&Operator{
+			symbol:      streamCons,
+			minArgCount: 2,
+			maxArgCount: 2,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				astVal, _ := operands[0].Val.(astValue)
+				retVal := evalASTHelper(env, astVal.astNodes[0])
+				if retVal.Err != nil {
+					return retVal
+				}
+				retVal.Val = streamValue{retVal.Val, newPromise(env, astVal.astNodes[1])}
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      streamCar,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				streamVal, err := streamOperand(streamCar, operands[0].Val)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				retVal.Val = streamVal.head
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      streamCdr,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				streamVal, err := streamOperand(streamCdr, operands[0].Val)
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				return streamVal.rest(env)
+			},
+		},
+	)
+
+	// Returns the list of the first n elements of the stream, or all of them if
+	// the stream is shorter. Only the tails needed are computed.
+	addOperator(opMap,
+		&Operator{
+			symbol:      streamTake,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				n, ok := operands[0].Val.(intValue)
+				if !ok || n.value < 0 {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative integer",
+						streamTake, operands[0].Val.Str()))
+					return retVal
+				}
+				values := make([]Value, 0)
+				current := operands[1].Val
+				for i := int64(0); i < n.value && current.getValueType() != nilType; i++ {
+					streamVal, err := streamOperand(streamTake, current)
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					values = append(values, streamVal.head)
+					if i+1 == n.value {
+						break
+					}
+					next := streamVal.rest(env)
+					if next.Err != nil {
+						return next
+					}
+					current = next.Val
+				}
+				retVal.Val = newListValue(values)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      streamIterate,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				retVal.Val = iterateStream(env, operands[0].Val, operands[1].Val)
+				return retVal
+			},
+		},
+	)
+}
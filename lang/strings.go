@@ -0,0 +1,328 @@
+package lang
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	interp       string = "interp"
+	stringToList string = "string->list"
+	listToString string = "list->string"
+	padLeft      string = "pad-left"
+	padRight     string = "pad-right"
+	repeat       string = "repeat"
+	startsWith   string = "starts-with?"
+	endsWith     string = "ends-with?"
+	// Makes pad-left and pad-right cut strings which are wider than the width.
+	truncateFlag string = "truncate"
+	// Opens a placeholder in an interp template, which is closed by a }.
+	interpOpen string = "${"
+)
+
+// The longest string in bytes which repeat builds. Running out of memory
+// cannot be recovered from, so longer ones are rejected.
+const maxRepeatBytes = 1 << 26
+
+// Returns the characters of the string, without the surrounding quotes.
+func (v stringValue) contents() string {
+	return v.value[1 : len(v.value)-1]
+}
+
+// Returns the index of the } which closes the placeholder starting at the
+// beginning of exp. Braces within string literals in the expression are
+// ignored.
+func placeholderEnd(exp string) int {
+	depth := 0
+	var quote byte
+	for i := 0; i < len(exp); i++ {
+		c := exp[i]
+		switch {
+		case quote != 0:
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '{':
+			depth++
+		case c == '}':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+	}
+	return -1
+}
+
+// Evaluates the expression in a placeholder, and returns the text it should be
+// replaced with. Strings are inserted without their quotes.
+func interpolate(env *LangEnv, exp string) (string, error) {
+	astNode, tokens, _, err := getAST(exp)
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("Could not parse ${%s} in %s: %s", exp, interp, err))
+	}
+	if len(tokens) > 0 {
+		return "", errors.New(fmt.Sprintf("Expected a single expression in ${%s} in %s", exp, interp))
+	}
+	result := evalASTHelper(env, astNode)
+	if result.Err != nil {
+		return "", result.Err
+	}
+	if strVal, ok := result.Val.(stringValue); ok {
+		return strVal.contents(), nil
+	}
+	return result.Val.Str(), nil
+}
+
+// Returns the handler for pad-left or pad-right, which take a string, the width
+// to pad it to in characters, and optionally the character to pad it with and
+// the :truncate flag. Strings which are wider are returned unchanged, unless
+// the flag is passed, in which case only their first width characters are kept.
+func padHandler(symbol string, atLeft bool) func(*LangEnv, []Atom) Atom {
+	return func(env *LangEnv, operands []Atom) Atom {
+		var retVal Atom
+		strVal, ok := operands[0].Val.(stringValue)
+		if !ok {
+			retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a string",
+				symbol, operands[0].Val.Str()))
+			return retVal
+		}
+		width, ok := operands[1].Val.(intValue)
+		if !ok || width.value < 0 {
+			retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative integer",
+				symbol, operands[1].Val.Str()))
+			return retVal
+		}
+
+		padChar, truncate := ' ', false
+		for _, o := range operands[2:] {
+			if kw, ok := o.Val.(keywordValue); ok && kw.name == truncateFlag {
+				truncate = true
+				continue
+			}
+			r, err := charOperand(symbol, o)
+			if err != nil {
+				retVal.Err = err
+				return retVal
+			}
+			padChar = r
+		}
+
+		runes := []rune(strVal.contents())
+		n := int(width.value)
+		switch {
+		case len(runes) > n && truncate:
+			runes = runes[:n]
+		case len(runes) < n:
+			padding := []rune(strings.Repeat(string(padChar), n-len(runes)))
+			if atLeft {
+				runes = append(padding, runes...)
+			} else {
+				runes = append(runes, padding...)
+			}
+		}
+		// The padded string is written with the same quotes as the original.
+		quote := strVal.value[:1]
+		retVal.Val = stringValue{quote + string(runes) + quote}
+		return retVal
+	}
+}
+
+func addStringOperators(opMap map[string]*Operator) {
+	// Replaces every ${expr} in the template with the result of evaluating expr
+	// in the current environment. \${ results in a literal ${.
+	addOperator(opMap,
+		&Operator{
+			symbol:      interp,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				template, ok := operands[0].Val.(stringValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a string",
+						interp, operands[0].Val.Str()))
+					return retVal
+				}
+
+				var buffer bytes.Buffer
+				rest := template.contents()
+				for len(rest) > 0 {
+					if strings.HasPrefix(rest, "\\"+interpOpen) {
+						buffer.WriteString(interpOpen)
+						rest = rest[len(interpOpen)+1:]
+						continue
+					}
+					if !strings.HasPrefix(rest, interpOpen) {
+						buffer.WriteByte(rest[0])
+						rest = rest[1:]
+						continue
+					}
+
+					rest = rest[len(interpOpen):]
+					end := placeholderEnd(rest)
+					if end < 0 {
+						retVal.Err = errors.New(fmt.Sprintf("Unterminated placeholder in %s template %s",
+							interp, template.Str()))
+						return retVal
+					}
+					text, err := interpolate(env, rest[:end])
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					buffer.WriteString(text)
+					rest = rest[end+1:]
+				}
+				retVal.Val = newStringValue(buffer.String())
+				return retVal
+			},
+		},
+	)
+
+	// Returns the list of characters in the string.
+	addOperator(opMap,
+		&Operator{
+			symbol:      stringToList,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				strVal, ok := operands[0].Val.(stringValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a string",
+						stringToList, operands[0].Val.Str()))
+					return retVal
+				}
+				values := make([]Value, 0)
+				for _, r := range strVal.contents() {
+					values = append(values, newCharValue(r))
+				}
+				retVal.Val = newListValue(values)
+				return retVal
+			},
+		},
+	)
+
+	// Returns the string made up of the characters in the list.
+	addOperator(opMap,
+		&Operator{
+			symbol:      listToString,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[0].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						listToString, operands[0].Val.Str()))
+					return retVal
+				}
+				var buffer bytes.Buffer
+				for _, v := range listVal.values {
+					r, err := charOperand(listToString, Atom{Val: v})
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					buffer.WriteRune(r)
+				}
+				retVal.Val = newStringValue(buffer.String())
+				return retVal
+			},
+		},
+	)
+
+	// Pad the string to the given width, like (pad-left "7" 3 #\0), which
+	// results in "007", for aligning the columns of a table.
+	addOperator(opMap,
+		&Operator{
+			symbol:      padLeft,
+			minArgCount: 2,
+			maxArgCount: 4,
+			handler:     padHandler(padLeft, true),
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      padRight,
+			minArgCount: 2,
+			maxArgCount: 4,
+			handler:     padHandler(padRight, false),
+		},
+	)
+
+	// Returns the string repeated count times, like (repeat "-" 10) for a
+	// separator. The result can be at most maxRepeatBytes long.
+	addOperator(opMap,
+		&Operator{
+			symbol:      repeat,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				strVal, ok := operands[0].Val.(stringValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a string",
+						repeat, operands[0].Val.Str()))
+					return retVal
+				}
+				count, ok := operands[1].Val.(intValue)
+				if !ok || count.value < 0 {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative integer",
+						repeat, operands[1].Val.Str()))
+					return retVal
+				}
+				if n := int64(len(strVal.contents())); n > 0 && count.value > maxRepeatBytes/n {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, repeating %s %d times results in a string longer than %d bytes",
+						repeat, strVal.Str(), count.value, maxRepeatBytes))
+					return retVal
+				}
+				quote := strVal.value[:1]
+				retVal.Val = stringValue{quote + strings.Repeat(strVal.contents(), int(count.value)) + quote}
+				return retVal
+			},
+		},
+	)
+
+	// Check whether the first string starts or ends with the second one. As
+	// strings are UTF-8, matching their bytes only ever matches whole characters.
+	affixPredicates := map[string]func(string, string) bool{
+		startsWith: strings.HasPrefix,
+		endsWith:   strings.HasSuffix,
+	}
+	for symbol, predicate := range affixPredicates {
+		symbol, predicate := symbol, predicate
+		addOperator(opMap,
+			&Operator{
+				symbol:      symbol,
+				minArgCount: 2,
+				maxArgCount: 2,
+				handler: func(env *LangEnv, operands []Atom) Atom {
+					var retVal Atom
+					str, err := stringOperand(symbol, operands[0])
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					affix, err := stringOperand(symbol, operands[1])
+					if err != nil {
+						retVal.Err = err
+						return retVal
+					}
+					var val boolValue
+					val.value = predicate(str, affix)
+					retVal.Val = val
+					return retVal
+				},
+			},
+		)
+	}
+}
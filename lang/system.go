@@ -0,0 +1,165 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	commandLineArgs string = "command-line-args"
+	getenv          string = "getenv"
+	setenv          string = "setenv"
+	now             string = "now"
+	formatTime      string = "format-time"
+	sleep           string = "sleep"
+)
+
+func addSystemOperators(opMap map[string]*Operator) {
+	// Returns the list of the arguments passed after the script.
+	addOperator(opMap,
+		&Operator{
+			symbol:      commandLineArgs,
+			minArgCount: 0,
+			maxArgCount: 0,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				args := make([]Value, len(env.args))
+				for i, arg := range env.args {
+					args[i] = newStringValue(arg)
+				}
+				retVal.Val = newListValue(args)
+				return retVal
+			},
+		},
+	)
+
+	// Returns the value of the environment variable, or nil if it is not set.
+	addOperator(opMap,
+		&Operator{
+			symbol:      getenv,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				if retVal.Err = checkNotSandboxed(env, getenv); retVal.Err != nil {
+					return retVal
+				}
+				name, err := stringOperand(getenv, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				value, ok := os.LookupEnv(name)
+				if !ok {
+					retVal.Val = newNilValue()
+					return retVal
+				}
+				retVal.Val = newStringValue(value)
+				return retVal
+			},
+		},
+	)
+
+	// Sets the environment variable for the rest of the process, and returns nil.
+	addOperator(opMap,
+		&Operator{
+			symbol:      setenv,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				if retVal.Err = checkNotSandboxed(env, setenv); retVal.Err != nil {
+					return retVal
+				}
+				name, err := stringOperand(setenv, operands[0])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				value, err := stringOperand(setenv, operands[1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				if retVal.Err = os.Setenv(name, value); retVal.Err != nil {
+					return retVal
+				}
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+
+	// Returns the current Unix timestamp, in seconds.
+	addOperator(opMap,
+		&Operator{
+			symbol:      now,
+			minArgCount: 0,
+			maxArgCount: 0,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				var val intValue
+				val.value = time.Now().Unix()
+				retVal.Val = val
+				return retVal
+			},
+		},
+	)
+
+	// Formats the Unix timestamp in UTC, using a layout like the ones of Go's
+	// time package, which are written for the time 2006-01-02 15:04:05.
+	addOperator(opMap,
+		&Operator{
+			symbol:      formatTime,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				timestamp, ok := operands[0].Val.(intValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a timestamp",
+						formatTime, operands[0].Val.Str()))
+					return retVal
+				}
+				layout, err := stringOperand(formatTime, operands[1])
+				if err != nil {
+					retVal.Err = err
+					return retVal
+				}
+				formatted := time.Unix(timestamp.value, 0).UTC().Format(layout)
+				retVal.Val = newStringValue(formatted)
+				return retVal
+			},
+		},
+	)
+
+	// Pauses for the number of seconds, which can be fractional, and returns nil.
+	addOperator(opMap,
+		&Operator{
+			symbol:      sleep,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				seconds := -1.0
+				switch v := operands[0].Val.(type) {
+				case intValue:
+					seconds = float64(v.value)
+				case floatValue:
+					seconds = v.value
+				}
+				// Also rejects nan.
+				if !(seconds >= 0) {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a non-negative number of seconds",
+						sleep, operands[0].Val.Str()))
+					return retVal
+				}
+				time.Sleep(time.Duration(seconds * float64(time.Second)))
+				retVal.Val = newNilValue()
+				return retVal
+			},
+		},
+	)
+}
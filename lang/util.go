@@ -1,28 +1,276 @@
 package lang
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strconv"
+	"strings"
 )
 
-func checkArgTypes(operatorName string, operands *[]Atom, allowedTypes []valueType) (map[valueType]int, error) {
-	typesFound := make(map[valueType]int, 0)
+func checkArgTypes(operatorName string, operands *[]Atom, typePrecendenceMap map[valueType]int) error {
 	for _, operand := range *operands {
-		exists := false
-		typesFound[operand.Val.getValueType()]++
-		for _, t := range allowedTypes {
-			if operand.Val.getValueType() == t {
-				exists = true
-				break
-			}
-		}
-		if !exists {
-			return nil, errors.New(
+		if _, exists := typePrecendenceMap[operand.Val.getValueType()]; !exists {
+			return errors.New(
 				fmt.Sprintf("For operator %s, operand %s is of unexpected type: %s.",
 					operatorName, operand.Val.Str(), operand.Val.getValueType()))
 		}
 	}
-	return typesFound, nil
+	return nil
+}
+
+// Only false and nil are falsey, every other value is truthy.
+func isTruthy(v Value) bool {
+	switch v.getValueType() {
+	case boolType:
+		b, _ := v.(boolValue)
+		return b.value
+	case nilType:
+		return false
+	}
+	return true
+}
+
+// Returns true if both values are of the same type and print the same. Lists
+// are equal if their elements are equal, pairwise, and strings if their
+// characters are, regardless of the quotes they were written with.
+func isEqual(a, b Value) bool {
+	// Cons cells are equal to the lists with the same elements. Lists of
+	// different lengths are told apart without converting them.
+	aLen, aIsList := listLength(a)
+	bLen, bIsList := listLength(b)
+	if aIsList && bIsList && aLen != bLen {
+		return false
+	}
+	a, b = consToList(a), consToList(b)
+	aBlob, aIsBlob := a.(blobValue)
+	bBlob, bIsBlob := b.(blobValue)
+	if aIsBlob && bIsBlob {
+		return bytes.Equal(aBlob.data, bBlob.data)
+	}
+	aStr, aIsStr := a.(stringValue)
+	bStr, bIsStr := b.(stringValue)
+	if aIsStr && bIsStr {
+		return aStr.contents() == bStr.contents()
+	}
+	aList, aIsList := a.(listValue)
+	bList, bIsList := b.(listValue)
+	if aIsList && bIsList {
+		if len(aList.values) != len(bList.values) {
+			return false
+		}
+		for i := range aList.values {
+			if !isEqual(aList.values[i], bList.values[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	aVec, aIsVec := a.(vectorValue)
+	bVec, bIsVec := b.(vectorValue)
+	if aIsVec && bIsVec {
+		return isEqual(newListValue(aVec.values), newListValue(bVec.values))
+	}
+	aMap, aIsMap := a.(mapValue)
+	bMap, bIsMap := b.(mapValue)
+	if aIsMap && bIsMap {
+		if len(aMap.entries) != len(bMap.entries) {
+			return false
+		}
+		for k, entry := range aMap.entries {
+			other, ok := bMap.entries[k]
+			if !ok || !isEqual(entry.value, other.value) {
+				return false
+			}
+		}
+		return true
+	}
+	aRec, aIsRec := a.(recordValue)
+	bRec, bIsRec := b.(recordValue)
+	if aIsRec && bIsRec {
+		// Field names cannot contain spaces, so they can be compared joined.
+		if aRec.name != bRec.name || strings.Join(aRec.fields, " ") != strings.Join(bRec.fields, " ") {
+			return false
+		}
+		return isEqual(newListValue(aRec.values), newListValue(bRec.values))
+	}
+	aSet, aIsSet := a.(setValue)
+	bSet, bIsSet := b.(setValue)
+	if aIsSet && bIsSet {
+		if len(aSet.elements) != len(bSet.elements) {
+			return false
+		}
+		for k := range aSet.elements {
+			if _, ok := bSet.elements[k]; !ok {
+				return false
+			}
+		}
+		return true
+	}
+	if aCell, ok := cellOf(a); ok {
+		bCell, ok := cellOf(b)
+		return ok && aCell == bCell
+	}
+	return a.getValueType() == b.getValueType() && a.Str() == b.Str()
+}
+
+// Returns the state shared by the copies of a value which can change, like an
+// atom. Such values are only equal to the copies of themselves, whatever
+// they hold.
+func cellOf(v Value) (interface{}, bool) {
+	switch val := v.(type) {
+	case atomValue:
+		return val.a, true
+	case promiseValue:
+		return val.p, true
+	case futureValue:
+		return val.f, true
+	case channelValue:
+		return val.c, true
+	}
+	return nil, false
+}
+
+// Returns a string which is the same for values which are equal?, and differs
+// otherwise. It is used to look up values in maps.
+func hashKey(v Value) string {
+	switch val := v.(type) {
+	case consValue:
+		return hashKey(consToList(val))
+	case stringValue:
+		return fmt.Sprintf("%s:%q", stringType, val.contents())
+	case blobValue:
+		return fmt.Sprintf("%s:%x", blobType, val.data)
+	case listValue:
+		keys := make([]string, len(val.values))
+		for i, elem := range val.values {
+			keys[i] = hashKey(elem)
+		}
+		return fmt.Sprintf("%s:(%s)", listType, strings.Join(keys, " "))
+	case vectorValue:
+		keys := make([]string, len(val.values))
+		for i, elem := range val.values {
+			keys[i] = hashKey(elem)
+		}
+		return fmt.Sprintf("%s:[%s]", vectorType, strings.Join(keys, " "))
+	case recordValue:
+		keys := make([]string, len(val.fields))
+		for i, field := range val.fields {
+			keys[i] = field + " " + hashKey(val.values[i])
+		}
+		return fmt.Sprintf("%s:#%s{%s}", recordType, val.name, strings.Join(keys, ", "))
+	case mapValue:
+		keys := make([]string, 0, len(val.entries))
+		for k, entry := range val.entries {
+			keys = append(keys, k+" "+hashKey(entry.value))
+		}
+		sort.Strings(keys)
+		return fmt.Sprintf("%s:{%s}", mapType, strings.Join(keys, ", "))
+	case setValue:
+		keys := make([]string, 0, len(val.elements))
+		for k := range val.elements {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		return fmt.Sprintf("%s:{%s}", setType, strings.Join(keys, " "))
+	}
+	if cell, ok := cellOf(v); ok {
+		return fmt.Sprintf("%s:%p", v.getValueType(), cell)
+	}
+	return fmt.Sprintf("%s:%s", v.getValueType(), v.Str())
+}
+
+// Returns true if both values are the same value. Lists are only identical to
+// themselves, i.e. when they share their elements, like a list and the variable
+// it was bound to, and so are cons cells. Other values can not be told apart
+// when they are equal, so they are identical if they are equal.
+func isIdentical(a, b Value) bool {
+	aCons, aIsCons := a.(consValue)
+	bCons, bIsCons := b.(consValue)
+	if aIsCons || bIsCons {
+		return aIsCons && bIsCons && aCons.cell == bCons.cell
+	}
+	aList, aIsList := a.(listValue)
+	bList, bIsList := b.(listValue)
+	if aIsList || bIsList {
+		if !aIsList || !bIsList || len(aList.values) != len(bList.values) {
+			return false
+		}
+		return len(aList.values) == 0 || &aList.values[0] == &bList.values[0]
+	}
+	return isEqual(a, b)
+}
+
+// Returns the largest precision amongst the big float operands.
+func maxBigFloatPrec(operands []Atom) uint {
+	var prec uint
+	for _, o := range operands {
+		if v, ok := o.Val.(bigFloatValue); ok && v.value.Prec() > prec {
+			prec = v.value.Prec()
+		}
+	}
+	return prec
+}
+
+// Returns true if v is a number equal to zero.
+func isZero(v Value) bool {
+	switch val := v.(type) {
+	case intValue:
+		return val.value == 0
+	case bigIntValue:
+		return val.value.Sign() == 0
+	case floatValue:
+		return val.value == 0
+	case bigFloatValue:
+		return val.value.Sign() == 0
+	}
+	return false
+}
+
+// Returns the exact decimal value of a number as a rational. Floats are taken
+// at their shortest decimal representation, so 2.675 is exactly 2.675.
+func toRat(v Value) (*big.Rat, error) {
+	var str string
+	switch val := v.(type) {
+	case intValue, bigIntValue, bigFloatValue:
+		str = val.Str()
+	case floatValue:
+		if math.IsNaN(val.value) || math.IsInf(val.value, 0) {
+			return nil, errors.New(fmt.Sprintf("Cannot convert %s to a decimal", val.Str()))
+		}
+		str = strconv.FormatFloat(val.value, 'g', -1, 64)
+	default:
+		return nil, typeConvError(v.getValueType(), "decimal")
+	}
+	r, ok := new(big.Rat).SetString(str)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("Cannot convert %s to a decimal", str))
+	}
+	return r, nil
+}
+
+// Rounds r to the given number of decimal places. Ties are rounded to the
+// nearest even digit (banker's rounding).
+func roundHalfEven(r *big.Rat, places int64) *big.Rat {
+	scale := new(big.Int).Exp(big.NewInt(10), big.NewInt(places), nil)
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt(scale))
+
+	q, rem := new(big.Int).QuoRem(scaled.Num(), scaled.Denom(), new(big.Int))
+	// Compare the discarded fraction against one half.
+	twiceRem := new(big.Int).Abs(rem)
+	twiceRem.Lsh(twiceRem, 1)
+	c := twiceRem.Cmp(scaled.Denom())
+	if c > 0 || (c == 0 && q.Bit(0) == 1) {
+		if scaled.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return new(big.Rat).SetFrac(q, scale)
 }
 
 func pop(tokens []string) (string, []string) {
@@ -36,55 +284,47 @@ func pop(tokens []string) (string, []string) {
 func tryTypeCastTo(operands *[]Atom, finalType valueType) error {
 	for i := 0; i < len(*operands); i++ {
 		if (*operands)[i].Val.getValueType() != finalType {
-			var err error
-			(*operands)[i].Val, err = (*operands)[i].Val.to(finalType)
+			val, err := (*operands)[i].Val.to(finalType)
 
 			if err != nil {
 				return err
 			}
+			(*operands)[i].Val = val
 		}
 	}
 	return nil
 }
 
+// This is done for every arithmetic operation, so it does not allocate unless
+// some operands have to be cast.
 // Algorithm:
-// 1. We get the type -> count mapping.
+// 1. We check that all the operands are of the allowed types.
 // 2. If there is only one type, there is nothing to do.
 // 3. If there are multiple, pick the one with the highest precedence.
 // 4. Try and cast all operand values to that type. Error out if any of them
 //    resists. Because, resistance is futile.
 func typeCoerce(operatorName string, operands *[]Atom, typePrecendenceMap map[valueType]int) (valueType, error) {
-	var err error
-	var typesCountMap map[valueType]int
-	allowedTypes := make([]valueType, len(typePrecendenceMap))
-	typeIdx := 0
-	for vtype, _ := range typePrecendenceMap {
-		allowedTypes[typeIdx] = vtype
-		typeIdx++
-	}
-
-	typesCountMap, err = checkArgTypes(operatorName, operands, allowedTypes)
+	err := checkArgTypes(operatorName, operands, typePrecendenceMap)
 	if err != nil {
 		return "", err
 	}
 
-	if len(typesCountMap) == 1 {
-		valType := (*operands)[0].Val.getValueType()
-		return valType, nil
-	}
-
 	var finalType valueType
 	finalTypePrecedence := -1
-	for t, c := range typesCountMap {
-		if c <= 0 {
-			continue
+	mixedTypes := false
+	for _, operand := range *operands {
+		t := operand.Val.getValueType()
+		if finalTypePrecedence >= 0 && t != finalType {
+			mixedTypes = true
 		}
-		precedence := typePrecendenceMap[t]
-		if precedence > finalTypePrecedence {
+		if precedence := typePrecendenceMap[t]; precedence > finalTypePrecedence {
 			finalType = t
 			finalTypePrecedence = precedence
 		}
 	}
+	if !mixedTypes {
+		return finalType, nil
+	}
 
 	err = tryTypeCastTo(operands, finalType)
 	if err != nil {
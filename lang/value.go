@@ -3,9 +3,14 @@ package lang
 import (
 	"errors"
 	"fmt"
+	"math"
 	"math/big"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 )
 
 // Different types of values supported
@@ -13,13 +18,35 @@ type valueType interface{}
 
 const (
 	// Value type
-	stringType = "stringType"
-	intType    = "intType"
-	bigIntType = "bigIntType"
-	floatType  = "floatType"
-	varType    = "varType"
-	boolType   = "boolType"
-	astType    = "astType"
+	stringType   = "stringType"
+	intType      = "intType"
+	bigIntType   = "bigIntType"
+	floatType    = "floatType"
+	bigFloatType = "bigFloatType"
+	varType      = "varType"
+	boolType     = "boolType"
+	astType      = "astType"
+	nilType      = "nilType"
+	keywordType  = "keywordType"
+	listType     = "listType"
+	valuesType   = "valuesType"
+	charType     = "charType"
+	mapType      = "mapType"
+	setType      = "setType"
+	errorType    = "errorType"
+	promiseType  = "promiseType"
+	streamType   = "streamType"
+	funcType     = "funcType"
+	recurType    = "recurType"
+	vectorType   = "vectorType"
+	consType     = "consType"
+	symbolType   = "symbolType"
+	futureType   = "futureType"
+	channelType  = "channelType"
+	atomType     = "atomType"
+	enumType     = "enumType"
+	recordType   = "recordType"
+	blobType     = "blobType"
 )
 
 type Value interface {
@@ -35,11 +62,11 @@ func getVarValue(env *LangEnv, varVal Value) (Value, error) {
 		varTypeVal, _ := varVal.(varValue)
 		varName := varTypeVal.varName
 
-		val := env.varMap[varName]
+		val := env.getValue(varName)
 		if val != nil {
 			return val, nil
 		}
-		opVal := env.opMap[varName]
+		opVal := env.getOperator(varName)
 		if opVal != nil {
 			return varVal, nil
 		}
@@ -53,8 +80,7 @@ func getVarValue(env *LangEnv, varVal Value) (Value, error) {
 // 2. Pick the highest value type that complies.
 // 3. Return that value type.
 func getValue(env *LangEnv, token string) (Value, error) {
-	types := builtinTypes()
-	for _, t := range types {
+	for _, t := range env.valueTypes() {
 		if t.ofType(token) {
 			return t.newValue(token), nil
 		}
@@ -95,8 +121,23 @@ func (v stringValue) to(targetType valueType) (Value, error) {
 	return nil, typeConvError(v.getValueType(), targetType)
 }
 
+// Returns the string as a literal, which is read back as the same string. The
+// quotes within it are escaped, and so are the backslashes which would be read
+// as an escape otherwise.
 func (v stringValue) Str() string {
-	return v.value
+	quote := v.value[0]
+	contents := v.value[1 : len(v.value)-1]
+	var b strings.Builder
+	b.WriteByte(quote)
+	for i := 0; i < len(contents); i++ {
+		c := contents[i]
+		if c == quote || (c == '\\' && (i+1 == len(contents) || contents[i+1] == '\\' || contents[i+1] == quote)) {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte(quote)
+	return b.String()
 }
 
 func (v stringValue) ofType(targetValue string) bool {
@@ -104,18 +145,50 @@ func (v stringValue) ofType(targetValue string) bool {
 	if valLen < 2 {
 		return false
 	}
-	// TODO
-	// Stricter checks for quotes inside strings, like ''' should not be valid.
 	f, l := targetValue[0], targetValue[valLen-1]
-	if (f == '\'' && l == '\'') || (f == '"' && l == '"') {
-		return true
+	if (f != '\'' && f != '"') || l != f {
+		return false
 	}
-	return false
+	// The quote character can only appear within the string when it is escaped,
+	// so ''' and "a"b" are not valid, while "a\"b" is.
+	for i := 1; i < valLen-1; i++ {
+		if targetValue[i] == '\\' {
+			i++
+			// The closing quote is escaped, like in "abc\".
+			if i == valLen-1 {
+				return false
+			}
+		} else if targetValue[i] == f {
+			return false
+		}
+	}
+	return true
 }
 
+// Reads the string literal, in which \\ and the escaped quote stand for a
+// backslash and the quote. Other escapes, like \$ in interp templates, are
+// kept as they are.
 func (v stringValue) newValue(str string) Value {
+	quote := str[0]
+	var b strings.Builder
+	b.WriteByte(quote)
+	for i := 1; i < len(str)-1; i++ {
+		if str[i] == '\\' && i+1 < len(str)-1 && (str[i+1] == '\\' || str[i+1] == quote) {
+			i++
+		}
+		b.WriteByte(str[i])
+	}
+	b.WriteByte(quote)
 	var val stringValue
-	val.value = str
+	val.value = b.String()
+	return val
+}
+
+// Returns the string with the given contents, which are taken as they are,
+// unlike those of a literal.
+func newStringValue(contents string) stringValue {
+	var val stringValue
+	val.value = "\"" + contents + "\""
 	return val
 }
 
@@ -140,15 +213,36 @@ func (v intValue) to(targetType valueType) (Value, error) {
 		var val floatValue
 		val.value = float64(v.value)
 		return val, nil
+	case bigFloatType:
+		var val bigFloatValue
+		val.value = new(big.Float).SetPrec(defaultBigFloatPrec).SetInt64(v.value)
+		return val, nil
 	}
 	return nil, typeConvError(v.getValueType(), targetType)
 }
 
+// Integers can also be written in an explicit radix between 2 and 36, as
+// <radix>r<digits>, like 16r1F or 2r1010.
+var radixLiteralRegexp = regexp.MustCompile("^([+-]?)([0-9]+)r([0-9a-zA-Z]+)$")
+
+func parseRadixLiteral(str string) (*big.Int, bool) {
+	matches := radixLiteralRegexp.FindStringSubmatch(str)
+	if matches == nil {
+		return nil, false
+	}
+	radix, err := strconv.Atoi(matches[2])
+	if err != nil || radix < 2 || radix > 36 {
+		return nil, false
+	}
+	return new(big.Int).SetString(matches[1]+matches[3], radix)
+}
+
 func (v intValue) ofType(targetValue string) bool {
 	_, err := strconv.ParseInt(targetValue, 0, 64)
 	if err != nil {
 		// fmt.Printf("Error processing %s: %s", targetValue, err)
-		return false
+		radixVal, ok := parseRadixLiteral(targetValue)
+		return ok && radixVal.IsInt64()
 	}
 	return true
 }
@@ -160,7 +254,11 @@ func (v intValue) Str() string {
 func (v intValue) newValue(str string) Value {
 	intVal, err := strconv.ParseInt(str, 0, 64)
 	if err != nil {
-		return nil
+		radixVal, ok := parseRadixLiteral(str)
+		if !ok || !radixVal.IsInt64() {
+			return nil
+		}
+		intVal = radixVal.Int64()
 	}
 	var val intValue
 	val.value = intVal
@@ -188,6 +286,10 @@ func (v bigIntValue) to(targetType valueType) (Value, error) {
 		}
 		// An alternate way would be to check if the bigInt is either smaller than
 		// the smallest value of int64, or larger than the largest value of int64.
+	case bigFloatType:
+		var val bigFloatValue
+		val.value = new(big.Float).SetPrec(defaultBigFloatPrec).SetInt(v.value)
+		return val, nil
 	}
 	return nil, typeConvError(v.getValueType(), targetType)
 }
@@ -197,6 +299,9 @@ func (v bigIntValue) ofType(targetValue string) bool {
 	bigIntVal := new(big.Int)
 	var ok bool
 	bigIntVal, ok = bigIntVal.SetString(targetValue, 0)
+	if !ok {
+		_, ok = parseRadixLiteral(targetValue)
+	}
 	return ok
 }
 
@@ -208,6 +313,9 @@ func (v bigIntValue) newValue(str string) Value {
 	bigIntVal := new(big.Int)
 	var ok bool
 	bigIntVal, ok = bigIntVal.SetString(str, 0)
+	if !ok {
+		bigIntVal, ok = parseRadixLiteral(str)
+	}
 	if !ok {
 		fmt.Printf("There was an error!\n")
 		return nil
@@ -218,6 +326,18 @@ func (v bigIntValue) newValue(str string) Value {
 	return val
 }
 
+// Returns an intValue if n fits in an int64, and a bigIntValue otherwise.
+func newIntegerValue(n *big.Int) Value {
+	if n.IsInt64() {
+		var val intValue
+		val.value = n.Int64()
+		return val
+	}
+	var val bigIntValue
+	val.value = n
+	return val
+}
+
 type floatValue struct {
 	value float64
 }
@@ -230,26 +350,60 @@ func (v floatValue) to(targetType valueType) (Value, error) {
 	switch targetType {
 	case floatType:
 		return v, nil
+	case bigFloatType:
+		if math.IsNaN(v.value) || math.IsInf(v.value, 0) {
+			break
+		}
+		// Going through the shortest decimal representation means that 0.1
+		// becomes 0.1, and not the binary approximation that float64 stores.
+		var val bigFloatValue
+		val.value, _, _ = big.ParseFloat(strconv.FormatFloat(v.value, 'g', -1, 64),
+			10, defaultBigFloatPrec, big.ToNearestEven)
+		return val, nil
 	}
 	return nil, typeConvError(v.getValueType(), targetType)
 }
 
-func (v floatValue) ofType(targetValue string) bool {
-	_, err := strconv.ParseFloat(targetValue, 64)
-	if err != nil {
+// The special float values can only be written as nan, inf and -inf.
+// Spellings like NaN or Infinity, which strconv accepts, are identifiers.
+var specialFloatLiterals = map[string]float64{
+	"nan":  math.NaN(),
+	"inf":  math.Inf(1),
+	"-inf": math.Inf(-1),
+}
+
+func parseFloatLiteral(str string) (float64, bool) {
+	if f, ok := specialFloatLiterals[str]; ok {
+		return f, true
+	}
+	f, err := strconv.ParseFloat(str, 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
 		// fmt.Printf("Error processing %s: %s", targetValue, err)
-		return false
+		return 0, false
 	}
-	return true
+	return f, true
+}
+
+func (v floatValue) ofType(targetValue string) bool {
+	_, ok := parseFloatLiteral(targetValue)
+	return ok
 }
 
 func (v floatValue) Str() string {
+	switch {
+	case math.IsNaN(v.value):
+		return "nan"
+	case math.IsInf(v.value, 1):
+		return "inf"
+	case math.IsInf(v.value, -1):
+		return "-inf"
+	}
 	return strconv.FormatFloat(v.value, 'g', -1, 64)
 }
 
 func (v floatValue) newValue(str string) Value {
-	floatVal, err := strconv.ParseFloat(str, 64)
-	if err != nil {
+	floatVal, ok := parseFloatLiteral(str)
+	if !ok {
 		return nil
 	}
 	var val floatValue
@@ -257,6 +411,65 @@ func (v floatValue) newValue(str string) Value {
 	return val
 }
 
+// The precision (in bits) of big floats, unless configured otherwise.
+const defaultBigFloatPrec uint = 256
+
+// Arbitrary precision floats are written with an M suffix, like 2.675M.
+type bigFloatValue struct {
+	value *big.Float
+	// The precision with which literals are parsed.
+	prec uint
+}
+
+func (v bigFloatValue) getValueType() valueType {
+	return bigFloatType
+}
+
+func (v bigFloatValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case bigFloatType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+func (v bigFloatValue) parse(str string) (*big.Float, bool) {
+	if !strings.HasSuffix(str, "M") {
+		return nil, false
+	}
+	prec := v.prec
+	if prec == 0 {
+		prec = defaultBigFloatPrec
+	}
+	f, _, err := big.ParseFloat(strings.TrimSuffix(str, "M"), 10, prec, big.ToNearestEven)
+	if err != nil || f.IsInf() {
+		return nil, false
+	}
+	return f, true
+}
+
+func (v bigFloatValue) ofType(targetValue string) bool {
+	_, ok := v.parse(targetValue)
+	return ok
+}
+
+// Renders the value with a couple of digits less than what the precision
+// allows for, so that the binary rounding errors do not show up.
+func (v bigFloatValue) Str() string {
+	digits := int(float64(v.value.Prec())*math.Log10(2)) - 2
+	return v.value.Text('g', digits)
+}
+
+func (v bigFloatValue) newValue(str string) Value {
+	f, ok := v.parse(str)
+	if !ok {
+		return nil
+	}
+	var val bigFloatValue
+	val.value = f
+	return val
+}
+
 type varValue struct {
 	value   string
 	varName string
@@ -270,12 +483,10 @@ func (v varValue) to(targetType valueType) (Value, error) {
 	return nil, typeConvError(v.getValueType(), targetType)
 }
 
+// Names have to contain a letter, from any script, which sets them apart from
+// numbers and from operators made up of symbols, like + and <=.
 func (v varValue) ofType(targetValue string) bool {
-	matched, err := regexp.MatchString("[a-zA-Z]+[a-zA-Z0-9]*", targetValue)
-	if matched == false || err != nil {
-		return false
-	}
-	return true
+	return strings.IndexFunc(targetValue, unicode.IsLetter) >= 0
 }
 
 func (v varValue) Str() string {
@@ -332,6 +543,963 @@ func newBoolValue(b bool) Value {
 	return val
 }
 
+type nilValue struct{}
+
+func (v nilValue) getValueType() valueType {
+	return nilType
+}
+
+func (v nilValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case nilType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+func (v nilValue) ofType(targetValue string) bool {
+	return targetValue == "nil"
+}
+
+func (v nilValue) Str() string {
+	return "nil"
+}
+
+func (v nilValue) newValue(str string) Value {
+	return nilValue{}
+}
+
+func newNilValue() Value {
+	return nilValue{}
+}
+
+type keywordValue struct {
+	name string
+}
+
+func (v keywordValue) getValueType() valueType {
+	return keywordType
+}
+
+func (v keywordValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case keywordType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+func (v keywordValue) ofType(targetValue string) bool {
+	if len(targetValue) < 2 || targetValue[0] != ':' {
+		return false
+	}
+	return new(varValue).ofType(targetValue[1:])
+}
+
+func (v keywordValue) Str() string {
+	return ":" + v.name
+}
+
+func (v keywordValue) newValue(str string) Value {
+	var val keywordValue
+	val.name = str[1:]
+	return val
+}
+
+type charValue struct {
+	value rune
+}
+
+// Precedes the character in a character literal, like #\a.
+const charPrefix = "#\\"
+
+// Characters which are written using their names, since they are either
+// invisible or would be split off by the tokenizer.
+var namedChars = map[string]rune{
+	"space":   ' ',
+	"newline": '\n',
+	"tab":     '\t',
+}
+
+func (v charValue) getValueType() valueType {
+	return charType
+}
+
+func (v charValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case charType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+func (v charValue) ofType(targetValue string) bool {
+	if !strings.HasPrefix(targetValue, charPrefix) {
+		return false
+	}
+	name := targetValue[len(charPrefix):]
+	if _, ok := namedChars[name]; ok {
+		return true
+	}
+	return utf8.RuneCountInString(name) == 1
+}
+
+func (v charValue) Str() string {
+	for name, r := range namedChars {
+		if r == v.value {
+			return charPrefix + name
+		}
+	}
+	return charPrefix + string(v.value)
+}
+
+func (v charValue) newValue(str string) Value {
+	name := str[len(charPrefix):]
+	if r, ok := namedChars[name]; ok {
+		return newCharValue(r)
+	}
+	r, _ := utf8.DecodeRuneInString(name)
+	return newCharValue(r)
+}
+
+func newCharValue(r rune) Value {
+	var val charValue
+	val.value = r
+	return val
+}
+
+type listValue struct {
+	values []Value
+}
+
+func (v listValue) getValueType() valueType {
+	return listType
+}
+
+func (v listValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case listType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Lists do not have a literal form, they are created using the list operator.
+func (v listValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v listValue) Str() string {
+	return v.str(nil)
+}
+
+func (v listValue) str(visiting map[interface{}]bool) string {
+	return "(" + strings.Join(strsOf(v.values, visiting), " ") + ")"
+}
+
+func (v listValue) newValue(str string) Value {
+	return nil
+}
+
+func newListValue(values []Value) Value {
+	var val listValue
+	val.values = values
+	return val
+}
+
+// A list built using cons, which shares the list of the remaining elements with
+// the list it was built from. This makes prepending an element O(1). Operators
+// get the slice form of the list instead, unless they keep cons cells.
+type consValue struct {
+	cell *consCell
+}
+
+type consCell struct {
+	head Value
+	// Either another cons cell, or a list.
+	tail   Value
+	length int
+}
+
+func (v consValue) getValueType() valueType {
+	return consType
+}
+
+func (v consValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case consType:
+		return v, nil
+	case listType:
+		return consToList(v), nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+func (v consValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v consValue) Str() string {
+	return v.str(nil)
+}
+
+func (v consValue) str(visiting map[interface{}]bool) string {
+	return consToList(v).(listValue).str(visiting)
+}
+
+func (v consValue) newValue(str string) Value {
+	return nil
+}
+
+// Returns a cons cell with the head prepended to the tail, which has to be a
+// list or a cons cell.
+func newConsValue(head, tail Value) Value {
+	length := 1
+	switch t := tail.(type) {
+	case listValue:
+		length += len(t.values)
+	case consValue:
+		length += t.cell.length
+	}
+	return consValue{&consCell{head, tail, length}}
+}
+
+// Returns the number of elements in a list or a cons cell, without converting
+// the cons cell.
+func listLength(v Value) (int, bool) {
+	switch val := v.(type) {
+	case listValue:
+		return len(val.values), true
+	case consValue:
+		return val.cell.length, true
+	}
+	return 0, false
+}
+
+// Returns the slice form of a cons cell, or the value itself otherwise.
+func consToList(v Value) Value {
+	c, ok := v.(consValue)
+	if !ok {
+		return v
+	}
+	values := make([]Value, 0, c.cell.length)
+	for {
+		values = append(values, c.cell.head)
+		next, ok := c.cell.tail.(consValue)
+		if !ok {
+			break
+		}
+		c = next
+	}
+	values = append(values, c.cell.tail.(listValue).values...)
+	return newListValue(values)
+}
+
+// A sequence of values like a list, which is meant to be indexed rather than
+// built up element by element.
+type vectorValue struct {
+	values []Value
+}
+
+func (v vectorValue) getValueType() valueType {
+	return vectorType
+}
+
+func (v vectorValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case vectorType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Vectors do not have a literal form, they are created using list->vector.
+func (v vectorValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v vectorValue) Str() string {
+	return v.str(nil)
+}
+
+func (v vectorValue) str(visiting map[interface{}]bool) string {
+	return "[" + strings.Join(strsOf(v.values, visiting), " ") + "]"
+}
+
+func (v vectorValue) newValue(str string) Value {
+	return nil
+}
+
+func newVectorValue(values []Value) Value {
+	var val vectorValue
+	val.values = values
+	return val
+}
+
+// A name in an expression, like the + and x in (+ x 1), as seen by operators
+// which work on expressions rather than evaluating them. Unlike a variable, it
+// is never resolved.
+type symbolValue struct {
+	name string
+}
+
+func (v symbolValue) getValueType() valueType {
+	return symbolType
+}
+
+func (v symbolValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case symbolType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Symbols do not have a literal form, they are created using symbol.
+func (v symbolValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v symbolValue) Str() string {
+	return v.name
+}
+
+func (v symbolValue) newValue(str string) Value {
+	return nil
+}
+
+// One of the variants of an enum defined using defenum. Variants are only
+// equal to themselves, and not to the variants of other enums with the same
+// name, or to symbols and strings.
+type enumValue struct {
+	enum string
+	name string
+}
+
+func (v enumValue) getValueType() valueType {
+	return enumType
+}
+
+func (v enumValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case enumType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Variants do not have a literal form, they are defined using defenum.
+func (v enumValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v enumValue) Str() string {
+	return fmt.Sprintf("#%s.%s", v.enum, v.name)
+}
+
+func (v enumValue) newValue(str string) Value {
+	return nil
+}
+
+// An instance of a struct defined using defstruct, with a value for each of
+// its fields, in the order in which they were declared.
+type recordValue struct {
+	name   string
+	fields []string
+	values []Value
+}
+
+func (v recordValue) getValueType() valueType {
+	return recordType
+}
+
+func (v recordValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case recordType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Records do not have a literal form, they are created by the constructors
+// defined using defstruct.
+func (v recordValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v recordValue) Str() string {
+	return v.str(nil)
+}
+
+func (v recordValue) str(visiting map[interface{}]bool) string {
+	strs := make([]string, len(v.fields))
+	for i, field := range v.fields {
+		strs[i] = field + ": " + strOf(v.values[i], visiting)
+	}
+	return "#" + v.name + "{" + strings.Join(strs, ", ") + "}"
+}
+
+func (v recordValue) newValue(str string) Value {
+	return nil
+}
+
+// Returns the value of the field, and whether the record has it.
+func (v recordValue) get(field string) (Value, bool) {
+	for i, f := range v.fields {
+		if f == field {
+			return v.values[i], true
+		}
+	}
+	return nil, false
+}
+
+// A map from keys to values. Keys which are equal? refer to the same entry.
+type mapValue struct {
+	// The hash keys of the entries, in the order in which they were added.
+	order   []string
+	entries map[string]mapEntry
+}
+
+type mapEntry struct {
+	key   Value
+	value Value
+}
+
+func (v mapValue) getValueType() valueType {
+	return mapType
+}
+
+func (v mapValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case mapType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Maps do not have a literal form, they are created by operators.
+func (v mapValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v mapValue) Str() string {
+	return v.str(nil)
+}
+
+func (v mapValue) str(visiting map[interface{}]bool) string {
+	strs := make([]string, len(v.order))
+	for i, k := range v.order {
+		entry := v.entries[k]
+		strs[i] = strOf(entry.key, visiting) + ": " + strOf(entry.value, visiting)
+	}
+	return "{" + strings.Join(strs, ", ") + "}"
+}
+
+func (v mapValue) newValue(str string) Value {
+	return nil
+}
+
+func newMapValue() mapValue {
+	var val mapValue
+	val.order = make([]string, 0)
+	val.entries = make(map[string]mapEntry)
+	return val
+}
+
+// Returns the value for the key, and whether the map has it.
+func (v mapValue) get(key Value) (Value, bool) {
+	entry, ok := v.entries[hashKey(key)]
+	return entry.value, ok
+}
+
+// Sets the value for the key in place. Maps are immutable once they have been
+// returned, so this is only meant for the operators building them.
+func (v *mapValue) put(key, value Value) {
+	k := hashKey(key)
+	if _, ok := v.entries[k]; !ok {
+		v.order = append(v.order, k)
+	}
+	v.entries[k] = mapEntry{key, value}
+}
+
+// A set of values, none of which are equal? to each other.
+type setValue struct {
+	// The hash keys of the elements, in the order in which they were added.
+	order    []string
+	elements map[string]Value
+}
+
+func (v setValue) getValueType() valueType {
+	return setType
+}
+
+func (v setValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case setType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Sets do not have a literal form, they are created using make-set.
+func (v setValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v setValue) Str() string {
+	return v.str(nil)
+}
+
+func (v setValue) str(visiting map[interface{}]bool) string {
+	return "#{" + strings.Join(strsOf(v.values(), visiting), " ") + "}"
+}
+
+func (v setValue) newValue(str string) Value {
+	return nil
+}
+
+func newSetValue() setValue {
+	var val setValue
+	val.order = make([]string, 0)
+	val.elements = make(map[string]Value)
+	return val
+}
+
+func (v setValue) has(elem Value) bool {
+	_, ok := v.elements[hashKey(elem)]
+	return ok
+}
+
+// Adds the element in place, if the set does not have it already. Like with
+// maps, this is only meant for the operators building sets.
+func (v *setValue) add(elem Value) {
+	k := hashKey(elem)
+	if _, ok := v.elements[k]; !ok {
+		v.order = append(v.order, k)
+		v.elements[k] = elem
+	}
+}
+
+// Returns the elements of the set, in order.
+func (v setValue) values() []Value {
+	values := make([]Value, len(v.order))
+	for i, k := range v.order {
+		values[i] = v.elements[k]
+	}
+	return values
+}
+
+// An error which was caught, so that it can be handled like any other value.
+type errorValue struct {
+	err error
+}
+
+func (v errorValue) getValueType() valueType {
+	return errorType
+}
+
+func (v errorValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case errorType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Errors do not have a literal form, they are created by catching them.
+func (v errorValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v errorValue) Str() string {
+	return fmt.Sprintf("#<error: %s>", v.err)
+}
+
+func (v errorValue) newValue(str string) Value {
+	return nil
+}
+
+func newErrorValue(err error) Value {
+	var val errorValue
+	val.err = err
+	return val
+}
+
+// An expression whose evaluation is delayed until it is forced. Copies of a
+// promise share its state, so it is evaluated at most once.
+type promiseValue struct {
+	p *promise
+}
+
+type promise struct {
+	// Guards the state of the promise, which can be forced by spawned tasks.
+	mu sync.Mutex
+	// Computes the value of the promise for the environment forcing it. It is
+	// dropped once the promise has been forced, along with the environment it
+	// might refer to.
+	compute func(forcer *LangEnv) Atom
+	forced  bool
+	value   Value
+	// Set while the promise is being evaluated, and closed when the evaluation
+	// is done, so that tasks forcing the promise meanwhile wait for it.
+	evaluating chan struct{}
+}
+
+func (v promiseValue) getValueType() valueType {
+	return promiseType
+}
+
+func (v promiseValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case promiseType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Promises do not have a literal form, they are created using delay.
+func (v promiseValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v promiseValue) Str() string {
+	return v.str(nil)
+}
+
+// The value is printed without holding the lock, since it can contain the
+// promise itself.
+func (v promiseValue) str(visiting map[interface{}]bool) string {
+	v.p.mu.Lock()
+	forced, value := v.p.forced, v.p.value
+	v.p.mu.Unlock()
+	if !forced {
+		return "#<promise>"
+	}
+	return strOfCell(v.p, visiting, func(visiting map[interface{}]bool) string {
+		return fmt.Sprintf("#<promise: %s>", strOf(value, visiting))
+	})
+}
+
+func (v promiseValue) newValue(str string) Value {
+	return nil
+}
+
+// A lazy list, whose tail is only computed when it is needed. The tail is
+// either another stream, or nil at the end of the stream.
+type streamValue struct {
+	head Value
+	tail promiseValue
+}
+
+func (v streamValue) getValueType() valueType {
+	return streamType
+}
+
+func (v streamValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case streamType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Streams do not have a literal form, they are created using stream-cons.
+func (v streamValue) ofType(targetValue string) bool {
+	return false
+}
+
+// Only the head is printed, since printing the rest would have to compute it.
+func (v streamValue) Str() string {
+	return v.str(nil)
+}
+
+func (v streamValue) str(visiting map[interface{}]bool) string {
+	return fmt.Sprintf("#<stream: %s ...>", strOf(v.head, visiting))
+}
+
+func (v streamValue) newValue(str string) Value {
+	return nil
+}
+
+// The result of an expression which is being evaluated by a spawned task.
+// Copies of a future share its state.
+type futureValue struct {
+	f *future
+}
+
+type future struct {
+	// Closed once the task is done, after which result is set.
+	done   chan struct{}
+	result Atom
+}
+
+func (v futureValue) getValueType() valueType {
+	return futureType
+}
+
+func (v futureValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case futureType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Futures do not have a literal form, they are created using spawn.
+func (v futureValue) ofType(targetValue string) bool {
+	return false
+}
+
+// The result is only printed if the task is done, since printing should not
+// block.
+func (v futureValue) Str() string {
+	return v.str(nil)
+}
+
+func (v futureValue) str(visiting map[interface{}]bool) string {
+	select {
+	case <-v.f.done:
+		if v.f.result.Err == nil {
+			return strOfCell(v.f, visiting, func(visiting map[interface{}]bool) string {
+				return fmt.Sprintf("#<future: %s>", strOf(v.f.result.Val, visiting))
+			})
+		}
+	default:
+	}
+	return "#<future>"
+}
+
+func (v futureValue) newValue(str string) Value {
+	return nil
+}
+
+// A Go channel, through which tasks can pass values to each other. Since
+// values are immutable, they can be shared by the tasks once they are sent.
+type channelValue struct {
+	c *channel
+}
+
+type channel struct {
+	values chan Value
+	mu     sync.Mutex
+	closed bool
+}
+
+func (v channelValue) getValueType() valueType {
+	return channelType
+}
+
+func (v channelValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case channelType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Channels do not have a literal form, they are created using make-channel.
+func (v channelValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v channelValue) Str() string {
+	return "#<channel>"
+}
+
+func (v channelValue) newValue(str string) Value {
+	return nil
+}
+
+// A reference to a value, which can be changed atomically by swap!, so that it
+// can hold state shared by tasks. Copies of an atom share its state.
+type atomValue struct {
+	a *atomState
+}
+
+type atomState struct {
+	mu    sync.Mutex
+	value Value
+	// Incremented every time the value changes, to detect concurrent changes.
+	version uint64
+}
+
+func (v atomValue) getValueType() valueType {
+	return atomType
+}
+
+func (v atomValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case atomType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Atoms do not have a literal form, they are created using atom.
+func (v atomValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v atomValue) Str() string {
+	return v.str(nil)
+}
+
+func (v atomValue) str(visiting map[interface{}]bool) string {
+	value, _ := v.a.get()
+	return strOfCell(v.a, visiting, func(visiting map[interface{}]bool) string {
+		return fmt.Sprintf("#<atom: %s>", strOf(value, visiting))
+	})
+}
+
+func (v atomValue) newValue(str string) Value {
+	return nil
+}
+
+// Raw bytes, like a serialized value. The bytes are never changed once the
+// blob has been created.
+type blobValue struct {
+	data []byte
+}
+
+func (v blobValue) getValueType() valueType {
+	return blobType
+}
+
+func (v blobValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case blobType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Blobs do not have a literal form, they are created using serialize.
+func (v blobValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v blobValue) Str() string {
+	return fmt.Sprintf("#<blob: %d bytes>", len(v.data))
+}
+
+func (v blobValue) newValue(str string) Value {
+	return nil
+}
+
+// A function created at runtime, like the ones returned by partial. Unlike
+// methods, functions are not registered under a name, and are called through
+// the values referring to them.
+type funcValue struct {
+	op *Operator
+}
+
+func (v funcValue) getValueType() valueType {
+	return funcType
+}
+
+func (v funcValue) to(targetType valueType) (Value, error) {
+	switch targetType {
+	case funcType:
+		return v, nil
+	}
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+// Functions do not have a literal form, they are created by operators.
+func (v funcValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v funcValue) Str() string {
+	return fmt.Sprintf("#<function %s>", v.op.symbol)
+}
+
+func (v funcValue) newValue(str string) Value {
+	return nil
+}
+
+// Returns a function, which accepts any number of arguments, and calls the
+// handler with them.
+func newFuncValue(name string, handler func(*LangEnv, []Atom) Atom) Value {
+	return funcValue{&Operator{symbol: name, minArgCount: 0, maxArgCount: 100, handler: handler}}
+}
+
+// The arguments of a recur. It is returned from the tail position of a method,
+// which then starts over with the arguments bound to its parameters.
+type recurValue struct {
+	args []Value
+}
+
+func (v recurValue) getValueType() valueType {
+	return recurType
+}
+
+func (v recurValue) to(targetType valueType) (Value, error) {
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+func (v recurValue) ofType(targetValue string) bool {
+	return false
+}
+
+func (v recurValue) Str() string {
+	return "#<recur>"
+}
+
+func (v recurValue) newValue(str string) Value {
+	return nil
+}
+
+// Multiple values returned from a single expression. Unlike a list, these
+// are not a single value, and have to be received using let-values or
+// call-with-values.
+type multipleValues struct {
+	values []Value
+}
+
+func (v multipleValues) getValueType() valueType {
+	return valuesType
+}
+
+func (v multipleValues) to(targetType valueType) (Value, error) {
+	return nil, typeConvError(v.getValueType(), targetType)
+}
+
+func (v multipleValues) ofType(targetValue string) bool {
+	return false
+}
+
+func (v multipleValues) Str() string {
+	return v.str(nil)
+}
+
+func (v multipleValues) str(visiting map[interface{}]bool) string {
+	return strings.Join(strsOf(v.values, visiting), " ")
+}
+
+func (v multipleValues) newValue(str string) Value {
+	return nil
+}
+
+// A single value is returned as is, instead of being wrapped.
+func newMultipleValues(values []Value) Value {
+	if len(values) == 1 {
+		return values[0]
+	}
+	var val multipleValues
+	val.values = values
+	return val
+}
+
+// Returns the values carried by v, which is a single value unless v holds
+// multiple values.
+func valuesOf(v Value) []Value {
+	if mv, ok := v.(multipleValues); ok {
+		return mv.values
+	}
+	return []Value{v}
+}
+
 type astValue struct {
 	astNodes      []*ASTNode
 	parentASTNode *ASTNode
@@ -367,5 +1535,18 @@ func newASTValue(parentNode *ASTNode) Value {
 type method struct {
 	methodName string
 	params     []string
-	ast        *ASTNode
+	// Expressions for the default values of the optional parameters.
+	defaults map[string]*ASTNode
+	// The patterns which destructure the arguments of the parameters named
+	// after them.
+	patterns map[string]*ASTNode
+	ast      *ASTNode
+	// The doc string given before the body, if any.
+	doc string
+	// The expression which defined the method, if it was defined using defun or
+	// lambda.
+	source *ASTNode
+	// The environment which a lambda was created in. Methods defined using
+	// defun do not have one, and are evaluated in the caller's environment.
+	env *LangEnv
 }
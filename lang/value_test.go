@@ -2,6 +2,7 @@ package lang
 
 import (
 	"fmt"
+	"math"
 	"testing"
 )
 
@@ -78,6 +79,12 @@ func TestfloatValue(t *testing.T) {
 	cases = append(cases, TestPair{"1", true})
 	cases = append(cases, TestPair{"-1", true})
 	cases = append(cases, TestPair{"foobar", false})
+	cases = append(cases, TestPair{"nan", true})
+	cases = append(cases, TestPair{"inf", true})
+	cases = append(cases, TestPair{"-inf", true})
+	cases = append(cases, TestPair{"NaN", false})
+	cases = append(cases, TestPair{"+Inf", false})
+	cases = append(cases, TestPair{"infinity", false})
 	doTypeChecks(fv, cases, t)
 
 	strCases := make([]TestPair, 0)
@@ -89,10 +96,16 @@ func TestfloatValue(t *testing.T) {
 	strCases = append(strCases, TestPair{fv.Str(), "-1.1"})
 	fv.value = -1.23456789
 	strCases = append(strCases, TestPair{fv.Str(), "-1.23456789"})
+	fv.value = math.Inf(1)
+	strCases = append(strCases, TestPair{fv.Str(), "inf"})
+	fv.value = math.Inf(-1)
+	strCases = append(strCases, TestPair{fv.Str(), "-inf"})
+	fv.value = math.NaN()
+	strCases = append(strCases, TestPair{fv.Str(), "nan"})
 	doChecks(fv, strCases, t)
 }
 
-func TeststringValue(t *testing.T) {
+func TestStringValue(t *testing.T) {
 	sv := new(stringValue)
 	cases := make([]TestPair, 0)
 	cases = append(cases, TestPair{"", false})
@@ -101,6 +114,16 @@ func TeststringValue(t *testing.T) {
 	cases = append(cases, TestPair{"\"\"", true})
 	cases = append(cases, TestPair{"\"abc\"", true})
 	cases = append(cases, TestPair{"1.2", false})
+	cases = append(cases, TestPair{"'", false})
+	cases = append(cases, TestPair{"\"abc'", false})
+	cases = append(cases, TestPair{"'abc\"", false})
+	cases = append(cases, TestPair{"'''", false})
+	cases = append(cases, TestPair{"\"a\"b\"", false})
+	cases = append(cases, TestPair{"\"a\\\"b\"", true})
+	cases = append(cases, TestPair{"\"abc\\\"", false})
+	cases = append(cases, TestPair{"\"abc\\\\\"", true})
+	cases = append(cases, TestPair{"'it\"s'", true})
+	cases = append(cases, TestPair{"\"it's\"", true})
 	doTypeChecks(sv, cases, t)
 
 	strCases := make([]TestPair, 0)
@@ -138,3 +161,130 @@ func TestTypeInfer(t *testing.T) {
 		t.Errorf("Could not correctly getValue(1)")
 	}
 }
+
+func TestKeywordValue(t *testing.T) {
+	kv := new(keywordValue)
+	cases := make([]TestPair, 0)
+	cases = append(cases, TestPair{":x", true})
+	cases = append(cases, TestPair{":foo-bar", true})
+	cases = append(cases, TestPair{":", false})
+	cases = append(cases, TestPair{"x", false})
+	cases = append(cases, TestPair{":1", false})
+	doTypeChecks(kv, cases, t)
+
+	strCases := make([]TestPair, 0)
+	strCases = append(strCases, TestPair{kv.newValue(":foo").Str(), ":foo"})
+	doChecks(kv, strCases, t)
+}
+
+func TestBigFloatValue(t *testing.T) {
+	bv := new(bigFloatValue)
+	cases := make([]TestPair, 0)
+	cases = append(cases, TestPair{"1.2M", true})
+	cases = append(cases, TestPair{"-1M", true})
+	cases = append(cases, TestPair{"1e10M", true})
+	cases = append(cases, TestPair{"1.2", false})
+	cases = append(cases, TestPair{"M", false})
+	cases = append(cases, TestPair{"InfM", false})
+	cases = append(cases, TestPair{"fooM", false})
+	doTypeChecks(bv, cases, t)
+
+	strCases := make([]TestPair, 0)
+	strCases = append(strCases, TestPair{bv.newValue("2.675M").Str(), "2.675"})
+	strCases = append(strCases, TestPair{bv.newValue("-0.1M").Str(), "-0.1"})
+	doChecks(bv, strCases, t)
+
+	var fv floatValue
+	fv.value = 2.675
+	conv, err := fv.to(bigFloatType)
+	if err != nil || conv.getValueType() != bigFloatType || conv.Str() != "2.675" {
+		t.Errorf("Could not convert from floatType to bigFloatType")
+	}
+}
+
+func TestRadixLiterals(t *testing.T) {
+	iv := new(intValue)
+	cases := make([]TestPair, 0)
+	cases = append(cases, TestPair{"16r1F", true})
+	cases = append(cases, TestPair{"16r1f", true})
+	cases = append(cases, TestPair{"2r1010", true})
+	cases = append(cases, TestPair{"-36rZZ", true})
+	cases = append(cases, TestPair{"2r102", false})
+	cases = append(cases, TestPair{"1r0", false})
+	cases = append(cases, TestPair{"37r1", false})
+	cases = append(cases, TestPair{"16r", false})
+	cases = append(cases, TestPair{"r1F", false})
+	cases = append(cases, TestPair{"16r1FFFFFFFFFFFFFFFF", false})
+	doTypeChecks(iv, cases, t)
+
+	bv := new(bigIntValue)
+	cases = make([]TestPair, 0)
+	cases = append(cases, TestPair{"16r1FFFFFFFFFFFFFFFF", true})
+	cases = append(cases, TestPair{"2r102", false})
+	doTypeChecks(bv, cases, t)
+
+	strCases := make([]TestPair, 0)
+	strCases = append(strCases, TestPair{iv.newValue("16r1F").Str(), "31"})
+	strCases = append(strCases, TestPair{iv.newValue("2r1010").Str(), "10"})
+	strCases = append(strCases, TestPair{iv.newValue("-36rZZ").Str(), "-1295"})
+	strCases = append(strCases, TestPair{bv.newValue("16r1FFFFFFFFFFFFFFFF").Str(), "36893488147419103231"})
+	doChecks(iv, strCases, t)
+
+	env := new(LangEnv)
+	env.Init()
+	checkExprResultTest("(+ 16r1F 2r1)", "32", t, env)
+	malformedExprTest("(+ 2r12 1)", t, env)
+}
+
+func TestNumericTypeOrdering(t *testing.T) {
+	env := new(LangEnv)
+	env.Init()
+	cases := []TestPair{
+		{"5", intType},
+		{"-5", intType},
+		{"9223372036854775807", intType},
+		{"-9223372036854775808", intType},
+		{"9223372036854775808", bigIntType},
+		{"-9223372036854775809", bigIntType},
+		{"0x7FFFFFFFFFFFFFFF", intType},
+		{"0x8000000000000000", bigIntType},
+		{"5.0", floatType},
+		{"5.0M", bigFloatType},
+	}
+	for _, p := range cases {
+		token, _ := p.a.(string)
+		v, e := getValue(env, token)
+		if e != nil || v.getValueType() != p.b {
+			t.Errorf("Expected %s to be of type %s, got %v (err: %v)", token, p.b, v, e)
+		}
+	}
+}
+
+func TestCharValue(t *testing.T) {
+	checkOfType("#\\a", new(charValue), t)
+	checkOfType("#\\space", new(charValue), t)
+	checkOfType("#\\世", new(charValue), t)
+	checkNotOfType("#\\ab", new(charValue), t)
+	checkNotOfType("#\\", new(charValue), t)
+	checkNotOfType("a", new(charValue), t)
+}
+
+func TestVarValue(t *testing.T) {
+	checkOfType("x", new(varValue), t)
+	checkOfType("foo-bar?", new(varValue), t)
+	checkOfType("número", new(varValue), t)
+	checkOfType("λ", new(varValue), t)
+	checkOfType("变量2", new(varValue), t)
+	checkNotOfType("+", new(varValue), t)
+	checkNotOfType("<=", new(varValue), t)
+	checkNotOfType("12", new(varValue), t)
+	checkNotOfType("", new(varValue), t)
+
+	env := new(LangEnv)
+	env.Init()
+	checkExprResultTest("(defvar número 3)", "3", t, env)
+	checkExprResultTest("(* número 2)", "6", t, env)
+	checkExprResultTest("(defun λ (x) (+ x 1))", "<Method: λ>", t, env)
+	checkExprResultTest("(λ 1)", "2", t, env)
+	checkExprResultTest(":größe", ":größe", t, env)
+}
@@ -0,0 +1,99 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	values         string = "values"
+	callWithValues string = "call-with-values"
+	letValues      string = "let-values"
+)
+
+// Returns an error if v holds multiple values, which cannot be passed to an
+// operator or kept in a container, and have to be received using let-values or
+// call-with-values instead.
+func checkSingleValue(symbol string, v Value) error {
+	if mv, ok := v.(multipleValues); ok {
+		return errors.New(fmt.Sprintf("For %s, expected a single value, got the multiple values %s",
+			symbol, mv.Str()))
+	}
+	return nil
+}
+
+func addValuesOperators(opMap map[string]*Operator) {
+	addOperator(opMap,
+		&Operator{
+			symbol:         values,
+			minArgCount:    0,
+			maxArgCount:    100,
+			keepsConsCells: true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				vals := make([]Value, len(operands))
+				for i, o := range operands {
+					vals[i] = o.Val
+				}
+				retVal.Val = newMultipleValues(vals)
+				return retVal
+			},
+		},
+	)
+
+	// Calls the producer without any arguments, and passes the values it
+	// returns as arguments to the consumer.
+	addOperator(opMap,
+		&Operator{
+			symbol:      callWithValues,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				produced := callOperator(env, operands[0].Val, []Value{})
+				if produced.Err != nil {
+					return produced
+				}
+				return callOperator(env, operands[1].Val, valuesOf(produced.Val))
+			},
+		},
+	)
+
+	// Like let, except that every binding is of the format
+	// `((pattern...) value)`, and the patterns are bound to the multiple values
+	// returned by the expression.
+	addOperator(opMap,
+		&Operator{
+			symbol:      letValues,
+			minArgCount: 2,
+			maxArgCount: 100,
+			passRawAST:  true,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				astVal, _ := operands[0].Val.(astValue)
+				bindingsNode := astVal.astNodes[0]
+				if bindingsNode.isValue {
+					retVal.Err = errors.New(fmt.Sprintf("Missing list of bindings for %s", letValues))
+					return retVal
+				}
+
+				newEnv := env.newChildEnv()
+				for _, binding := range bindingsNode.children {
+					if binding.isValue || len(binding.children) != 2 || binding.children[0].isValue {
+						retVal.Err = errors.New(fmt.Sprintf(
+							"Bindings for %s should be of the format `((pattern...) value)`.", letValues))
+						return retVal
+					}
+					result := evalASTHelper(env, binding.children[1])
+					if result.Err != nil {
+						return result
+					}
+					retVal.Err = bindPattern(newEnv, binding.children[0], newListValue(valuesOf(result.Val)))
+					if retVal.Err != nil {
+						return retVal
+					}
+				}
+				return evalASTs(newEnv, astVal.astNodes[1:])
+			},
+		},
+	)
+}
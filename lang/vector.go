@@ -0,0 +1,82 @@
+package lang
+
+import (
+	"errors"
+	"fmt"
+)
+
+const (
+	listToVector string = "list->vector"
+	vectorToList string = "vector->list"
+	vectorRef    string = "vector-ref"
+)
+
+func addVectorOperators(opMap map[string]*Operator) {
+	addOperator(opMap,
+		&Operator{
+			symbol:      listToVector,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				listVal, ok := operands[0].Val.(listValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a list",
+						listToVector, operands[0].Val.Str()))
+					return retVal
+				}
+				values := make([]Value, len(listVal.values))
+				copy(values, listVal.values)
+				retVal.Val = newVectorValue(values)
+				return retVal
+			},
+		},
+	)
+
+	addOperator(opMap,
+		&Operator{
+			symbol:      vectorToList,
+			minArgCount: 1,
+			maxArgCount: 1,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				vectorVal, ok := operands[0].Val.(vectorValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a vector",
+						vectorToList, operands[0].Val.Str()))
+					return retVal
+				}
+				values := make([]Value, len(vectorVal.values))
+				copy(values, vectorVal.values)
+				retVal.Val = newListValue(values)
+				return retVal
+			},
+		},
+	)
+
+	// Returns the element at the index, starting from 0.
+	addOperator(opMap,
+		&Operator{
+			symbol:      vectorRef,
+			minArgCount: 2,
+			maxArgCount: 2,
+			handler: func(env *LangEnv, operands []Atom) Atom {
+				var retVal Atom
+				vectorVal, ok := operands[0].Val.(vectorValue)
+				if !ok {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be a vector",
+						vectorRef, operands[0].Val.Str()))
+					return retVal
+				}
+				index, ok := operands[1].Val.(intValue)
+				if !ok || index.value < 0 || index.value >= int64(len(vectorVal.values)) {
+					retVal.Err = errors.New(fmt.Sprintf("For %s, expected %s to be an index between 0 and %d",
+						vectorRef, operands[1].Val.Str(), len(vectorVal.values)-1))
+					return retVal
+				}
+				retVal.Val = vectorVal.values[index.value]
+				return retVal
+			},
+		},
+	)
+}